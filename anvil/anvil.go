@@ -0,0 +1,262 @@
+// Package anvil 读取 Minecraft Java 版的 .mca 区域文件 (1.13 以后的方块调色板格式)
+package anvil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/humboldt-xie/tinycraft/nbt"
+)
+
+const (
+	sectorSize   = 4096
+	sectionSize  = 16 * 16 * 16
+	regionChunks = 32
+
+	compressGzip = 1
+	compressZlib = 2
+	compressNone = 3
+
+	// 1.16 (20w17a) 之后 long 数组中的索引不再跨越两个 long
+	dataVersionPadded = 2529
+)
+
+var (
+	ErrBadRegion = errors.New("anvil: bad region file")
+	ErrBadChunk  = errors.New("anvil: bad chunk")
+	// ErrLegacyChunk 1.13 之前的数字 id 格式, 不支持
+	ErrLegacyChunk = errors.New("anvil: legacy chunk format")
+)
+
+// Section 16x16x16 的方块, Indexes 按 y*256+z*16+x 排列, 值为 Palette 的下标
+type Section struct {
+	Y       int
+	Palette []string
+	Indexes []int
+}
+
+type Chunk struct {
+	X, Z     int
+	Sections []*Section
+}
+
+// Range 遍历非空气方块, x y z 为世界坐标
+func (c *Chunk) Range(f func(x, y, z int, name string)) {
+	for _, s := range c.Sections {
+		for i, idx := range s.Indexes {
+			name := s.Palette[idx]
+			if isAir(name) {
+				continue
+			}
+			x, z, y := i&15, (i>>4)&15, i>>8
+			f(c.X*16+x, s.Y*16+y, c.Z*16+z, name)
+		}
+	}
+}
+
+func isAir(name string) bool {
+	return name == "minecraft:air" || name == "minecraft:cave_air" || name == "minecraft:void_air"
+}
+
+type Region struct {
+	X, Z int
+	f    *os.File
+	// 每个 chunk 在文件中的位置, 0 表示不存在
+	offsets [regionChunks * regionChunks]uint32
+}
+
+// OpenRegion 打开 r.<x>.<z>.mca 文件
+func OpenRegion(path string) (*Region, error) {
+	r := &Region{}
+	_, err := fmt.Sscanf(filepath.Base(path), "r.%d.%d.mca", &r.X, &r.Z)
+	if err != nil {
+		return nil, fmt.Errorf("anvil: bad region file name %s", path)
+	}
+	r.f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Read(io.NewSectionReader(r.f, 0, sectorSize), binary.BigEndian, &r.offsets)
+	if err != nil {
+		r.f.Close()
+		return nil, ErrBadRegion
+	}
+	return r, nil
+}
+
+func (r *Region) Close() error {
+	return r.f.Close()
+}
+
+// Chunks 读取区域中所有 chunk, f 返回错误时停止
+// 单个 chunk 损坏时 bad 会被调用, 然后继续下一个
+func (r *Region) Chunks(f func(c *Chunk) error, bad func(x, z int, err error)) error {
+	for i, loc := range r.offsets {
+		if loc == 0 {
+			continue
+		}
+		x, z := r.X*regionChunks+i%regionChunks, r.Z*regionChunks+i/regionChunks
+		c, err := r.readChunk(loc)
+		if err != nil {
+			bad(x, z, err)
+			continue
+		}
+		err = f(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Region) readChunk(loc uint32) (*Chunk, error) {
+	offset := int64(loc>>8) * sectorSize
+	var header struct {
+		Length      uint32
+		Compression byte
+	}
+	err := binary.Read(io.NewSectionReader(r.f, offset, 5), binary.BigEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Length < 1 || header.Length > 1<<24 {
+		return nil, ErrBadChunk
+	}
+	data := io.NewSectionReader(r.f, offset+5, int64(header.Length-1))
+	var zr io.Reader
+	switch header.Compression {
+	case compressGzip:
+		zr, err = gzip.NewReader(data)
+	case compressZlib:
+		zr, err = zlib.NewReader(data)
+	case compressNone:
+		zr = data
+	default:
+		return nil, fmt.Errorf("%w: compression %d", ErrBadChunk, header.Compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return ReadChunk(bytes.NewReader(buf))
+}
+
+// ReadChunk 解析未压缩的 chunk NBT, 支持 1.13-1.17 (Level.Sections) 和 1.18 以后 (sections) 的格式
+func ReadChunk(rd io.Reader) (*Chunk, error) {
+	_, root, err := nbt.Read(rd)
+	if err != nil {
+		return nil, err
+	}
+	dataVersion, _ := root.Int("DataVersion")
+	level := root
+	if l, ok := root.Compound("Level"); ok {
+		level = l
+	}
+	c := &Chunk{}
+	c.X, _ = level.Int("xPos")
+	c.Z, _ = level.Int("zPos")
+	sections, ok := level.List("sections")
+	if !ok {
+		sections, ok = level.List("Sections")
+	}
+	if !ok {
+		return c, nil
+	}
+	for _, item := range sections.Items {
+		sc, ok := item.(nbt.Compound)
+		if !ok {
+			return nil, ErrBadChunk
+		}
+		s, err := readSection(sc, dataVersion)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			c.Sections = append(c.Sections, s)
+		}
+	}
+	return c, nil
+}
+
+func readSection(sc nbt.Compound, dataVersion int) (*Section, error) {
+	y, _ := sc.Int("Y")
+	palette, _ := sc.List("Palette")
+	states, _ := sc.Longs("BlockStates")
+	if bs, ok := sc.Compound("block_states"); ok {
+		palette, _ = bs.List("palette")
+		states, _ = bs.Longs("data")
+	}
+	if len(palette.Items) == 0 {
+		if _, ok := sc.Bytes("Blocks"); ok {
+			return nil, ErrLegacyChunk
+		}
+		// 空的 section, 例如只有光照数据
+		return nil, nil
+	}
+	s := &Section{Y: int(int8(y)), Indexes: make([]int, sectionSize)}
+	for _, item := range palette.Items {
+		p, ok := item.(nbt.Compound)
+		if !ok {
+			return nil, ErrBadChunk
+		}
+		name, _ := p.String("Name")
+		s.Palette = append(s.Palette, name)
+	}
+	if len(s.Palette) == 1 {
+		return s, nil
+	}
+	err := unpack(s.Indexes, states, len(s.Palette), dataVersion >= dataVersionPadded)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// unpack 从 long 数组中解出调色板下标, 每个下标至少 4 bit
+func unpack(dst []int, data []int64, paletteLen int, padded bool) error {
+	bits := 4
+	for 1<<uint(bits) < paletteLen {
+		bits++
+	}
+	mask := uint64(1)<<uint(bits) - 1
+	if padded {
+		perLong := 64 / bits
+		if len(data) < (len(dst)+perLong-1)/perLong {
+			return ErrBadChunk
+		}
+		for i := range dst {
+			v := uint64(data[i/perLong]) >> uint(i%perLong*bits) & mask
+			dst[i] = int(v)
+		}
+	} else {
+		if len(data)*64 < len(dst)*bits {
+			return ErrBadChunk
+		}
+		for i := range dst {
+			bit := i * bits
+			idx, off := bit/64, uint(bit%64)
+			v := uint64(data[idx]) >> off
+			if off+uint(bits) > 64 {
+				v |= uint64(data[idx+1]) << (64 - off)
+			}
+			dst[i] = int(v & mask)
+		}
+	}
+	for _, v := range dst {
+		if v >= paletteLen {
+			return fmt.Errorf("%w: palette index %d", ErrBadChunk, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package anvil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/humboldt-xie/tinycraft/nbt"
+)
+
+func pack(indexes []int, bits int, padded bool) []int64 {
+	var data []int64
+	if padded {
+		perLong := 64 / bits
+		data = make([]int64, (len(indexes)+perLong-1)/perLong)
+		for i, v := range indexes {
+			data[i/perLong] |= int64(uint64(v) << uint(i%perLong*bits))
+		}
+		return data
+	}
+	data = make([]int64, (len(indexes)*bits+63)/64)
+	for i, v := range indexes {
+		bit := i * bits
+		idx, off := bit/64, uint(bit%64)
+		data[idx] |= int64(uint64(v) << off)
+		if off+uint(bits) > 64 {
+			data[idx+1] |= int64(uint64(v) >> (64 - off))
+		}
+	}
+	return data
+}
+
+func TestUnpack(t *testing.T) {
+	indexes := make([]int, sectionSize)
+	for i := range indexes {
+		indexes[i] = i * 7 % 20
+	}
+	for _, padded := range []bool{true, false} {
+		got := make([]int, sectionSize)
+		err := unpack(got, pack(indexes, 5, padded), 20, padded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range got {
+			if got[i] != indexes[i] {
+				t.Fatalf("padded=%v index %d: got %d want %d", padded, i, got[i], indexes[i])
+			}
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	indexes := make([]int, sectionSize)
+	// (1, 2, 3) 为石头, 其余为空气
+	indexes[2*256+3*16+1] = 1
+	chunk := nbt.Compound{
+		"DataVersion": int32(2860),
+		"xPos":        int32(-31),
+		"zPos":        int32(2),
+		"sections": nbt.List{Type: nbt.TagCompound, Items: []interface{}{
+			nbt.Compound{
+				"Y": int8(-1),
+				"block_states": nbt.Compound{
+					"palette": nbt.List{Type: nbt.TagCompound, Items: []interface{}{
+						nbt.Compound{"Name": "minecraft:air"},
+						nbt.Compound{"Name": "minecraft:stone"},
+					}},
+					"data": pack(indexes, 4, true),
+				},
+			},
+		}},
+	}
+	var raw bytes.Buffer
+	zw := zlib.NewWriter(&raw)
+	err := nbt.Write(zw, "", chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	// 区域 r.-1.0 中的 (1, 2) 号 chunk, 放在第 2 个扇区
+	var file bytes.Buffer
+	offsets := make([]uint32, 2048)
+	offsets[2*regionChunks+1] = 2<<8 | 1
+	binary.Write(&file, binary.BigEndian, offsets)
+	binary.Write(&file, binary.BigEndian, uint32(raw.Len()+1))
+	file.WriteByte(compressZlib)
+	file.Write(raw.Bytes())
+
+	dir, err := ioutil.TempDir("", "anvil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "r.-1.0.mca")
+	err = ioutil.WriteFile(path, file.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenRegion(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var found []string
+	err = r.Chunks(func(c *Chunk) error {
+		c.Range(func(x, y, z int, name string) {
+			if x != -31*16+1 || y != -16+2 || z != 2*16+3 {
+				t.Errorf("block at %d %d %d", x, y, z)
+			}
+			found = append(found, name)
+		})
+		return nil
+	}, func(x, z int, err error) {
+		t.Errorf("chunk %d,%d: %s", x, z, err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != "minecraft:stone" {
+		t.Fatalf("got %v", found)
+	}
+}
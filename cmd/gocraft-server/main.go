@@ -0,0 +1,77 @@
+// gocraft-server 不需要 OpenGL 的服务器, 运行世界并接受游戏客户端的连接
+//
+//	gocraft-server -db server.db -l :8421
+//
+// SIGINT 或 SIGTERM 时断开所有客户端, 保存玩家和世界后退出.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/humboldt-xie/tinycraft/rpc"
+	_ "github.com/humboldt-xie/tinycraft/schematic"
+	"github.com/humboldt-xie/tinycraft/vox"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+var tps = flag.Int("tps", 20, "world updates per second")
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	// 服务器默认监听游戏客户端使用的端口
+	flag.Set("l", ":8421")
+	flag.Parse()
+	if *tps <= 0 {
+		log.Fatal("-tps must be positive")
+	}
+
+	// 和客户端一样生成地形
+	err := vox.LoadStructures()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = world.InitStore()
+	if errors.Is(err, world.ErrMigrateDryRun) {
+		log.Print(err)
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer world.CloseStore()
+	world.StartBackups()
+
+	w := world.NewWorld(2)
+	server, err := rpc.InitService(w)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if server == nil {
+		log.Fatal("empty listen address")
+	}
+	log.Printf("serving %s on %s", w.Dimension().Name, flag.Lookup("l").Value)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Second / time.Duration(*tps))
+	defer ticker.Stop()
+	prev := time.Now()
+	for running := true; running; {
+		select {
+		case now := <-ticker.C:
+			w.Update(now.Sub(prev).Seconds())
+			prev = now
+		case s := <-sig:
+			log.Printf("%s, shutting down", s)
+			running = false
+		}
+	}
+	server.Close()
+	w.Save()
+}
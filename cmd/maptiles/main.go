@@ -0,0 +1,183 @@
+// maptiles 不启动游戏, 把存档渲染为俯视地图瓦片和一张总览图
+//
+//	maptiles -db gocraft.db -from -512,-512 -to 511,511 -o map
+//
+// 输出 map/<level>/<x>_<z>.png, level 0 一个像素一个方块, level n 一个像素 2^n*2^n 个方块,
+// 每张瓦片 256x256 像素, x z 为瓦片坐标. map/overview.png 为整个区域的总览图.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/draw"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/humboldt-xie/tinycraft/render/maptile"
+	"github.com/humboldt-xie/tinycraft/render/tex"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+const tileSize = 256
+
+var (
+	from         = flag.String("from", "", "first corner of the region, x,z")
+	to           = flag.String("to", "", "second corner of the region, x,z")
+	output       = flag.String("o", "map", "output directory")
+	overviewSize = flag.Int("overview", 2048, "max width and height of overview.png")
+)
+
+type tileKey struct {
+	level, x, z int
+}
+
+// floorDiv 向下取整的除法, 负坐标的瓦片也是连续的
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+func parseXZ(s string) (int, int, error) {
+	var x, z int
+	_, err := fmt.Sscanf(strings.Replace(s, " ", "", -1), "%d,%d", &x, &z)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad position %q, want x,z", s)
+	}
+	return x, z, nil
+}
+
+// tiler 瓦片写入磁盘后不保留在内存中, 大地图也只占用几张瓦片的内存
+type tiler struct {
+	mr  *maptile.MapRender
+	dir string
+}
+
+func (t *tiler) path(k tileKey) string {
+	return filepath.Join(t.dir, fmt.Sprint(k.level), fmt.Sprintf("%d_%d.png", k.x, k.z))
+}
+
+func (t *tiler) save(k tileKey, img image.Image) error {
+	err := os.MkdirAll(filepath.Dir(t.path(k)), 0755)
+	if err != nil {
+		return err
+	}
+	return imaging.Save(img, t.path(k))
+}
+
+func (t *tiler) load(k tileKey) (image.Image, bool) {
+	img, err := imaging.Open(t.path(k))
+	return img, err == nil
+}
+
+// merge 把下一级的 2x2 张瓦片缩小为一张
+func (t *tiler) merge(k tileKey) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, tileSize*2, tileSize*2))
+	for dz := 0; dz < 2; dz++ {
+		for dx := 0; dx < 2; dx++ {
+			child, ok := t.load(tileKey{k.level - 1, k.x*2 + dx, k.z*2 + dz})
+			if !ok {
+				continue
+			}
+			draw.Draw(img, image.Rect(dx*tileSize, dz*tileSize, (dx+1)*tileSize, (dz+1)*tileSize), child, image.Point{}, draw.Src)
+		}
+	}
+	return imaging.Resize(img, tileSize, tileSize, imaging.Box)
+}
+
+func main() {
+	flag.Parse()
+	x0, z0, err := parseXZ(*from)
+	if err != nil {
+		log.Fatal(err)
+	}
+	x1, z1, err := parseXZ(*to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if x0 > x1 {
+		x0, x1 = x1, x0
+	}
+	if z0 > z1 {
+		z0, z1 = z1, z0
+	}
+
+	atlas, _, err := tex.LoadImage(*tex.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = tex.LoadTextureDesc()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = world.InitStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer world.CloseStore()
+
+	// 一张瓦片 16x16 个 chunk, 加上北边一行用于阴影
+	w := world.NewWorld(5)
+	t := &tiler{mr: maptile.NewMapRender(w, atlas), dir: *output}
+
+	tx0, tz0 := floorDiv(x0, tileSize), floorDiv(z0, tileSize)
+	tx1, tz1 := floorDiv(x1, tileSize), floorDiv(z1, tileSize)
+	for tz := tz0; tz <= tz1; tz++ {
+		for tx := tx0; tx <= tx1; tx++ {
+			img := t.mr.Render(tx*tileSize, tz*tileSize, tileSize, tileSize)
+			err := t.save(tileKey{0, tx, tz}, img)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		log.Printf("level 0: row %d/%d", tz-tz0+1, tz1-tz0+1)
+	}
+
+	// 逐级缩小直到整个区域不超过 2x2 张瓦片 (跨越原点的区域不会缩小为一张)
+	level := 0
+	for tx1-tx0 > 1 || tz1-tz0 > 1 {
+		level++
+		tx0, tz0 = floorDiv(tx0, 2), floorDiv(tz0, 2)
+		tx1, tz1 = floorDiv(tx1, 2), floorDiv(tz1, 2)
+		for tz := tz0; tz <= tz1; tz++ {
+			for tx := tx0; tx <= tx1; tx++ {
+				k := tileKey{level, tx, tz}
+				err := t.save(k, t.merge(k))
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		log.Printf("level %d: %d tiles", level, (tx1-tx0+1)*(tz1-tz0+1))
+	}
+
+	// 总览图使用分辨率不超过 overview 的最精细一级, 再裁剪到请求的区域
+	l := 0
+	for l < level && ((x1-x0+1)>>uint(l) > *overviewSize || (z1-z0+1)>>uint(l) > *overviewSize) {
+		l++
+	}
+	scale := 1 << uint(l)
+	ox0, oz0 := floorDiv(x0, scale), floorDiv(z0, scale)
+	ox1, oz1 := floorDiv(x1, scale), floorDiv(z1, scale)
+	overview := image.NewNRGBA(image.Rect(0, 0, ox1-ox0+1, oz1-oz0+1))
+	for tz := floorDiv(oz0, tileSize); tz <= floorDiv(oz1, tileSize); tz++ {
+		for tx := floorDiv(ox0, tileSize); tx <= floorDiv(ox1, tileSize); tx++ {
+			img, ok := t.load(tileKey{l, tx, tz})
+			if !ok {
+				continue
+			}
+			at := image.Pt(tx*tileSize-ox0, tz*tileSize-oz0)
+			draw.Draw(overview, image.Rectangle{at, at.Add(image.Pt(tileSize, tileSize))}, img, image.Point{}, draw.Src)
+		}
+	}
+	err = imaging.Save(overview, filepath.Join(*output, "overview.png"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("overview %dx%d, %d blocks per pixel", overview.Bounds().Dx(), overview.Bounds().Dy(), scale*scale)
+}
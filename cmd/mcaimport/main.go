@@ -0,0 +1,130 @@
+// mcaimport 把 Minecraft Java 版的区域文件 (.mca) 导入到 gocraft 的存档
+//
+//	mcaimport -db gocraft.db -blockmap mods/blockmap.yaml world/region/r.0.0.mca ...
+//	mcaimport -db gocraft.db world/region
+//	mcaimport -db gocraft.db -dim nether world/DIM-1/region
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/humboldt-xie/tinycraft/anvil"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+var (
+	dx   = flag.Int("dx", 0, "x offset added to imported blocks, multiple of 16")
+	dy   = flag.Int("dy", -48, "y offset added to imported blocks")
+	dz   = flag.Int("dz", 0, "z offset added to imported blocks, multiple of 16")
+	minY = flag.Int("miny", 0, "skip blocks below this height (after offset)")
+	maxY = flag.Int("maxy", 255, "skip blocks above this height (after offset)")
+)
+
+func regionFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "r.*.*.mca"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+type stats struct {
+	chunks, blocks, bad int
+	unknown             map[string]int
+}
+
+func importRegion(w *world.World, path string, m *world.BlockMap, st *stats) error {
+	r, err := anvil.OpenRegion(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	offset := world.Vec3{X: *dx, Y: *dy, Z: *dz}
+	return r.Chunks(func(c *anvil.Chunk) error {
+		cid := world.Vec3{X: c.X + *dx/world.ChunkWidth, Z: c.Z + *dz/world.ChunkWidth}
+		blocks := make(map[world.Vec3]*world.Block)
+		c.Range(func(x, y, z int, name string) {
+			id := world.Vec3{X: x, Y: y, Z: z}.Add(offset)
+			if id.Y < *minY || id.Y > *maxY {
+				return
+			}
+			tp, ok := m.ID(name)
+			if !ok || (tp != world.TypeAir && !world.IsBlockType(tp)) {
+				st.unknown[name]++
+				return
+			}
+			if tp != world.TypeAir {
+				blocks[id] = world.NewBlock(tp)
+			}
+		})
+		n, err := w.ImportChunk(cid, blocks)
+		if err != nil {
+			return err
+		}
+		st.chunks++
+		st.blocks += n
+		return nil
+	}, func(x, z int, err error) {
+		log.Printf("%s: chunk %d,%d: %s", path, x, z, err)
+		st.bad++
+	})
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <r.x.z.mca|region dir>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dx%world.ChunkWidth != 0 || *dz%world.ChunkWidth != 0 {
+		log.Fatalf("-dx and -dz must be multiples of %d", world.ChunkWidth)
+	}
+	files, err := regionFiles(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := world.DefaultBlockMap()
+	if err != nil {
+		log.Fatalf("load block map: %s", err)
+	}
+	err = world.InitStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer world.CloseStore()
+	w := world.NewWorld(1)
+
+	st := &stats{unknown: make(map[string]int)}
+	for _, f := range files {
+		err := importRegion(w, f, m, st)
+		if err != nil {
+			log.Printf("%s: %s", f, err)
+			continue
+		}
+		log.Printf("%s: done", f)
+	}
+	for name, n := range st.unknown {
+		log.Printf("unmapped block %s: %d", name, n)
+	}
+	log.Printf("imported %d chunks, %d blocks written, %d bad chunks", st.chunks, st.blocks, st.bad)
+}
@@ -0,0 +1,106 @@
+// regionexport 把存档中的一块区域导出为 OBJ+MTL 或 glTF (.glb), 不需要 OpenGL
+//
+//	regionexport -db gocraft.db -from -10,0,-10 -to 10,40,10 -o house.obj
+//	regionexport -db gocraft.db -from -10,0,-10 -to 10,40,10 -o house.glb
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/humboldt-xie/tinycraft/render/blockmesh"
+	"github.com/humboldt-xie/tinycraft/render/tex"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+var (
+	from   = flag.String("from", "", "first corner of the region, x,y,z")
+	to     = flag.String("to", "", "second corner of the region, x,y,z")
+	output = flag.String("o", "region.obj", "output file, .obj or .glb")
+)
+
+func parseVec3(s string) (world.Vec3, error) {
+	var v world.Vec3
+	_, err := fmt.Sscanf(strings.Replace(s, " ", "", -1), "%d,%d,%d", &v.X, &v.Y, &v.Z)
+	if err != nil {
+		return v, fmt.Errorf("bad position %q, want x,y,z", s)
+	}
+	return v, nil
+}
+
+func writeFile(path string, f func(w *os.File) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = f(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func main() {
+	flag.Parse()
+	a, err := parseVec3(*from)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := parseVec3(*to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	region := world.NewRegion(a, b)
+
+	// 贴图路径和游戏共用 -t 参数
+	texture, err := ioutil.ReadFile(*tex.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = tex.LoadTextureDesc()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = world.InitStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer world.CloseStore()
+
+	w := world.NewWorld(1)
+	vertices := blockmesh.MakeRegionMesh(w, region)
+	log.Printf("region %v: %d triangles", region, len(vertices)/8/3)
+
+	ext := filepath.Ext(*output)
+	switch strings.ToLower(ext) {
+	case ".glb":
+		err = writeFile(*output, func(f *os.File) error {
+			return blockmesh.WriteGLB(f, vertices, texture)
+		})
+	case ".obj":
+		base := strings.TrimSuffix(*output, ext)
+		mtl, png := base+".mtl", base+".png"
+		err = writeFile(*output, func(f *os.File) error {
+			return blockmesh.WriteOBJ(f, filepath.Base(mtl), vertices)
+		})
+		if err == nil {
+			err = writeFile(mtl, func(f *os.File) error {
+				return blockmesh.WriteMTL(f, filepath.Base(png))
+			})
+		}
+		if err == nil {
+			err = ioutil.WriteFile(png, texture, 0644)
+		}
+	default:
+		err = fmt.Errorf("unknown output format %q", ext)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"log"
 
 	"github.com/disintegration/imaging"
-	"github.com/humboldt-xie/tinycraft/render"
+	"github.com/humboldt-xie/tinycraft/render/tex"
 	"github.com/humboldt-xie/tinycraft/world"
 	"gopkg.in/yaml.v2"
 )
@@ -22,8 +22,8 @@ type TextureConfig struct {
 	Back    string `yaml:"back"`
 }
 
-func (t *TextureConfig) ItemDesc() (*render.TextDesc, error) {
-	td := render.TextDesc{}
+func (t *TextureConfig) ItemDesc() (*tex.TextDesc, error) {
+	td := tex.TextDesc{}
 	var err error
 	td.Left, err = addTexture(rgba, t.Left, t.Default)
 	if err != nil {
@@ -83,7 +83,7 @@ func addTexture(img *image.RGBA, path string, def string) (int, error) {
 	rect := img.Bounds()
 	bheight := rect.Max.Y - rect.Min.Y
 	width := (rect.Max.X - rect.Min.X) / 16
-	ext, _, err := render.LoadImage(path)
+	ext, _, err := tex.LoadImage(path)
 	if err != nil {
 		return id, err
 	}
@@ -114,7 +114,7 @@ func InitConfig(file string) error {
 		if err != nil {
 			return err
 		}
-		render.AddTextureDesc(item.Id, *td)
+		tex.AddTextureDesc(item.Id, *td)
 		bt := BlockType{}
 		bt.Type = item.Id
 		bt.Model = world.GetDrawType(item.Model)
@@ -123,7 +123,7 @@ func InitConfig(file string) error {
 		world.RegisterBlockType(item.Id, &bt)
 		log.Printf("add item %v %v", item, td)
 	}
-	render.AddTextureDesc(2, render.TextDesc{1, 1, 1, 1, 1, 1})
+	tex.AddTextureDesc(2, tex.TextDesc{1, 1, 1, 1, 1, 1})
 	imaging.Save(rgba, "texture.png")
 	return nil
 }
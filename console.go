@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+const consoleHistory = 6
+
+// Console 按 / 打开命令行, 回车执行, ESC 取消
+type Console struct {
+	open   bool
+	line   []rune
+	output []string
+}
+
+func (c *Console) Open() bool {
+	return c.open
+}
+
+func (c *Console) Start() {
+	c.open = true
+	c.line = c.line[:0]
+}
+
+func (c *Console) Input(ch rune) {
+	if !c.open {
+		return
+	}
+	c.line = append(c.line, ch)
+}
+
+func (c *Console) Print(s string) {
+	for _, l := range strings.Split(s, "\n") {
+		c.output = append(c.output, l)
+	}
+	if len(c.output) > consoleHistory {
+		c.output = c.output[len(c.output)-consoleHistory:]
+	}
+}
+
+// Key 处理命令行打开时的按键, 返回需要执行的命令
+func (c *Console) Key(key glfw.Key) (string, bool) {
+	switch key {
+	case glfw.KeyEscape:
+		c.open = false
+	case glfw.KeyBackspace:
+		if len(c.line) > 0 {
+			c.line = c.line[:len(c.line)-1]
+		}
+	case glfw.KeyEnter:
+		c.open = false
+		return string(c.line), true
+	}
+	return "", false
+}
+
+func (c *Console) String() string {
+	s := strings.Join(c.output, "\n")
+	if c.open {
+		s += "\n> " + string(c.line) + "_"
+	}
+	return s
+}
+
+func (g *Game) onCharCallback(win *glfw.Window, char rune) {
+	if !g.console.Open() {
+		return
+	}
+	g.console.Input(char)
+	g.refreshStat()
+}
+
+func (g *Game) onConsoleKey(key glfw.Key) {
+	line, ok := g.console.Key(key)
+	if ok {
+		g.RunCommand(line)
+	}
+	g.refreshStat()
+}
+
+func (g *Game) RunCommand(line string) {
+	g.console.Print(line)
+	ctx := &world.CommandContext{World: g.world, Player: g.player, Travel: g.Travel}
+	out, err := world.RunCommand(ctx, line)
+	if err != nil {
+		log.Printf("command %q error:%s", line, err)
+		g.console.Print(err.Error())
+		return
+	}
+	if out != "" {
+		g.console.Print(out)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/humboldt-xie/tinycraft/render"
+	"github.com/humboldt-xie/tinycraft/render/blockmesh"
+	"github.com/humboldt-xie/tinycraft/rpc"
 	"github.com/humboldt-xie/tinycraft/world"
 )
 
@@ -79,17 +81,20 @@ type Game struct {
 	lx, ly   float64
 	prevtime float64
 
-	blockRender  *render.BlockRender
-	lineRender   *render.LineRender
-	playerRender *render.PlayerRender
+	blockRender   *render.BlockRender
+	lineRender    *render.LineRender
+	playerRender  *render.PlayerRender
+	weatherRender *render.WeatherRender
 
 	world        *world.World
-	itemidx      int
+	unwatch      chan bool
 	item         *BlockType
 	fps          FPS
 	fpsObject    FPS
 	prevStatTime time.Time
 
+	console Console
+
 	exclusiveMouse bool
 	closed         bool
 }
@@ -100,8 +105,6 @@ func NewGame(w, h int) (*Game, error) {
 		game *Game
 	)
 	game = new(Game)
-	game.itemidx = 24
-	game.item = &Blocks[24]
 
 	mainthread.Call(func() {
 		win := initGL(w, h)
@@ -109,14 +112,22 @@ func NewGame(w, h int) (*Game, error) {
 		win.SetCursorPosCallback(game.onCursorPosCallback)
 		win.SetFramebufferSizeCallback(game.onFrameBufferSizeCallback)
 		win.SetKeyCallback(game.onKeyCallback)
+		win.SetCharCallback(game.onCharCallback)
 		game.win = win
 	})
 	game.world = world.NewWorld(*render.RenderRadius)
-	game.player = world.NewPlayer(mgl32.Vec3{0, 16, 0}, nil, &SimplePhysics{})
+	game.player = world.NewPlayer(game.world.Spawn(), nil, &SimplePhysics{})
+	game.player.Name = *playerName
+	game.player.Inventory.Selected = 24
+	err = game.world.Join(game.player)
+	if err != nil {
+		log.Printf("load player %s error:%s", game.player.Name, err)
+	}
 	err = InitConfig("mods/block/config.yaml")
 	if err != nil {
 		panic(err)
 	}
+	game.selectItem(game.player.Inventory.Selected)
 
 	game.blockRender, err = render.NewBlockRender(game.win, game.world, game.player)
 	if err != nil {
@@ -133,6 +144,10 @@ func NewGame(w, h int) (*Game, error) {
 	if err != nil {
 		return nil, err
 	}
+	game.weatherRender, err = render.NewWeatherRender(game.world)
+	if err != nil {
+		return nil, err
+	}
 
 	//game.playerRender.Add(0, game.player)
 	//if client == nil {
@@ -140,26 +155,59 @@ func NewGame(w, h int) (*Game, error) {
 	/*} else {
 		game.players.Store(int32(client.ClientID), game.player)
 	}*/
-	go game.watchWorld()
+	game.unwatch = make(chan bool)
+	go game.watchWorld(game.world, game.unwatch)
 
 	go game.syncPlayerLoop()
 	return game, nil
 }
 
-func (g *Game) watchWorld() {
+func (g *Game) watchWorld(w *world.World, done chan bool) {
 	for {
-		ch := g.world.Watcher.Watch(1024)
-		for {
-			ev, ok := <-ch
+		ch := w.Watcher.Watch(1024)
+		for ok := true; ok; {
+			var ev interface{}
+			select {
+			case ev, ok = <-ch:
+			case <-done:
+				w.Watcher.Unwatch(ch)
+				return
+			}
 			if !ok {
 				break
 			}
 			log.Printf("onEvent %v", ev)
+			if e, ok := ev.(world.Event); ok && e.Type == "Chunk.Update" {
+				g.blockRender.DirtyChunk(e.Data.(world.Vec3))
+			}
 		}
 	}
 
 }
 
+// Travel 切换到另一个维度
+func (g *Game) Travel(w *world.World) {
+	if w == g.world {
+		return
+	}
+	close(g.unwatch)
+	err := g.world.Leave(g.player)
+	if err != nil {
+		log.Printf("save player error:%s", err)
+	}
+	g.world.Save()
+	g.world = w
+	err = w.Join(g.player)
+	if err != nil {
+		log.Printf("load player error:%s", err)
+	}
+	g.blockRender.SetWorld(w)
+	g.lineRender.SetWorld(w)
+	g.weatherRender.SetWorld(w)
+	g.unwatch = make(chan bool)
+	go g.watchWorld(w, g.unwatch)
+}
+
 func (g *Game) setExclusiveMouse(exclusive bool) {
 	if exclusive {
 		g.win.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
@@ -168,10 +216,30 @@ func (g *Game) setExclusiveMouse(exclusive bool) {
 	}
 	g.exclusiveMouse = exclusive
 }
+
+// UpdateBlock 修改方块并发送给服务器, 服务器拒绝时恢复原来的方块
 func (g *Game) UpdateBlock(id world.Vec3, tp *world.Block) {
+	if g.world.Protected(id) {
+		log.Printf("block %v is protected by spawn", id)
+		return
+	}
+	old := g.world.Block(id)
+	g.applyBlock(id, tp)
+	go func() {
+		err := rpc.ClientUpdateBlock(id, tp)
+		if err != nil {
+			log.Printf("server rejected block %v: %s", id, err)
+			if old != nil {
+				g.applyBlock(id, old)
+			}
+		}
+	}()
+}
+
+// applyBlock 修改世界中的方块并更新渲染, 也用于服务器推送的其他玩家的修改
+func (g *Game) applyBlock(id world.Vec3, tp *world.Block) {
 	g.world.UpdateBlock(id, tp)
 	g.blockRender.DirtyBlock(id)
-	//go ClientUpdateBlock(id, tp)
 }
 
 func (g *Game) PutBlock(player *world.Player, item *BlockType) {
@@ -202,6 +270,9 @@ func (g *Game) BreakBlock(player *world.Player) {
 	tblock := g.SelectBlock(player) //g.world.HitTest(player.Pos(), player.Front())
 	if tblock != nil {
 		id := tblock.ID
+		// chunk 中的方块可能正在被其他 goroutine 读取, 修改副本
+		b := *tblock
+		tblock = &b
 		tblock.Life -= 40
 		if tblock.Life <= 0 {
 			tblock = world.NewBlock(world.TypeAir)
@@ -257,10 +328,19 @@ func (g *Game) JumpFront(delta float32) {
 }
 
 func (g *Game) onKeyCallback(win *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action != glfw.Press && action != glfw.Repeat {
+		return
+	}
+	if g.console.Open() {
+		g.onConsoleKey(key)
+		return
+	}
 	if action != glfw.Press {
 		return
 	}
 	switch key {
+	case glfw.KeySlash:
+		g.console.Start()
 	case glfw.KeyTab:
 		g.player.FlipFlying()
 	case glfw.KeySpace:
@@ -286,21 +366,29 @@ func (g *Game) onKeyCallback(win *glfw.Window, key glfw.Key, scancode int, actio
 			}, true)*/
 		}
 	case glfw.KeyE:
-		g.itemidx = (1 + g.itemidx) % len(Blocks)
-		log.Printf("item idx %d", g.itemidx)
-		g.item = &Blocks[g.itemidx]
+		g.selectItem(g.player.Inventory.Selected + 1)
+		log.Printf("item idx %d", g.player.Inventory.Selected)
 		g.blockRender.UpdateItem(g.item)
 	case glfw.KeyR:
-		g.itemidx--
-		if g.itemidx < 0 {
-			g.itemidx = len(Blocks) - 1
-		}
-		g.item = &Blocks[g.itemidx]
+		g.selectItem(g.player.Inventory.Selected - 1)
 		g.blockRender.UpdateItem(g.item)
 	}
 }
 
+// selectItem 选择手中的方块, 超出范围时循环
+func (g *Game) selectItem(idx int) {
+	idx %= len(Blocks)
+	if idx < 0 {
+		idx += len(Blocks)
+	}
+	g.player.Inventory.Selected = idx
+	g.item = &Blocks[idx]
+}
+
 func (g *Game) handleKeyInput(dt float64) {
+	if g.console.Open() {
+		return
+	}
 	speed := float32(3) * float32(dt)
 	if g.player.Flying() {
 		speed = 3 * float32(dt)
@@ -331,6 +419,10 @@ func (g *Game) ShouldClose() bool {
 	return g.closed
 }
 
+func (g *Game) refreshStat() {
+	g.prevStatTime = time.Time{}
+}
+
 func (g *Game) renderStat() {
 	now := time.Now()
 	if now.Sub(g.prevStatTime) < time.Second {
@@ -339,43 +431,63 @@ func (g *Game) renderStat() {
 	g.prevStatTime = now
 	p := g.player.Pos()
 	cid := world.NearBlock(p).Chunkid()
-	c := g.world.Chunk(cid)
+	var version int64
+	if c := g.world.TryChunk(cid); c != nil {
+		version = c.V()
+	}
 
 	life := 0
 	blockType := -1
-	show := render.FaceFilter{}
+	show := blockmesh.FaceFilter{}
 	block := g.SelectBlock(g.player)
 	if block != nil {
 		life = block.Life
-		show = render.ShowFaces(g.world, block.ID)
+		show = blockmesh.ShowFaces(g.world, block.ID)
 		blockType = block.Type
 	}
 	stat := g.blockRender.Stat()
 	stats := [][]interface{}{
 		{"pos:[%.2f,%.2f,%.2f]", p.X(), p.Y(), p.Z()},
 		{"fps: %3d object fps: %3d", g.fps.Fps(), g.fpsObject.Fps()},
-		{"cid: %v   v:%d", cid, c.V()},
+		{"cid: %v   v:%d", cid, version},
 		{"rending chunks:%.5d cache: %.5d", stat.RendingChunks, stat.CacheChunks},
 		{"faces: %d", stat.Faces},
 		{"life: %v", life},
 		{"show: %v", show},
 		{"type: %d", blockType},
+		{"time: %v", g.world.Clock()},
+		{"weather: %v", g.world.Weather().Type()},
+		{"db: %v", world.StoreStats()},
+		{"chunks: %v", g.world.ChunkStats()},
+		{"loader: %v", g.world.Loader().Stats()},
 	}
 	title := ""
 	for _, v := range stats {
 		title += fmt.Sprintf(v[0].(string), v[1:]...) + "\n"
 	}
+	title += g.console.String()
 
 	g.blockRender.UpdateText(title)
 
 	g.win.SetTitle(fmt.Sprintf("fps:%d", g.fps.Fps()))
 }
 
+// syncPlayerLoop 连接服务器时每秒上传 10 次玩家的位置, 同时收到附近的其他玩家
 func (g *Game) syncPlayerLoop() {
-	/*tick := time.NewTicker(time.Second / 10)
+	tick := time.NewTicker(time.Second / 10)
+	defer tick.Stop()
 	for range tick.C {
-		ClientUpdatePlayerState(g.player.State())
-	}*/
+		if g.closed {
+			return
+		}
+		if !rpc.Connected() {
+			continue
+		}
+		err := rpc.ClientUpdatePlayerState(g.player.State())
+		if err != nil {
+			log.Printf("sync player error:%s", err)
+		}
+	}
 }
 func (g *Game) UpdateObject() {
 	fps := 200
@@ -386,6 +498,7 @@ func (g *Game) UpdateObject() {
 		g.fpsObject.Update()
 		now := time.Now()
 		g.handleKeyInput(dt)
+		g.world.Update(dt)
 		g.players.Range(func(k, v interface{}) bool {
 			p := v.(*world.Player)
 			p.Update(dt)
@@ -410,9 +523,10 @@ func (g *Game) Update() {
 		dt = 0.02
 	}
 	//g.player.Update(dt)
+	sky := render.ComputeSky(g.world)
 	mainthread.Call(func() {
 		g.fps.Update()
-		gl.ClearColor(0.57, 0.71, 0.77, 1)
+		gl.ClearColor(sky.Color.X(), sky.Color.Y(), sky.Color.Z(), 1)
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 
 		g.blockRender.Draw(g.player)
@@ -420,6 +534,7 @@ func (g *Game) Update() {
 		mat := g.blockRender.Get3dmat(g.player)
 		//g.playerRender.DrawPlayer(g.player, g.player)
 		g.playerRender.Draw(mat, g.players)
+		g.weatherRender.Draw(g.player, mat)
 		g.renderStat()
 
 		g.win.SwapBuffers()
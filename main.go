@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"time"
 
@@ -13,13 +15,17 @@ import (
 	"github.com/faiface/mainthread"
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
-	"github.com/humboldt-xie/tinycraft/render"
+	"github.com/humboldt-xie/tinycraft/render/tex"
+	"github.com/humboldt-xie/tinycraft/rpc"
+	_ "github.com/humboldt-xie/tinycraft/schematic"
+	"github.com/humboldt-xie/tinycraft/vox"
 	"github.com/humboldt-xie/tinycraft/world"
 	//"github.com/icexin/gocraft-server/proto"
 )
 
 var (
-	pprofPort = flag.String("pprof", "", "http pprof port")
+	pprofPort  = flag.String("pprof", "", "http pprof port")
+	playerName = flag.String("name", world.DefaultPlayerName, "player name, saved state is restored by it")
 
 	game *Game
 )
@@ -75,43 +81,50 @@ func (f *FPS) Fps() int {
 }
 
 func run() {
-	err := render.LoadTextureDesc()
+	err := tex.LoadTextureDesc()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = world.InitBoltStore()
+	err = vox.LoadStructures()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = world.InitStore()
+	if errors.Is(err, world.ErrMigrateDryRun) {
+		log.Print(err)
+		return
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 	defer world.CloseStore()
-
-	/*if *listenAddr != "" {
-		err := InitService()
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		err = InitClient()
-		if err != nil {
-			log.Panic(err)
-		}
-		if client != nil {
-			defer client.Close()
-		}
-	}*/
+	world.StartBackups()
 
 	game, err = NewGame(2024, 1400)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	/*game.player = store.GetPlayer()
-	if client == nil {
-		game.playerRender.Add(0, game.player)
-	} else {
-		game.playerRender.Add(client.ClientID, game.player)
-	}*/
+	// -s 连接服务器
+	rpc.BlockUpdated = game.applyBlock
+	rpc.PlayerUpdated = game.playerRender.Remotes().Update
+	rpc.PlayerRemoved = game.playerRender.Remotes().Remove
+	info, err := rpc.InitClient(game.world, game.player)
+	if err != nil {
+		// 连不上或者被服务器拒绝时单机游戏, 在命令行显示原因
+		log.Printf("connect server error:%s", err)
+		game.console.Print("could not join the server: " + err.Error())
+	} else if info != nil {
+		game.console.Print(fmt.Sprintf("joined %s, %d other players online", info.Dimension, info.Players))
+		if info.MOTD != "" {
+			game.console.Print(info.MOTD)
+		}
+		if info.BlockHash != world.BlockRegistryHash() {
+			game.console.Print("warning: the server has different block types")
+		}
+	}
 
 	//tick := time.Tick(time.Second / 60)
 	md := time.Second / 120
@@ -129,7 +142,11 @@ func run() {
 		timer.Reset(d)
 		//log.Printf("update spend %fs %fs", float64(time.Since(start))/float64(time.Second), float64(d+time.Since(start))/float64(time.Second))
 	}
-	//store.UpdatePlayer(game.player)
+	err = game.world.Leave(game.player)
+	if err != nil {
+		log.Printf("save player error:%s", err)
+	}
+	game.world.Save()
 }
 
 func main() {
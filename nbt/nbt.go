@@ -0,0 +1,383 @@
+// Package nbt 读写 Minecraft 的 NBT 格式 (big endian)
+//
+// 标签和 go 类型的对应关系:
+//
+//	Byte      int8
+//	Short     int16
+//	Int       int32
+//	Long      int64
+//	Float     float32
+//	Double    float64
+//	ByteArray []byte
+//	String    string
+//	List      List
+//	Compound  Compound
+//	IntArray  []int32
+//	LongArray []int64
+package nbt
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	TagEnd byte = iota
+	TagByte
+	TagShort
+	TagInt
+	TagLong
+	TagFloat
+	TagDouble
+	TagByteArray
+	TagString
+	TagList
+	TagCompound
+	TagIntArray
+	TagLongArray
+)
+
+const maxDepth = 512
+
+var (
+	ErrBadTag   = errors.New("nbt: bad tag")
+	ErrTooDeep  = errors.New("nbt: nesting too deep")
+	ErrTooLarge = errors.New("nbt: array too large")
+)
+
+type Compound map[string]interface{}
+
+type List struct {
+	Type  byte
+	Items []interface{}
+}
+
+func TagType(v interface{}) (byte, error) {
+	switch v.(type) {
+	case int8:
+		return TagByte, nil
+	case int16:
+		return TagShort, nil
+	case int32:
+		return TagInt, nil
+	case int64:
+		return TagLong, nil
+	case float32:
+		return TagFloat, nil
+	case float64:
+		return TagDouble, nil
+	case []byte:
+		return TagByteArray, nil
+	case string:
+		return TagString, nil
+	case List:
+		return TagList, nil
+	case Compound:
+		return TagCompound, nil
+	case []int32:
+		return TagIntArray, nil
+	case []int64:
+		return TagLongArray, nil
+	}
+	return 0, fmt.Errorf("nbt: unsupported type %T", v)
+}
+
+type decoder struct {
+	r     *bufio.Reader
+	depth int
+}
+
+func (d *decoder) read(v interface{}) error {
+	return binary.Read(d.r, binary.BigEndian, v)
+}
+
+func (d *decoder) readString() (string, error) {
+	var n uint16
+	if err := d.read(&n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(d.r, b)
+	return string(b), err
+}
+
+func (d *decoder) readLen() (int, error) {
+	var n int32
+	if err := d.read(&n); err != nil {
+		return 0, err
+	}
+	if n < 0 || n > 1<<26 {
+		return 0, ErrTooLarge
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readPayload(tag byte) (interface{}, error) {
+	switch tag {
+	case TagByte:
+		var v int8
+		return v, d.read(&v)
+	case TagShort:
+		var v int16
+		return v, d.read(&v)
+	case TagInt:
+		var v int32
+		return v, d.read(&v)
+	case TagLong:
+		var v int64
+		return v, d.read(&v)
+	case TagFloat:
+		var v float32
+		return v, d.read(&v)
+	case TagDouble:
+		var v float64
+		return v, d.read(&v)
+	case TagByteArray:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		v := make([]byte, n)
+		_, err = io.ReadFull(d.r, v)
+		return v, err
+	case TagString:
+		return d.readString()
+	case TagList:
+		return d.readList()
+	case TagCompound:
+		return d.readCompound()
+	case TagIntArray:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		v := make([]int32, n)
+		return v, d.read(v)
+	case TagLongArray:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		v := make([]int64, n)
+		return v, d.read(v)
+	}
+	return nil, ErrBadTag
+}
+
+func (d *decoder) readList() (List, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return List{}, ErrTooDeep
+	}
+	var l List
+	if err := d.read(&l.Type); err != nil {
+		return l, err
+	}
+	n, err := d.readLen()
+	if err != nil {
+		return l, err
+	}
+	if n > 0 && (l.Type == TagEnd || l.Type > TagLongArray) {
+		return l, ErrBadTag
+	}
+	for i := 0; i < n; i++ {
+		v, err := d.readPayload(l.Type)
+		if err != nil {
+			return l, err
+		}
+		l.Items = append(l.Items, v)
+	}
+	return l, nil
+}
+
+func (d *decoder) readCompound() (Compound, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return nil, ErrTooDeep
+	}
+	c := Compound{}
+	for {
+		tag, err := d.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if tag == TagEnd {
+			return c, nil
+		}
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readPayload(tag)
+		if err != nil {
+			return nil, err
+		}
+		c[name] = v
+	}
+}
+
+// Read 读取一个未压缩的根 compound
+func Read(r io.Reader) (string, Compound, error) {
+	d := &decoder{r: bufio.NewReader(r)}
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	if tag != TagCompound {
+		return "", nil, ErrBadTag
+	}
+	name, err := d.readString()
+	if err != nil {
+		return "", nil, err
+	}
+	c, err := d.readCompound()
+	return name, c, err
+}
+
+// ReadGzip 读取 gzip 压缩的 NBT 文件, 例如 .schem
+func ReadGzip(r io.Reader) (string, Compound, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	return Read(zr)
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) write(v interface{}) {
+	if e.err == nil {
+		e.err = binary.Write(e.w, binary.BigEndian, v)
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	if len(s) > math.MaxUint16 {
+		e.err = fmt.Errorf("nbt: string too long")
+		return
+	}
+	e.write(uint16(len(s)))
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *encoder) writePayload(v interface{}) {
+	switch v := v.(type) {
+	case int8, int16, int32, int64, float32, float64:
+		e.write(v)
+	case []byte:
+		e.write(int32(len(v)))
+		e.write(v)
+	case string:
+		e.writeString(v)
+	case List:
+		e.write(v.Type)
+		e.write(int32(len(v.Items)))
+		for _, item := range v.Items {
+			t, err := TagType(item)
+			if err != nil || t != v.Type {
+				e.err = fmt.Errorf("nbt: list of %d contains %T", v.Type, item)
+				return
+			}
+			e.writePayload(item)
+		}
+	case Compound:
+		for name, item := range v {
+			t, err := TagType(item)
+			if err != nil {
+				e.err = err
+				return
+			}
+			e.write(t)
+			e.writeString(name)
+			e.writePayload(item)
+		}
+		e.write(TagEnd)
+	case []int32:
+		e.write(int32(len(v)))
+		e.write(v)
+	case []int64:
+		e.write(int32(len(v)))
+		e.write(v)
+	default:
+		e.err = fmt.Errorf("nbt: unsupported type %T", v)
+	}
+}
+
+// Write 写入一个未压缩的根 compound
+func Write(w io.Writer, name string, c Compound) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.write(TagCompound)
+	e.writeString(name)
+	e.writePayload(c)
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+func WriteGzip(w io.Writer, name string, c Compound) error {
+	zw := gzip.NewWriter(w)
+	err := Write(zw, name, c)
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Int 读取任意整数类型的字段
+func (c Compound) Int(name string) (int, bool) {
+	switch v := c[name].(type) {
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+func (c Compound) String(name string) (string, bool) {
+	v, ok := c[name].(string)
+	return v, ok
+}
+
+func (c Compound) Compound(name string) (Compound, bool) {
+	v, ok := c[name].(Compound)
+	return v, ok
+}
+
+func (c Compound) List(name string) (List, bool) {
+	v, ok := c[name].(List)
+	return v, ok
+}
+
+func (c Compound) Bytes(name string) ([]byte, bool) {
+	v, ok := c[name].([]byte)
+	return v, ok
+}
+
+func (c Compound) Ints(name string) ([]int32, bool) {
+	v, ok := c[name].([]int32)
+	return v, ok
+}
+
+func (c Compound) Longs(name string) ([]int64, bool) {
+	v, ok := c[name].([]int64)
+	return v, ok
+}
@@ -0,0 +1,53 @@
+package nbt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := Compound{
+		"byte":   int8(-1),
+		"short":  int16(300),
+		"int":    int32(70000),
+		"long":   int64(1) << 40,
+		"float":  float32(1.5),
+		"double": 2.25,
+		"bytes":  []byte{1, 2, 3},
+		"string": "minecraft:stone",
+		"list":   List{Type: TagString, Items: []interface{}{"a", "b"}},
+		"empty":  List{Type: TagEnd},
+		"nested": Compound{"x": int32(1)},
+		"ints":   []int32{1, -2, 3},
+		"longs":  []int64{1 << 50, -1},
+	}
+	var buf bytes.Buffer
+	if err := WriteGzip(&buf, "Schematic", c); err != nil {
+		t.Fatal(err)
+	}
+	name, got, err := ReadGzip(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Schematic" {
+		t.Fatalf("name %q", name)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Fatalf("got %#v\nwant %#v", got, c)
+	}
+}
+
+func TestReadBadInput(t *testing.T) {
+	inputs := [][]byte{
+		{},
+		{TagInt},
+		{TagCompound, 0, 1, 'a', TagByteArray, 0, 1, 'b', 0xff, 0xff, 0xff, 0xff},
+		{TagCompound, 0, 0, 99, 0, 0},
+	}
+	for _, in := range inputs {
+		if _, _, err := Read(bytes.NewReader(in)); err == nil {
+			t.Fatalf("expected error for %v", in)
+		}
+	}
+}
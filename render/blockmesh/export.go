@@ -0,0 +1,236 @@
+package blockmesh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+// 导出用的网格和游戏中一样, 每个顶点 8 个 float: x y z u v nx ny nz
+const vertexSize = 8
+
+// MakeRegionMesh 生成区域内所有方块的网格, 坐标相对于区域的最小角
+// 区域边界上的面总是输出, 这样导出的模型是封闭的
+func MakeRegionMesh(w *world.World, r world.Region) []float32 {
+	// 生成网格时 pin 住区域的 chunk, 不会被卸载
+	for _, id := range RegionChunks(r) {
+		if c := pinChunk(w, id); c != nil {
+			defer c.Unpin()
+		}
+	}
+	var vertices []float32
+	r.Range(func(id Vec3) {
+		b := w.Block(id)
+		if b == nil || b.BlockType() == nil || b.BlockType().Model == world.DTAir {
+			return
+		}
+		show := ShowFaces(w, id)
+		show.Left = show.Left || !r.Contains(id.Left())
+		show.Right = show.Right || !r.Contains(id.Right())
+		show.Up = show.Up || !r.Contains(id.Up())
+		show.Down = show.Down || !r.Contains(id.Down())
+		show.Front = show.Front || !r.Contains(id.Front())
+		show.Back = show.Back || !r.Contains(id.Back())
+		vertices = MakeData(b, vertices, show, id.Sub(r.Min))
+	})
+	return vertices
+}
+
+// pinChunk 加载并 pin chunk, 加载失败时返回 nil
+func pinChunk(w *world.World, id Vec3) *world.Chunk {
+	for w.Chunk(id) != nil {
+		if c := w.PinChunk(id); c != nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// RegionChunks 区域以及周围一圈方块所在的 chunk
+func RegionChunks(r world.Region) []Vec3 {
+	min := r.Min.Add(Vec3{X: -1, Z: -1}).Chunkid()
+	max := r.Max.Add(Vec3{X: 1, Z: 1}).Chunkid()
+	var ids []Vec3
+	for x := min.X; x <= max.X; x++ {
+		for z := min.Z; z <= max.Z; z++ {
+			ids = append(ids, Vec3{X: x, Z: z})
+		}
+	}
+	return ids
+}
+
+type objIndex map[[3]float32]int
+
+func (m objIndex) get(w *bufio.Writer, prefix string, v [3]float32, n int) int {
+	if i, ok := m[v]; ok {
+		return i
+	}
+	i := len(m) + 1
+	m[v] = i
+	switch n {
+	case 2:
+		fmt.Fprintf(w, "%s %g %g\n", prefix, v[0], v[1])
+	default:
+		fmt.Fprintf(w, "%s %g %g %g\n", prefix, v[0], v[1], v[2])
+	}
+	return i
+}
+
+// WriteOBJ 输出 Wavefront OBJ, 所有面使用 mtllib 中的 atlas 材质
+func WriteOBJ(w io.Writer, mtlName string, vertices []float32) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# tinycraft export\nmtllib %s\no region\nusemtl atlas\n", mtlName)
+	pos, uv, normal := objIndex{}, objIndex{}, objIndex{}
+	var face [3][3]int
+	for i := 0; i+vertexSize <= len(vertices); i += vertexSize {
+		v := vertices[i : i+vertexSize]
+		k := i / vertexSize % 3
+		face[k][0] = pos.get(bw, "v", [3]float32{v[0], v[1], v[2]}, 3)
+		// 网格中的 v 和 OBJ 一样从图片底部开始, 见 blockFragmentSource
+		face[k][1] = uv.get(bw, "vt", [3]float32{v[3], v[4]}, 2)
+		face[k][2] = normal.get(bw, "vn", [3]float32{v[5], v[6], v[7]}, 3)
+		if k == 2 {
+			fmt.Fprintf(bw, "f %d/%d/%d %d/%d/%d %d/%d/%d\n",
+				face[0][0], face[0][1], face[0][2],
+				face[1][0], face[1][1], face[1][2],
+				face[2][0], face[2][1], face[2][2])
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteMTL 输出 atlas 材质, 透明部分使用贴图的 alpha
+func WriteMTL(w io.Writer, textureName string) error {
+	_, err := fmt.Fprintf(w, "newmtl atlas\nKa 1 1 1\nKd 1 1 1\nKs 0 0 0\nillum 1\nmap_Kd %s\nmap_d %s\n", textureName, textureName)
+	return err
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride,omitempty"`
+	Target     int `json:"target,omitempty"`
+}
+
+type gltfAccessor struct {
+	BufferView    int       `json:"bufferView"`
+	ByteOffset    int       `json:"byteOffset"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float32 `json:"min,omitempty"`
+	Max           []float32 `json:"max,omitempty"`
+}
+
+const (
+	gltfFloat        = 5126
+	gltfArrayBuffer  = 34962
+	gltfNearest      = 9728
+	gltfClampToEdge  = 33071
+	glbMagic         = 0x46546C67
+	glbChunkJSON     = 0x4E4F534A
+	glbChunkBIN      = 0x004E4942
+	glbHeaderLength  = 12
+	glbChunkOverhead = 8
+)
+
+func pad4(b []byte, c byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, c)
+	}
+	return b
+}
+
+// WriteGLB 输出二进制 glTF 2.0, texture 为 atlas 的 PNG 数据, 嵌入到文件中
+func WriteGLB(w io.Writer, vertices []float32, texture []byte) error {
+	count := len(vertices) / vertexSize
+	if count == 0 {
+		return fmt.Errorf("empty mesh")
+	}
+	min := []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32}
+	max := []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32}
+	for i := 0; i < count; i++ {
+		for j := 0; j < 3; j++ {
+			v := vertices[i*vertexSize+j]
+			if v < min[j] {
+				min[j] = v
+			}
+			if v > max[j] {
+				max[j] = v
+			}
+		}
+	}
+
+	// glTF 的 v 从图片顶部开始
+	flipped := make([]float32, count*vertexSize)
+	copy(flipped, vertices)
+	for i := 0; i < count; i++ {
+		flipped[i*vertexSize+4] = 1 - flipped[i*vertexSize+4]
+	}
+	bin := new(bytes.Buffer)
+	binary.Write(bin, binary.LittleEndian, flipped)
+	vertexLength := bin.Len()
+	bin.Write(texture)
+	data := pad4(bin.Bytes(), 0)
+
+	stride := vertexSize * 4
+	doc := map[string]interface{}{
+		"asset":  map[string]interface{}{"version": "2.0", "generator": "tinycraft"},
+		"scene":  0,
+		"scenes": []interface{}{map[string]interface{}{"nodes": []int{0}}},
+		"nodes":  []interface{}{map[string]interface{}{"mesh": 0, "name": "region"}},
+		"meshes": []interface{}{map[string]interface{}{
+			"primitives": []interface{}{map[string]interface{}{
+				"attributes": map[string]int{"POSITION": 0, "TEXCOORD_0": 1, "NORMAL": 2},
+				"material":   0,
+			}},
+		}},
+		"materials": []interface{}{map[string]interface{}{
+			"name": "atlas",
+			"pbrMetallicRoughness": map[string]interface{}{
+				"baseColorTexture": map[string]int{"index": 0},
+				"metallicFactor":   0,
+				"roughnessFactor":  1,
+			},
+			"alphaMode":   "MASK",
+			"doubleSided": true,
+		}},
+		"textures": []interface{}{map[string]int{"sampler": 0, "source": 0}},
+		"samplers": []interface{}{map[string]int{
+			"magFilter": gltfNearest, "minFilter": gltfNearest,
+			"wrapS": gltfClampToEdge, "wrapT": gltfClampToEdge,
+		}},
+		"images":  []interface{}{map[string]interface{}{"bufferView": 1, "mimeType": "image/png"}},
+		"buffers": []interface{}{map[string]int{"byteLength": len(data)}},
+		"bufferViews": []gltfBufferView{
+			{Buffer: 0, ByteOffset: 0, ByteLength: vertexLength, ByteStride: stride, Target: gltfArrayBuffer},
+			{Buffer: 0, ByteOffset: vertexLength, ByteLength: len(texture)},
+		},
+		"accessors": []gltfAccessor{
+			{BufferView: 0, ByteOffset: 0, ComponentType: gltfFloat, Count: count, Type: "VEC3", Min: min, Max: max},
+			{BufferView: 0, ByteOffset: 3 * 4, ComponentType: gltfFloat, Count: count, Type: "VEC2"},
+			{BufferView: 0, ByteOffset: 5 * 4, ComponentType: gltfFloat, Count: count, Type: "VEC3"},
+		},
+	}
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	js = pad4(js, ' ')
+
+	total := glbHeaderLength + glbChunkOverhead + len(js) + glbChunkOverhead + len(data)
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.LittleEndian, [3]uint32{glbMagic, 2, uint32(total)})
+	binary.Write(bw, binary.LittleEndian, [2]uint32{uint32(len(js)), glbChunkJSON})
+	bw.Write(js)
+	binary.Write(bw, binary.LittleEndian, [2]uint32{uint32(len(data)), glbChunkBIN})
+	bw.Write(data)
+	return bw.Flush()
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/humboldt-xie/tinycraft/render/blockmesh"
+	"github.com/humboldt-xie/tinycraft/render/tex"
 	"github.com/humboldt-xie/tinycraft/world"
 )
 
@@ -31,6 +33,12 @@ func (c *MuCache) Add(key interface{}, value interface{}) {
 	c.lru.Add(key, value)
 }
 
+func (c *MuCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Purge()
+}
+
 func (c *MuCache) Get(key interface{}) (interface{}, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -38,6 +46,8 @@ func (c *MuCache) Get(key interface{}) (interface{}, bool) {
 }
 
 type BlockRender struct {
+	// mutex 保护 world, 切换维度时网格的线程还在读取
+	mutex   sync.Mutex
 	world   *world.World
 	player  *world.Player
 	win     *glfw.Window
@@ -58,7 +68,7 @@ type BlockRender struct {
 
 func NewBlockRender(win *glfw.Window, world *world.World, player *world.Player) (*BlockRender, error) {
 	var err error
-	img, rect, err := LoadImage(*texturePath)
+	img, rect, err := tex.LoadImage(*tex.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -84,6 +94,9 @@ func NewBlockRender(win *glfw.Window, world *world.World, player *world.Player)
 			glhf.Attr{Name: "matrix", Type: glhf.Mat4},
 			glhf.Attr{Name: "camera", Type: glhf.Vec3},
 			glhf.Attr{Name: "fogdis", Type: glhf.Float},
+			glhf.Attr{Name: "lightdir", Type: glhf.Vec3},
+			glhf.Attr{Name: "ambient", Type: glhf.Float},
+			glhf.Attr{Name: "sky_color", Type: glhf.Vec3},
 		}, blockVertexSource, blockFragmentSource)
 		r.text = NewText(r.shader) //&Text{shader: r.shader}
 		r.text.Update("欢迎光临")
@@ -107,19 +120,9 @@ func NewBlockRender(win *glfw.Window, world *world.World, player *world.Player)
 	return r, nil
 }
 
-func ShowFaces(world *world.World, id Vec3) FaceFilter {
-	return FaceFilter{
-		Left:  world.Block(id.Left()).IsTransparent(),
-		Right: world.Block(id.Right()).IsTransparent(),
-		Up:    world.Block(id.Up()).IsTransparent(),
-		Down:  world.Block(id.Down()).IsTransparent() && world.Block(id.Down()) != nil, //&& id.Y != 0
-		Front: world.Block(id.Front()).IsTransparent(),
-		Back:  world.Block(id.Back()).IsTransparent(),
-	}
-}
-func makeBlock(world *world.World, vertices []float32, w *Block, id Vec3) []float32 {
-	show := ShowFaces(world, id)
-	vertices = makeData(w, vertices, show, id)
+func makeBlock(world blockmesh.BlockSource, vertices []float32, w *Block, id Vec3) []float32 {
+	show := blockmesh.ShowFaces(world, id)
+	vertices = blockmesh.MakeData(w, vertices, show, id)
 	return vertices
 }
 
@@ -127,11 +130,12 @@ func (r *BlockRender) UpdateText(s string) {
 	r.text.Update(s)
 }
 
-func (r *BlockRender) makeChunkMesh(c *world.Chunk, onmainthread bool) *Mesh {
+// makeChunkMesh 由 chunk 的副本生成网格, 不读取会被修改的 chunk
+func (r *BlockRender) makeChunkMesh(c *world.ChunkSnapshot, onmainthread bool) *Mesh {
 	start := time.Now()
 	makeDataSpend := 0.0
 	defer func() {
-		log.Printf("make chunk spend %.2fs make data spend: %.2fs %v", float64(time.Since(start))/float64(time.Second), makeDataSpend, c.Id())
+		log.Printf("make chunk spend %.2fs make data spend: %.2fs %v", float64(time.Since(start))/float64(time.Second), makeDataSpend, c.ID)
 	}()
 	facedata := r.facePool.Get().([]float32)
 	defer r.facePool.Put(facedata[:0])
@@ -154,7 +158,7 @@ func (r *BlockRender) makeChunkMesh(c *world.Chunk, onmainthread bool) *Mesh {
 			defer wgMaker.Done()
 			for b := range maker {
 				temp := []float32{}
-				temp = makeBlock(r.world, temp, b, b.ID)
+				temp = makeBlock(c, temp, b, b.ID)
 				merge <- temp
 			}
 
@@ -162,7 +166,7 @@ func (r *BlockRender) makeChunkMesh(c *world.Chunk, onmainthread bool) *Mesh {
 	}
 	c.RangeBlocks(func(id Vec3, w *Block) {
 		/*temp := []float32{}
-		temp = makeBlock(r.world, temp, w, id)
+		temp = makeBlock(c, temp, w, id)
 		merge <- temp*/
 		maker <- w
 	})
@@ -172,10 +176,10 @@ func (r *BlockRender) makeChunkMesh(c *world.Chunk, onmainthread bool) *Mesh {
 	wg.Wait()
 	makeDataSpend = float64(time.Since(start)) / float64(time.Second)
 	n := len(facedata) / (r.shader.VertexFormat().Size() / 4)
-	log.Printf("chunk faces: %v %d %fs %d", c.Id(), n/6, float64(time.Since(start))/float64(time.Second), len(facedata))
+	log.Printf("chunk faces: %v %d %fs %d", c.ID, n/6, float64(time.Since(start))/float64(time.Second), len(facedata))
 	var mesh *Mesh
 	mesh = NewMesh(r.shader, facedata, onmainthread)
-	mesh.Id = c.Id()
+	mesh.Id = c.ID
 	return mesh
 }
 
@@ -184,11 +188,11 @@ func (r *BlockRender) UpdateItem(bt *world.BlockType) {
 	vertices := r.facePool.Get().([]float32)
 	defer r.facePool.Put(vertices[:0])
 
-	show := FaceFilter{true, true, true, true, true, true}
+	show := blockmesh.FaceFilter{true, true, true, true, true, true}
 	pos := Vec3{0, 0, 0}
 	w := world.NewBlock(bt.Type)
 
-	vertices = makeData(w, vertices, show, pos)
+	vertices = blockmesh.MakeData(w, vertices, show, pos)
 
 	item := NewMesh(r.shader, vertices, true)
 	if r.item != nil {
@@ -235,6 +239,21 @@ func (r *BlockRender) sortChunks(player *world.Player, chunks []Vec3) []Vec3 {
 	return chunks
 }
 
+// SetWorld 切换到另一个维度, 丢弃所有 chunk 的网格
+func (r *BlockRender) SetWorld(w *world.World) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.world.PinArea(r.player, Vec3{}, -1)
+	r.world = w
+	r.meshcache.Purge()
+}
+
+func (r *BlockRender) World() *world.World {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.world
+}
+
 func (r *BlockRender) OnEvicted(key interface{}, value interface{}) {
 	log.Printf("onEvicted %v", key)
 	value.(*ChunkMesh).Close()
@@ -242,21 +261,33 @@ func (r *BlockRender) OnEvicted(key interface{}, value interface{}) {
 
 func (r *BlockRender) updateMeshCache(player *world.Player, id Vec3) {
 	log.Printf("updateMeshCache %v", id)
-	if _, ok := r.meshcache.Get(id); !ok {
-		r.meshcache.Add(id, NewChunkMesh(r.world, r, id))
+	if _, ok := r.meshcache.Get(id); ok {
+		return
 	}
+	w := r.World()
+	mesh := NewChunkMesh(w, r, id)
+	if mesh == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// 生成网格时切换了维度
+	if r.world != w {
+		mesh.Close()
+		return
+	}
+	r.meshcache.Add(id, mesh)
 }
 
+// forcePlayerChunks 以玩家所在的 chunk 为中心加载, 先加载并 pin 脚下的 chunk, 不阻塞渲染
 func (r *BlockRender) forcePlayerChunks(player *world.Player) {
 	bid := world.NearBlock(player.Pos())
 	cid := bid.Chunkid()
-	//var ids []Vec3
-	for dx := -1; dx <= 1; dx++ {
-		for dz := -1; dz <= 1; dz++ {
-			id := Vec3{cid.X + dx, 0, cid.Z + dz}
-			r.world.Chunk(id)
-		}
-	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.world.Loader().Focus(cid, *RenderRadius+1)
+	// 玩家周围的 chunk 不会被卸载
+	r.world.PinArea(player, cid, 1)
 }
 
 func (r *BlockRender) checkChunk(id Vec3) {
@@ -303,12 +334,15 @@ func (r *BlockRender) UpdateLoop(player *world.Player) {
 
 func (r *BlockRender) drawChunks(player *world.Player) {
 	r.forcePlayerChunks(player)
+	w := r.World()
 	//r.checkChunks()
 	mat := r.Get3dmat(player)
 
 	r.shader.SetUniformAttr(0, mat)
 	r.shader.SetUniformAttr(1, player.Pos())
-	r.shader.SetUniformAttr(2, float32(*RenderRadius)*world.ChunkWidth)
+	sky := ComputeSky(w)
+	r.shader.SetUniformAttr(2, sky.Fog*float32(*RenderRadius)*world.ChunkWidth)
+	setSkyUniforms(r.shader, sky)
 
 	r.stat = Stat{}
 	planes := frustumPlanes(&mat)
@@ -327,21 +361,23 @@ func (r *BlockRender) drawChunks(player *world.Player) {
 				continue
 			}
 			if !isChunkVisiable(planes, id) {
+				// 看不见的 chunk 在可见的之后加载
+				w.Loader().Request(id, false)
 				continue
 			}
+			chunk := w.TryChunk(id)
 			//info += fmt.Sprintf("(%d,%d)", id.X, id.Z)
 			if v, ok := r.meshcache.Get(id); ok {
-				chunk := r.world.Chunk(id)
 				cmesh := v.(*ChunkMesh)
-				mesh := cmesh.mesh
-				if chunk.V() != cmesh.version {
+				mesh := cmesh.Mesh()
+				if chunk != nil && chunk.V() != cmesh.Version() {
 					cmesh.checkChunk()
 				}
 				//info += fmt.Sprintf("e[%d]\t", mesh.Faces())
 				r.stat.RendingChunks++
 				r.stat.Faces += mesh.Faces()
 				mesh.Draw()
-			} else {
+			} else if chunk != nil {
 				//info += fmt.Sprintf("n\t")
 				needMakeMesh = append(needMakeMesh, id)
 			}
@@ -371,5 +407,6 @@ func (r *BlockRender) drawItem() {
 	r.shader.SetUniformAttr(0, mat)
 	r.shader.SetUniformAttr(1, mgl32.Vec3{0, 0, 0})
 	r.shader.SetUniformAttr(2, float32(*RenderRadius)*world.ChunkWidth)
+	setSkyUniforms(r.shader, DefaultSky())
 	r.item.Draw()
 }
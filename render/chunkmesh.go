@@ -2,24 +2,34 @@ package render
 
 import (
 	"log"
+	"sync"
 
 	"github.com/faiface/mainthread"
 	"github.com/humboldt-xie/tinycraft/world"
 )
 
 type ChunkMesh struct {
-	world   *world.World
-	br      *BlockRender
-	id      Vec3
+	world *world.World
+	br    *BlockRender
+	id    Vec3
+	sigch chan bool
+
+	// mesh 和 version 在 UpdateLoop 中更新, 在渲染线程读取
+	mutex   sync.Mutex
 	mesh    *Mesh
 	version int64
-	sigch   chan bool
 }
 
+// NewChunkMesh chunk 已经卸载时返回 nil
 func NewChunkMesh(world *world.World, br *BlockRender, id Vec3) *ChunkMesh {
-	c := world.Chunk(id)
-	newMesh := br.makeChunkMesh(c, false)
-	nc := &ChunkMesh{world: world, br: br, id: c.Id(), version: c.V(), mesh: newMesh, sigch: make(chan bool)}
+	c := world.PinChunk(id)
+	if c == nil {
+		return nil
+	}
+	snap := c.Snapshot()
+	c.Unpin()
+	newMesh := br.makeChunkMesh(snap, false)
+	nc := &ChunkMesh{world: world, br: br, id: snap.ID, version: snap.Version, mesh: newMesh, sigch: make(chan bool)}
 	go nc.UpdateLoop(world)
 	return nc
 }
@@ -28,15 +38,31 @@ func (r *ChunkMesh) Close() {
 	close(r.sigch)
 }
 
+func (r *ChunkMesh) Mesh() *Mesh {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.mesh
+}
+
+// Version 网格对应的 chunk 版本
+func (r *ChunkMesh) Version() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.version
+}
+
 func (r *ChunkMesh) DirtyChunk() {
+	r.mutex.Lock()
 	r.version -= 1
+	r.mutex.Unlock()
 	r.checkChunk()
 }
 
 func (r *ChunkMesh) UpdateLoop(world *world.World) {
 	defer func() {
+		mesh := r.Mesh()
 		mainthread.CallNonBlock(func() {
-			r.mesh.Release()
+			mesh.Release()
 		})
 	}()
 	for {
@@ -57,14 +83,22 @@ func (r *ChunkMesh) checkChunk() {
 }
 
 func (r *ChunkMesh) updateMesh() {
-	c := r.world.Chunk(r.id)
-	if r.version == c.V() {
+	// 不重新加载已经卸载的 chunk
+	c := r.world.PinChunk(r.id)
+	if c == nil {
+		return
+	}
+	snap := c.Snapshot()
+	c.Unpin()
+	if r.Version() == snap.Version {
 		return
 	}
-	newMesh := r.br.makeChunkMesh(c, false)
+	newMesh := r.br.makeChunkMesh(snap, false)
+	r.mutex.Lock()
 	oldMesh := r.mesh
 	r.mesh = newMesh
-	r.version = c.V()
+	r.version = snap.Version
+	r.mutex.Unlock()
 	mainthread.CallNonBlock(func() {
 		oldMesh.Release()
 	})
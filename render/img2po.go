@@ -3,6 +3,8 @@ package render
 import (
 	"fmt"
 	"image"
+
+	"github.com/humboldt-xie/tinycraft/render/tex"
 )
 
 func arround(p image.Point) []image.Point {
@@ -53,7 +55,7 @@ func deepFind(has map[image.Point]bool, res []image.Point, img image.Image, cur
 }
 
 func Img2Po(img image.Image) ([]image.Point, error) {
-	img, rec, err := LoadImage("test_data/test.png")
+	img, rec, err := tex.LoadImage("test_data/test.png")
 	if err != nil {
 		return []image.Point{}, err
 	}
@@ -6,10 +6,11 @@ import (
 	"testing"
 
 	"github.com/disintegration/imaging"
+	"github.com/humboldt-xie/tinycraft/render/tex"
 )
 
 func TestImg2po(t *testing.T) {
-	img, _, _ := LoadImage("test_data/test.png")
+	img, _, _ := tex.LoadImage("test_data/test.png")
 	if isEdge(img, image.Point{0, 0}) {
 		t.Fatalf("no edge")
 	}
@@ -0,0 +1,166 @@
+// Package maptile 把世界渲染为俯视地图, 只在 CPU 上计算, 不依赖 OpenGL
+package maptile
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/humboldt-xie/tinycraft/render/tex"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+type (
+	Block = world.Block
+	Vec3  = world.Vec3
+)
+
+// MapRender 俯视地图, 每个像素是一列方块最上面的可见方块
+type MapRender struct {
+	world *world.World
+	atlas *image.RGBA
+
+	mutex  sync.Mutex
+	colors map[int]color.RGBA
+}
+
+// noHeight 没有任何可见方块的列
+const noHeight = -1 << 31
+
+type mapColumn struct {
+	tp     int
+	height int
+}
+
+func NewMapRender(w *world.World, atlas *image.RGBA) *MapRender {
+	return &MapRender{
+		world:  w,
+		atlas:  atlas,
+		colors: make(map[int]color.RGBA),
+	}
+}
+
+// defaultMapColor 贴图为空时使用的颜色
+var defaultMapColor = color.RGBA{128, 128, 128, 255}
+
+// faceColor 贴图中一个面的平均颜色, 忽略透明像素
+func (m *MapRender) faceColor(face tex.FaceTexture) (color.RGBA, bool) {
+	// 贴图坐标的 v 从图片底部开始, 见 blockFragmentSource
+	size := m.atlas.Bounds().Size()
+	x0, y0 := int(face[0].X()*float32(size.X)), int((1-face[2].Y())*float32(size.Y))
+	x1, y1 := int(face[2].X()*float32(size.X)), int((1-face[0].Y())*float32(size.Y))
+	var r, g, b, n int
+	for y := y0; y <= y1 && y < size.Y; y++ {
+		for x := x0; x <= x1 && x < size.X; x++ {
+			p := m.atlas.RGBAAt(x, y)
+			if p.A == 0 {
+				continue
+			}
+			r, g, b, n = r+int(p.R), g+int(p.G), b+int(p.B), n+1
+		}
+	}
+	if n == 0 {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}, true
+}
+
+// blockColor 方块顶面贴图的平均颜色, 植物或顶面为空时使用侧面贴图
+func (m *MapRender) blockColor(b *Block) color.RGBA {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if c, ok := m.colors[b.Type]; ok {
+		return c
+	}
+	t := tex.Texture(b)
+	faces := []tex.FaceTexture{t.Up, t.Front}
+	if b.BlockType().Model == world.DTPlant {
+		faces = faces[1:]
+	}
+	c := defaultMapColor
+	for _, face := range faces {
+		if fc, ok := m.faceColor(face); ok {
+			c = fc
+			break
+		}
+	}
+	m.colors[b.Type] = c
+	return c
+}
+
+// columns 计算 chunk 中每一列最上面的可见方块
+func (m *MapRender) columns(cid Vec3) *[world.ChunkWidth][world.ChunkWidth]mapColumn {
+	var cols [world.ChunkWidth][world.ChunkWidth]mapColumn
+	for x := range cols {
+		for z := range cols[x] {
+			cols[x][z].height = noHeight
+		}
+	}
+	c := m.world.Chunk(cid)
+	if c == nil {
+		return &cols
+	}
+	c.RangeBlocks(func(id Vec3, b *Block) {
+		bt := b.BlockType()
+		if bt == nil || bt.Model == world.DTAir {
+			return
+		}
+		col := &cols[id.X-cid.X*world.ChunkWidth][id.Z-cid.Z*world.ChunkWidth]
+		if id.Y > col.height {
+			col.tp, col.height = b.Type, id.Y
+		}
+	})
+	return &cols
+}
+
+func shade(c color.RGBA, f float32) color.RGBA {
+	scale := func(v uint8) uint8 {
+		s := float32(v) * f
+		if s > 255 {
+			return 255
+		}
+		return uint8(s)
+	}
+	return color.RGBA{scale(c.R), scale(c.G), scale(c.B), c.A}
+}
+
+// Render 渲染以 (x0, z0) 为左上角, w*h 个方块的区域, 一个方块一个像素
+// 颜色按高度整体变亮, 并按和北边 (z-1) 的高度差做阴影
+func (m *MapRender) Render(x0, z0, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	chunks := make(map[Vec3]*[world.ChunkWidth][world.ChunkWidth]mapColumn)
+	column := func(x, z int) mapColumn {
+		cid := Vec3{X: x, Z: z}.Chunkid()
+		cols, ok := chunks[cid]
+		if !ok {
+			cols = m.columns(cid)
+			chunks[cid] = cols
+		}
+		return cols[x-cid.X*world.ChunkWidth][z-cid.Z*world.ChunkWidth]
+	}
+	for z := z0; z < z0+h; z++ {
+		for x := x0; x < x0+w; x++ {
+			col := column(x, z)
+			if col.height == noHeight {
+				continue
+			}
+			c := m.blockColor(world.NewBlock(col.tp))
+			f := 0.8 + clamp(float32(col.height)/128, 0, 0.4)
+			if north := column(x, z-1); north.height != noHeight {
+				f += clamp(float32(col.height-north.height)*0.08, -0.25, 0.25)
+			}
+			img.SetRGBA(x-x0, z-z0, shade(c, f))
+		}
+	}
+	return img
+}
+
+func clamp(x, lo, hi float32) float32 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
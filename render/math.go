@@ -46,6 +46,10 @@ func min(a, b float32) float32 {
 	return b
 }
 
+func clamp(x, lo, hi float32) float32 {
+	return max(lo, min(hi, x))
+}
+
 func mix(a, b, factor float32) float32 {
 	return a*(1-factor) + factor*b
 }
@@ -6,6 +6,8 @@ import (
 	"github.com/faiface/glhf"
 	"github.com/faiface/mainthread"
 	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/render/blockmesh"
+	"github.com/humboldt-xie/tinycraft/render/tex"
 	"github.com/humboldt-xie/tinycraft/world"
 )
 
@@ -15,19 +17,21 @@ type PlayerRender struct {
 	//players map[int32]*Player
 	mesh     *Mesh
 	meshFoot *Mesh
+	remotes  *RemotePlayers
 }
 
 func NewPlayerRender() (*PlayerRender, error) {
 	var (
 		err error
 	)
-	img, rect, err := LoadImage(*texturePath)
+	img, rect, err := tex.LoadImage(*tex.Path)
 	if err != nil {
 		return nil, err
 	}
 
 	r := &PlayerRender{
 		//players: make(map[int32]*Player),
+		remotes: NewRemotePlayers(),
 	}
 	mainthread.Call(func() {
 		r.shader, err = glhf.NewShader(glhf.AttrFormat{
@@ -43,9 +47,9 @@ func NewPlayerRender() (*PlayerRender, error) {
 		}
 		r.texture = glhf.NewTexture(rect.Dx(), rect.Dy(), false, img.Pix)
 
-		cubeData := makeCubeData([]float32{}, world.NewBlock(64), FaceFilter{true, true, true, true, true, true}, Vec3{0, 0, 0})
+		cubeData := blockmesh.MakeCubeData([]float32{}, world.NewBlock(64), blockmesh.FaceFilter{true, true, true, true, true, true}, Vec3{0, 0, 0})
 		r.mesh = NewMesh(r.shader, cubeData, true)
-		cubeDataFoot := makeCubeData([]float32{}, world.NewBlock(65), FaceFilter{true, true, true, true, true, true}, Vec3{0, 0, 0})
+		cubeDataFoot := blockmesh.MakeCubeData([]float32{}, world.NewBlock(65), blockmesh.FaceFilter{true, true, true, true, true, true}, Vec3{0, 0, 0})
 		r.meshFoot = NewMesh(r.shader, cubeDataFoot, true)
 
 	})
@@ -106,6 +110,11 @@ func (r *PlayerRender) DrawPlayer(p *world.Player, mat mgl32.Mat4) {
 	})
 }*/
 
+// Remotes 服务器上的其他玩家, 和本地的玩家一起绘制
+func (r *PlayerRender) Remotes() *RemotePlayers {
+	return r.remotes
+}
+
 func (r *PlayerRender) Draw(mat mgl32.Mat4, players sync.Map) {
 	//mat := game.blockRender.get3dmat()
 	r.shader.Begin()
@@ -115,6 +124,10 @@ func (r *PlayerRender) Draw(mat mgl32.Mat4, players sync.Map) {
 		r.DrawPlayer(p, mat)
 		return true
 	})
+	for _, s := range r.remotes.Positions() {
+		r.shader.SetUniformAttr(0, mat.Mul4(positionMat(s)))
+		r.mesh.Draw()
+	}
 	r.texture.End()
 	r.shader.End()
 }
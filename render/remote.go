@@ -0,0 +1,119 @@
+package render
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+const (
+	// remoteDelay 其他玩家显示的位置落后收到的时间, 在收到的两个状态之间插值
+	remoteDelay = 0.2
+	// remoteStale 超过这个时间没有收到状态的玩家不显示, 比如走出了服务器发送的范围
+	remoteStale = 2.0
+	// remoteStates 每个玩家最多保存的状态
+	remoteStates = 16
+)
+
+type remotePlayer struct {
+	name   string
+	states []world.Position // T 为收到的时间, 从早到晚
+}
+
+// RemotePlayers 服务器上其他玩家收到的状态, 用于平滑地显示他们的移动
+type RemotePlayers struct {
+	mutex   sync.Mutex
+	players map[int32]*remotePlayer
+	start   time.Time
+}
+
+func NewRemotePlayers() *RemotePlayers {
+	return &RemotePlayers{
+		players: make(map[int32]*remotePlayer),
+		start:   time.Now(),
+	}
+}
+
+func (r *RemotePlayers) now() float64 {
+	return time.Since(r.start).Seconds()
+}
+
+// Update 记录玩家 id 的新状态, name 不为空时更新名字
+func (r *RemotePlayers) Update(id int32, name string, s world.Position) {
+	r.update(id, name, s, r.now())
+}
+
+func (r *RemotePlayers) update(id int32, name string, s world.Position, t float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	p, ok := r.players[id]
+	if !ok {
+		p = &remotePlayer{}
+		r.players[id] = p
+	}
+	if name != "" {
+		p.name = name
+	}
+	s.T = t
+	p.states = append(p.states, s)
+	if len(p.states) > remoteStates {
+		p.states = p.states[len(p.states)-remoteStates:]
+	}
+}
+
+// Remove 玩家断开连接
+func (r *RemotePlayers) Remove(id int32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.players, id)
+}
+
+// Positions 返回现在应该显示的所有玩家的位置
+func (r *RemotePlayers) Positions() map[int32]world.Position {
+	return r.positions(r.now())
+}
+
+func (r *RemotePlayers) positions(t float64) map[int32]world.Position {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ps := make(map[int32]world.Position)
+	for id, p := range r.players {
+		if len(p.states) == 0 || t-p.states[len(p.states)-1].T > remoteStale {
+			continue
+		}
+		ps[id] = p.at(t - remoteDelay)
+	}
+	return ps
+}
+
+// at 在 t 前后的两个状态之间插值, 超出范围时使用最早或者最晚的状态
+func (p *remotePlayer) at(t float64) world.Position {
+	states := p.states
+	if t <= states[0].T {
+		return states[0]
+	}
+	for i := 1; i < len(states); i++ {
+		a, b := states[i-1], states[i]
+		if t > b.T {
+			continue
+		}
+		f := float32((t - a.T) / (b.T - a.T))
+		return world.Position{
+			Vec3: a.Vec3.Add(b.Vec3.Sub(a.Vec3).Mul(f)),
+			Rx:   a.Rx + (b.Rx-a.Rx)*f,
+			Ry:   a.Ry + (b.Ry-a.Ry)*f,
+			T:    t,
+		}
+	}
+	return states[len(states)-1]
+}
+
+// positionMat 和 Player.ComputeMat 一样的玩家模型矩阵
+func positionMat(s world.Position) mgl32.Mat4 {
+	front := s.Front()
+	right := front.Cross(mgl32.Vec3{0, 1, 0})
+	up := right.Cross(front).Normalize()
+	return mgl32.LookAtV(s.Vec3, s.Vec3.Add(front), up).Inv()
+}
@@ -0,0 +1,37 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+func TestRemotePlayers(t *testing.T) {
+	r := NewRemotePlayers()
+	r.update(1, "alice", world.Position{Vec3: mgl32.Vec3{0, 10, 0}, Rx: 0}, 1)
+	r.update(1, "", world.Position{Vec3: mgl32.Vec3{10, 10, 0}, Rx: 90}, 2)
+	if r.players[1].name != "alice" {
+		t.Fatalf("name %q", r.players[1].name)
+	}
+
+	// 显示落后 remoteDelay 的位置, 在两个状态之间插值
+	p := r.positions(1.5 + remoteDelay)[1]
+	if p.Vec3 != (mgl32.Vec3{5, 10, 0}) || p.Rx != 45 {
+		t.Fatalf("interpolated %v", p)
+	}
+	if p := r.positions(0.5)[1]; p.X() != 0 {
+		t.Fatalf("before first state %v", p)
+	}
+	if p := r.positions(3)[1]; p.X() != 10 {
+		t.Fatalf("after last state %v", p)
+	}
+	// 很久没有收到状态的玩家不显示
+	if ps := r.positions(2 + remoteStale + 0.1); len(ps) != 0 {
+		t.Fatalf("stale players %v", ps)
+	}
+	r.Remove(1)
+	if ps := r.positions(2); len(ps) != 0 {
+		t.Fatalf("removed players %v", ps)
+	}
+}
@@ -12,11 +12,12 @@ import (
 	"github.com/go-gl/gl/v3.3-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/render/blockmesh"
+	"github.com/humboldt-xie/tinycraft/render/tex"
 	"github.com/humboldt-xie/tinycraft/world"
 )
 
 var (
-	texturePath  = flag.String("t", "texture.png", "texture file")
 	RenderRadius = flag.Int("r", 6, "render radius")
 )
 
@@ -104,7 +105,7 @@ func NewText(s *glhf.Shader) *Text {
 func (t *Text) LoadPages() {
 	t.pages = make(map[int]*UnicodePage)
 	for i := 0; i < 256; i++ {
-		img, rect, err := LoadImage(fmt.Sprintf("font/unicode_page_%.2x.png", i))
+		img, rect, err := tex.LoadImage(fmt.Sprintf("font/unicode_page_%.2x.png", i))
 		if err != nil {
 			//panic(err)
 			continue
@@ -207,6 +208,7 @@ func (t *Text) Draw() {
 	t.shader.SetUniformAttr(0, mat)
 	t.shader.SetUniformAttr(1, mgl32.Vec3{0, 0, 0})
 	t.shader.SetUniformAttr(2, float32(*RenderRadius)*world.ChunkWidth)
+	setSkyUniforms(t.shader, DefaultSky())
 	//r.item.Draw()
 	t.face.Draw()
 }
@@ -344,6 +346,10 @@ func (r *LineRender) drawCross() {
 	r.cross.Draw(project.Mul4(model))
 }
 
+func (r *LineRender) SetWorld(w *world.World) {
+	r.world = w
+}
+
 func (r *LineRender) drawWireFrame(player *world.Player, mat mgl32.Mat4) {
 	if r.world == nil {
 		panic("world is nil")
@@ -362,7 +368,7 @@ func (r *LineRender) drawWireFrame(player *world.Player, mat mgl32.Mat4) {
 	}
 
 	id := *block
-	show := FaceFilter{
+	show := blockmesh.FaceFilter{
 		true || r.world.Block(id.Left()).IsTransparent(),
 		true || r.world.Block(id.Right()).IsTransparent(),
 		true || r.world.Block(id.Up()).IsTransparent(),
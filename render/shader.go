@@ -11,13 +11,12 @@ in vec3 normal;
 uniform mat4 matrix;
 uniform vec3 camera;
 uniform float fogdis;
+uniform vec3 lightdir;
 
 out vec2 Tex;
 out float diff;
 out float fog_factor;
 
-const vec3 lightdir = normalize(vec3(-1, 1, -1));
-
 void main() {
     gl_Position = matrix *  vec4(pos, 1.0);
 
@@ -35,11 +34,11 @@ in vec2 Tex;
 in float diff;
 in float fog_factor;
 uniform sampler2D tex;
+uniform float ambient;
+uniform vec3 sky_color;
 
 out vec4 FragColor;
 
-const vec3 sky_color = vec3(0.57, 0.71, 0.77);
-
 void main() {
     vec3 color = vec3(texture(tex, vec2(Tex.x, 1-Tex.y)));
     if (color == vec3(1,0,1)) {
@@ -49,9 +48,9 @@ void main() {
     if (color == vec3(1,1,1)) {
         df = 1- diff * 0.2;
     }
-    vec3 ambient = 0.5 * vec3(1, 1, 1);
-    vec3 diffcolor = df * 0.5 * vec3(1,1,1);
-    color = (ambient + diffcolor) * color;
+    vec3 ambientcolor = ambient * vec3(1, 1, 1);
+    vec3 diffcolor = df * ambient * vec3(1,1,1);
+    color = (ambientcolor + diffcolor) * color;
     color = mix(color, sky_color, fog_factor);
     FragColor = vec4(color, 1);
 }
@@ -76,6 +75,29 @@ out vec4 color;
 void main() {
     color = vec4(0,0,0,1);
 }
+`
+	particleVertexSource = `
+#version 330 core
+
+in vec3 pos;
+
+uniform mat4 matrix;
+
+void main() {
+    gl_Position = matrix *  vec4(pos, 1.0);
+}
+`
+
+	particleFragmentSource = `
+#version 330 core
+
+uniform vec4 color;
+
+out vec4 FragColor;
+
+void main() {
+    FragColor = color;
+}
 `
 	playerVertexSource = `
 #version 330 core
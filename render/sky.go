@@ -0,0 +1,118 @@
+package render
+
+import (
+	"math"
+	"time"
+
+	"github.com/faiface/glhf"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+var (
+	skyNight = mgl32.Vec3{0.02, 0.03, 0.08}
+	skyDawn  = mgl32.Vec3{0.85, 0.55, 0.40}
+	skyDay   = mgl32.Vec3{0.57, 0.71, 0.77}
+	skyDusk  = mgl32.Vec3{0.80, 0.45, 0.35}
+
+	// 一天中的关键帧, t 为 Clock.TimeOfDay
+	skyKeys = []struct {
+		t     float32
+		color mgl32.Vec3
+	}{
+		{0.00, skyDawn},
+		{0.06, skyDay},
+		{0.44, skyDay},
+		{0.50, skyDusk},
+		{0.56, skyNight},
+		{0.94, skyNight},
+		{1.00, skyDawn},
+	}
+
+	defaultLightDir = mgl32.Vec3{-1, 1, -1}.Normalize()
+
+	skyStorm = mgl32.Vec3{0.30, 0.32, 0.36}
+)
+
+const (
+	ambientNight = float32(0.12)
+	ambientDay   = float32(0.5)
+)
+
+type Sky struct {
+	Color    mgl32.Vec3
+	LightDir mgl32.Vec3
+	Ambient  float32
+	Fog      float32 // 雾的距离系数, 1 为渲染半径
+}
+
+// DefaultSky 物品栏和文字使用的固定光照
+func DefaultSky() Sky {
+	return Sky{Color: skyDay, LightDir: defaultLightDir, Ambient: ambientDay, Fog: 1}
+}
+
+func skyColor(t float32) mgl32.Vec3 {
+	for i := 1; i < len(skyKeys); i++ {
+		a, b := skyKeys[i-1], skyKeys[i]
+		if t <= b.t {
+			f := (t - a.t) / (b.t - a.t)
+			return mgl32.Vec3{
+				mix(a.color.X(), b.color.X(), f),
+				mix(a.color.Y(), b.color.Y(), f),
+				mix(a.color.Z(), b.color.Z(), f),
+			}
+		}
+	}
+	return skyKeys[len(skyKeys)-1].color
+}
+
+func mixColor(a, b mgl32.Vec3, f float32) mgl32.Vec3 {
+	return mgl32.Vec3{mix(a.X(), b.X(), f), mix(a.Y(), b.Y(), f), mix(a.Z(), b.Z(), f)}
+}
+
+// lightningFlash 闪电后的亮度, 0.5 秒内衰减
+func lightningFlash(t time.Time) float32 {
+	d := time.Since(t).Seconds()
+	if t.IsZero() || d > 0.5 {
+		return 0
+	}
+	return float32(math.Exp(-d * 8))
+}
+
+func ComputeSky(w *world.World) Sky {
+	clock := w.Clock()
+	weather := w.Weather()
+	angle := clock.SunAngle()
+	// 太阳从东(x+)升起, 稍微偏南避免正午光照垂直
+	dir := mgl32.Vec3{cos(angle), sin(angle), -0.3}.Normalize()
+	sky := Sky{
+		Color:    skyColor(clock.TimeOfDay()),
+		LightDir: dir,
+		Ambient:  mix(ambientNight, ambientDay, clock.Daylight()),
+		Fog:      1,
+	}
+
+	// 下雨时天空变灰, 光照变暗, 雾变浓
+	rain := weather.Intensity()
+	if weather.Type() == world.WeatherThunder {
+		rain *= 1.3
+	}
+	storm := mixColor(skyNight, skyStorm, clock.Daylight())
+	sky.Color = mixColor(sky.Color, storm, min(1, rain*0.8))
+	sky.Ambient *= 1 - 0.4*min(1, rain)
+	sky.Fog = 1 - 0.5*min(1, rain)
+
+	flash := lightningFlash(weather.Lightning())
+	if flash > 0 {
+		sky.Ambient = mix(sky.Ambient, 1, flash)
+		sky.Color = mixColor(sky.Color, mgl32.Vec3{0.9, 0.9, 1}, flash)
+	}
+	return sky
+}
+
+// setSkyUniforms 设置 block shader 的 lightdir, ambient, sky_color
+func setSkyUniforms(shader *glhf.Shader, sky Sky) {
+	shader.SetUniformAttr(3, sky.LightDir)
+	shader.SetUniformAttr(4, sky.Ambient)
+	shader.SetUniformAttr(5, sky.Color)
+}
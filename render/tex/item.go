@@ -1,13 +1,14 @@
-package render
+package tex
 
 import (
 	"log"
 
 	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
 )
 
 var (
-	tex = NewItemHub()
+	hub = NewItemHub()
 )
 
 type FaceTexture [6]mgl32.Vec2
@@ -64,7 +65,7 @@ func (h *ItemHub) AddTexture(w int, desc TextDesc) {
 	log.Printf("add texture %d %v", w, desc)
 }
 
-func (h *ItemHub) Texture(w *Block) *BlockTexture {
+func (h *ItemHub) Texture(w *world.Block) *BlockTexture {
 	t, ok := h.tex[w.BlockType().Type]
 	if !ok {
 		log.Printf("%d not found", w)
@@ -72,14 +73,19 @@ func (h *ItemHub) Texture(w *Block) *BlockTexture {
 	}
 	return t
 }
+// Texture 方块的贴图, 见 LoadTextureDesc 和 AddTextureDesc
+func Texture(w *world.Block) *BlockTexture {
+	return hub.Texture(w)
+}
+
 func AddTextureDesc(id int, desc TextDesc) error {
-	tex.AddTexture(id, desc)
+	hub.AddTexture(id, desc)
 	return nil
 }
 
 func LoadTextureDesc() error {
 	for w, f := range itemDesc {
-		tex.AddTexture(w, f)
+		hub.AddTexture(w, f)
 	}
 	return nil
 }
@@ -1,6 +1,8 @@
-package render
+// Package tex 方块贴图的描述和加载, 只在 CPU 上使用, 不依赖 OpenGL
+package tex
 
 import (
+	"flag"
 	"image"
 	"image/color"
 	"image/draw"
@@ -8,6 +10,9 @@ import (
 	"os"
 )
 
+// Path 所有方块贴图所在的图片
+var Path = flag.String("t", "texture.png", "texture file")
+
 func LoadImage(fname string) (*image.RGBA, image.Rectangle, error) {
 	f, err := os.Open(fname)
 	if err != nil {
@@ -0,0 +1,168 @@
+package render
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/faiface/glhf"
+	"github.com/faiface/mainthread"
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+const (
+	maxDrops      = 3000
+	weatherRadius = 20
+	weatherHeight = 24
+)
+
+var (
+	rainColor = mgl32.Vec4{0.55, 0.62, 0.80, 0.6}
+	snowColor = mgl32.Vec4{0.95, 0.95, 1.00, 0.9}
+)
+
+type drop struct {
+	pos   mgl32.Vec3
+	snow  bool
+	speed float32
+	phase float32
+}
+
+// WeatherRender 在相机周围绘制雨雪粒子
+type WeatherRender struct {
+	world    *world.World
+	shader   *glhf.Shader
+	vao, vbo uint32
+
+	drops []drop
+	rand  *rand.Rand
+	last  time.Time
+	rain  []float32
+	snow  []float32
+}
+
+func NewWeatherRender(w *world.World) (*WeatherRender, error) {
+	r := &WeatherRender{
+		world: w,
+		drops: make([]drop, maxDrops),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	var err error
+	mainthread.Call(func() {
+		r.shader, err = glhf.NewShader(glhf.AttrFormat{
+			glhf.Attr{Name: "pos", Type: glhf.Vec3},
+		}, glhf.AttrFormat{
+			glhf.Attr{Name: "matrix", Type: glhf.Mat4},
+			glhf.Attr{Name: "color", Type: glhf.Vec4},
+		}, particleVertexSource, particleFragmentSource)
+		if err != nil {
+			return
+		}
+		gl.GenVertexArrays(1, &r.vao)
+		gl.GenBuffers(1, &r.vbo)
+		gl.BindVertexArray(r.vao)
+		gl.BindBuffer(gl.ARRAY_BUFFER, r.vbo)
+		loc := gl.GetAttribLocation(r.shader.ID(), gl.Str("pos\x00"))
+		gl.VertexAttribPointer(uint32(loc), 3, gl.FLOAT, false, 3*4, gl.PtrOffset(0))
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.BindVertexArray(0)
+		gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *WeatherRender) respawn(d *drop, center mgl32.Vec3, top bool) {
+	x := center.X() + (r.rand.Float32()*2-1)*weatherRadius
+	z := center.Z() + (r.rand.Float32()*2-1)*weatherRadius
+	y := center.Y() + r.rand.Float32()*weatherHeight - weatherHeight/3
+	if top {
+		y = center.Y() + weatherHeight*2/3
+	}
+	d.pos = mgl32.Vec3{x, y, z}
+	d.snow = world.IsSnowy(world.NearBlock(d.pos))
+	d.speed = 18 + r.rand.Float32()*6
+	if d.snow {
+		d.speed = 1.5 + r.rand.Float32()
+	}
+	d.phase = r.rand.Float32() * 6.28
+}
+
+func (r *WeatherRender) update(center mgl32.Vec3, n int, dt float32) {
+	r.rain = r.rain[:0]
+	r.snow = r.snow[:0]
+	for i := 0; i < n; i++ {
+		d := &r.drops[i]
+		if d.speed == 0 {
+			r.respawn(d, center, false)
+		}
+		d.pos[1] -= d.speed * dt
+		if d.snow {
+			d.phase += dt
+			d.pos[0] += sin(d.phase) * 0.3 * dt
+		}
+		dx, dz := d.pos.X()-center.X(), d.pos.Z()-center.Z()
+		if d.pos.Y() < center.Y()-weatherHeight/3 || abs(dx) > weatherRadius || abs(dz) > weatherRadius {
+			r.respawn(d, center, true)
+		}
+		// 被方块挡住的粒子不画
+		if r.world.Block(world.NearBlock(d.pos)).IsObstacle() {
+			continue
+		}
+		x, y, z := d.pos.X(), d.pos.Y(), d.pos.Z()
+		if d.snow {
+			const s = 0.05
+			r.snow = append(r.snow,
+				x-s, y, z, x+s, y, z,
+				x, y-s, z, x, y+s, z,
+				x, y, z-s, x, y, z+s,
+			)
+		} else {
+			r.rain = append(r.rain, x, y, z, x, y+0.6, z)
+		}
+	}
+}
+
+func (r *WeatherRender) drawLines(data []float32, color mgl32.Vec4) {
+	if len(data) == 0 {
+		return
+	}
+	r.shader.SetUniformAttr(1, color)
+	gl.BindBuffer(gl.ARRAY_BUFFER, r.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(data)*4, gl.Ptr(data), gl.DYNAMIC_DRAW)
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	gl.BindVertexArray(r.vao)
+	gl.DrawArrays(gl.LINES, 0, int32(len(data)/3))
+	gl.BindVertexArray(0)
+}
+
+// call on mainthread
+func (r *WeatherRender) SetWorld(w *world.World) {
+	r.world = w
+}
+
+func (r *WeatherRender) Draw(player *world.Player, mat mgl32.Mat4) {
+	now := time.Now()
+	dt := float32(now.Sub(r.last).Seconds())
+	r.last = now
+	if dt > 0.1 {
+		dt = 0.1
+	}
+	n := int(r.world.Weather().Intensity() * maxDrops)
+	if n == 0 {
+		return
+	}
+	r.update(player.Pos(), n, dt)
+
+	r.shader.Begin()
+	r.shader.SetUniformAttr(0, mat)
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
+	r.drawLines(r.rain, rainColor)
+	r.drawLines(r.snow, snowColor)
+	gl.Disable(gl.BLEND)
+	r.shader.End()
+}
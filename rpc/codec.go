@@ -0,0 +1,423 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion 二进制协议的版本, 消息或者编码改变时增加
+const ProtocolVersion = 2
+
+// 每个 yamux stream 上只有一个方向的调用, 调用方先发送 shimLine 和 protocolMagic+版本,
+// 被调用方检查后在第一个响应前发送自己的 protocolMagic+版本. 之后每个消息为:
+//
+//	uvarint 长度 | 类型 | uvarint 消息 id | uvarint seq | 响应的错误 | 消息体
+//
+// shimLine 是一个 JSON-RPC 请求, 旧的客户端收到后完成初始化, 然后在第一次调用时收到
+// 服务器的版本错误, 新的一方忽略这一行.
+const shimLine = `{"method":"Status.InitClient","params":[{"ClientID":0}],"id":0,"protocol":1}` + "\n"
+
+var protocolMagic = [4]byte{'G', 'C', 'R', 'F'}
+
+const maxFrame = 16 << 20
+
+const (
+	frameRequest  = 1
+	frameResponse = 2
+)
+
+// ErrVersion 对方使用不同版本的协议, 用 errors.Is 判断
+var ErrVersion = errors.New("protocol version mismatch")
+
+// VersionError 对方的协议版本, Peer 为 0 时对方使用旧的 JSON-RPC
+type VersionError struct {
+	Local, Peer int
+}
+
+func (e *VersionError) Error() string {
+	if e.Peer == 0 {
+		return fmt.Sprintf("protocol version mismatch: peer uses the old JSON-RPC protocol, this side speaks binary protocol v%d; upgrade the peer", e.Local)
+	}
+	return fmt.Sprintf("protocol version mismatch: this side speaks v%d, peer speaks v%d", e.Local, e.Peer)
+}
+
+func (e *VersionError) Is(target error) bool {
+	return target == ErrVersion
+}
+
+// 消息 id, 发布后不能修改, 新的消息使用新的 id
+var methods = []struct {
+	id   uint64
+	name string
+}{
+	{1, "Block.FetchChunk"},
+	{2, "Block.UpdateBlock"},
+	{3, "Player.UpdateState"},
+	{4, "Player.PlayerJoin"},
+	{5, "Player.RemovePlayer"},
+	// 6 Status.InitClient, 版本 2 中被握手代替
+	{7, "Status.SyncPlayer"},
+	{8, "Status.SyncTime"},
+	{9, "Status.SyncWeather"},
+	{10, "Status.Handshake"},
+	{11, "Status.Accept"},
+	{12, "Status.Reject"},
+}
+
+var (
+	methodIDs   = make(map[string]uint64)
+	methodNames = make(map[uint64]string)
+)
+
+func init() {
+	for _, m := range methods {
+		methodIDs[m.name] = m.id
+		methodNames[m.id] = m.name
+	}
+}
+
+// frame 一个请求或者响应
+type frame struct {
+	kind   byte
+	method uint64
+	seq    uint64
+	err    string // 只有响应有
+	body   []byte
+}
+
+func (f *frame) encode() []byte {
+	e := &encoder{buf: make([]byte, 0, len(f.body)+16)}
+	e.buf = append(e.buf, f.kind)
+	e.uint(f.method)
+	e.uint(f.seq)
+	if f.kind == frameResponse {
+		e.string(f.err)
+	}
+	e.buf = append(e.buf, f.body...)
+	return e.buf
+}
+
+func decodeFrame(b []byte) (*frame, error) {
+	d := &decoder{buf: b}
+	kind := d.next(1)
+	if kind == nil {
+		return nil, errShortMessage
+	}
+	f := &frame{kind: kind[0]}
+	if f.kind != frameRequest && f.kind != frameResponse {
+		return nil, fmt.Errorf("bad frame type %d", f.kind)
+	}
+	f.method = d.uint()
+	f.seq = d.uint()
+	if f.kind == frameResponse {
+		f.err = d.string()
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	f.body = d.buf
+	return f, nil
+}
+
+func readFrame(r *bufio.Reader) (*frame, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFrame {
+		return nil, fmt.Errorf("frame too large: %d", n)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeFrame(b)
+}
+
+// codec 二进制协议的 rpc.ClientCodec 和 rpc.ServerCodec
+type codec struct {
+	conn   io.ReadWriteCloser
+	r      *bufio.Reader
+	caller bool
+
+	wmutex sync.Mutex
+	w      *bufio.Writer
+	sent   bool // 已经发送了版本
+
+	checked chan bool // 检查了对方的版本后关闭
+	cur     *frame
+	emutex  sync.Mutex
+	err     error // 读取出错的原因
+}
+
+// newClientCodec 调用方的编码, 立即发送版本
+func newClientCodec(conn io.ReadWriteCloser) *codec {
+	c := newCodec(conn, true)
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	c.sendVersion()
+	c.w.Flush()
+	return c
+}
+
+// newServerCodec 被调用方的编码, 检查对方的版本后才发送自己的版本
+func newServerCodec(conn io.ReadWriteCloser) *codec {
+	return newCodec(conn, false)
+}
+
+func newCodec(conn io.ReadWriteCloser, caller bool) *codec {
+	return &codec{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		caller:  caller,
+		checked: make(chan bool),
+	}
+}
+
+func (c *codec) sendVersion() {
+	if c.sent {
+		return
+	}
+	c.sent = true
+	if c.caller {
+		c.w.WriteString(shimLine)
+	}
+	var b [6]byte
+	copy(b[:], protocolMagic[:])
+	binary.BigEndian.PutUint16(b[4:], ProtocolVersion)
+	c.w.Write(b[:])
+}
+
+// checkVersion 读取对方的版本. 被调用方跳过 shimLine, 回复旧的 JSON-RPC 调用方一个 JSON 错误
+func (c *codec) checkVersion() error {
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if first[0] == '{' {
+		line, err := c.r.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		var req struct {
+			Id       json.RawMessage `json:"id"`
+			Protocol int             `json:"protocol"`
+		}
+		json.Unmarshal(line, &req)
+		if c.caller || req.Protocol == 0 {
+			verr := &VersionError{Local: ProtocolVersion}
+			if !c.caller && req.Id != nil {
+				c.rejectJSON(req.Id)
+			}
+			return verr
+		}
+	}
+	var b [6]byte
+	_, err = io.ReadFull(c.r, b[:])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(b[:4], protocolMagic[:]) {
+		return fmt.Errorf("bad protocol magic %q", b[:4])
+	}
+	peer := int(binary.BigEndian.Uint16(b[4:]))
+	if peer != ProtocolVersion {
+		// 告诉对方自己的版本, 对方也可以报告版本错误
+		c.wmutex.Lock()
+		c.sendVersion()
+		c.w.Flush()
+		c.wmutex.Unlock()
+		return &VersionError{Local: ProtocolVersion, Peer: peer}
+	}
+	return nil
+}
+
+type jsonResponse struct {
+	Id     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// rejectJSON 用 JSON-RPC 的格式回复旧的调用方
+func (c *codec) rejectJSON(id json.RawMessage) {
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	json.NewEncoder(c.w).Encode(&jsonResponse{Id: id, Error: jsonPeerError()})
+	c.w.Flush()
+}
+
+// rejectJSONCalls 回复旧的 JSON-RPC 客户端的所有调用, 直到连接关闭
+func rejectJSONCalls(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req struct {
+			Id json.RawMessage `json:"id"`
+		}
+		if dec.Decode(&req) != nil {
+			return
+		}
+		if enc.Encode(&jsonResponse{Id: req.Id, Error: jsonPeerError()}) != nil {
+			return
+		}
+	}
+}
+
+// jsonPeerError 旧的 JSON-RPC 对方看到的错误
+func jsonPeerError() string {
+	return fmt.Sprintf("protocol version mismatch: peer speaks gocraft binary protocol v%d, not JSON-RPC; upgrade", ProtocolVersion)
+}
+
+// read 读取下一个 kind 类型的消息
+func (c *codec) read(kind byte) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-c.checked:
+	default:
+		err := c.checkVersion()
+		c.setErr(err)
+		close(c.checked)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := readFrame(c.r)
+	if err == nil && f.kind != kind {
+		err = fmt.Errorf("unexpected frame type %d", f.kind)
+	}
+	if err != nil {
+		c.setErr(err)
+		return err
+	}
+	c.cur = f
+	return nil
+}
+
+func (c *codec) setErr(err error) {
+	if err == io.EOF {
+		return
+	}
+	c.emutex.Lock()
+	c.err = err
+	c.emutex.Unlock()
+}
+
+func (c *codec) readBody(body interface{}) error {
+	f := c.cur
+	c.cur = nil
+	if body == nil || f == nil {
+		return nil
+	}
+	m, ok := body.(message)
+	if !ok {
+		return fmt.Errorf("rpc: %T is not a protocol message", body)
+	}
+	d := &decoder{buf: f.body}
+	m.decode(d)
+	return d.done()
+}
+
+func (c *codec) write(f *frame, body interface{}) error {
+	if f.err == "" {
+		m, ok := body.(message)
+		if !ok {
+			return fmt.Errorf("rpc: %T is not a protocol message", body)
+		}
+		e := &encoder{}
+		m.encode(e)
+		f.body = e.buf
+	}
+	b := f.encode()
+	if len(b) > maxFrame {
+		return fmt.Errorf("frame too large: %d", len(b))
+	}
+	c.wmutex.Lock()
+	defer c.wmutex.Unlock()
+	c.sendVersion()
+	var n [binary.MaxVarintLen64]byte
+	c.w.Write(n[:binary.PutUvarint(n[:], uint64(len(b)))])
+	c.w.Write(b)
+	return c.w.Flush()
+}
+
+func (c *codec) WriteRequest(r *rpc.Request, body interface{}) error {
+	id, ok := methodIDs[r.ServiceMethod]
+	if !ok {
+		return fmt.Errorf("rpc: unknown method %s", r.ServiceMethod)
+	}
+	return c.write(&frame{kind: frameRequest, method: id, seq: r.Seq}, body)
+}
+
+func (c *codec) ReadResponseHeader(r *rpc.Response) error {
+	err := c.read(frameResponse)
+	if err != nil {
+		return err
+	}
+	r.ServiceMethod = methodNames[c.cur.method]
+	r.Seq = c.cur.seq
+	r.Error = c.cur.err
+	return nil
+}
+
+func (c *codec) ReadResponseBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *codec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.read(frameRequest)
+	if err != nil {
+		return err
+	}
+	name, ok := methodNames[c.cur.method]
+	if !ok {
+		// net/rpc 回复格式错误
+		name = fmt.Sprintf("#%d", c.cur.method)
+	}
+	r.ServiceMethod = name
+	r.Seq = c.cur.seq
+	return nil
+}
+
+func (c *codec) ReadRequestBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *codec) WriteResponse(r *rpc.Response, body interface{}) error {
+	return c.write(&frame{kind: frameResponse, method: methodIDs[r.ServiceMethod], seq: r.Seq, err: r.Error}, body)
+}
+
+func (c *codec) Close() error {
+	return c.conn.Close()
+}
+
+// peerError 等待读取对方的版本, 最多等 timeout, 返回读取失败的原因
+func (c *codec) peerError(timeout time.Duration) error {
+	select {
+	case <-c.checked:
+	case <-time.After(timeout):
+	}
+	return c.Err()
+}
+
+// Err 读取失败的原因, 比如 *VersionError, 正常关闭时为 nil
+func (c *codec) Err() error {
+	c.emutex.Lock()
+	defer c.emutex.Unlock()
+	return c.err
+}
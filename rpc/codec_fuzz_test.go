@@ -0,0 +1,52 @@
+//go:build go1.18
+// +build go1.18
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// FuzzDecode 任意的数据只能返回错误, 不能 panic 或者分配过多的内存
+func FuzzDecode(f *testing.F) {
+	for _, m := range testMessages {
+		e := &encoder{}
+		m.encode(e)
+		fr := &frame{kind: frameRequest, method: 1, seq: 1, body: e.buf}
+		f.Add(fr.encode())
+	}
+	f.Add([]byte{frameResponse, 6, 1, 3, 'e', 'r', 'r'})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		fr, err := decodeFrame(b)
+		if err != nil {
+			return
+		}
+		for _, seed := range testMessages {
+			// 不修改 testMessages 中的例子
+			m := reflect.New(reflect.TypeOf(seed).Elem()).Interface().(message)
+			d := &decoder{buf: fr.body}
+			m.decode(d)
+			if d.done() != nil {
+				continue
+			}
+			// 成功解码的消息重新编码后不变
+			e := &encoder{}
+			m.encode(e)
+			d = &decoder{buf: e.buf}
+			m.decode(d)
+			if err := d.done(); err != nil {
+				t.Fatalf("%T: re-decode %v", m, err)
+			}
+		}
+		var buf bytes.Buffer
+		e := &encoder{}
+		e.bytes(b)
+		buf.Write(e.buf)
+		if _, err := readFrame(bufio.NewReader(&buf)); (err == nil) != (fr != nil) {
+			t.Fatalf("readFrame %v", err)
+		}
+	})
+}
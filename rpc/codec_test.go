@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+// testMessages 每个消息的例子, 也是 FuzzDecode 的种子
+var testMessages = []message{
+	&FetchChunkRequest{P: -3, Q: 7, Version: "v1"},
+	&FetchChunkResponse{Data: []byte{1, 2, 3}, Version: "v2"},
+	&UpdateBlockRequest{Id: 2, P: -1, Q: 0, X: -5, Y: 40, Z: 3, Block: &world.Block{Type: 4, Life: 100}, Version: "x"},
+	&UpdateBlockRequest{Id: 2, X: 1},
+	&UpdateBlockResponse{Version: "abc"},
+	&UpdateStateRequest{Id: 1, State: PlayerState{X: 1.5, Y: -2, Z: 3, Rx: 90, Ry: -45}},
+	&UpdateStateResponse{Players: map[int32]PlayerState{1: {X: 1}, -7: {Ry: 2}}},
+	&PlayerJoinRequest{Id: 3, Name: "bob", State: PlayerState{Y: 30}},
+	&PlayerJoinResponse{},
+	&RemovePlayerRequest{Id: 3},
+	&RemovePlayerResponse{},
+	&SyncTimeRequest{Ticks: 123456789},
+	&SyncTimeResponse{},
+	&SyncWeatherRequest{State: world.WeatherState{Type: 1, Remaining: 12.5}},
+	&SyncWeatherResponse{},
+	&HandshakeRequest{Protocol: ProtocolVersion, ClientID: 5, Capabilities: CapPlayers | CapBlocks,
+		Server: ServerInfo{MOTD: "hi", Dimension: "overworld", Seed: -42, Players: 3, MaxPlayers: 10, BlockHash: "00ff"}},
+	&HandshakeResponse{Protocol: ProtocolVersion, Name: "alice", Capabilities: CapWorldSync},
+	&AcceptRequest{Capabilities: CapBlocks},
+	&AcceptResponse{},
+	&RejectRequest{Reason: "server is full"},
+	&RejectResponse{},
+	&SyncPlayerRequest{Player: world.PlayerRecord{Name: "alice", Dimension: "overworld", Pos: mgl32.Vec3{1, 2, 3}, Flying: true, Health: 20,
+		Inventory: world.Inventory{Selected: 24}, Respawn: &world.RespawnPoint{Dimension: "overworld", Pos: world.Vec3{X: 1, Y: 14, Z: -2}}}},
+	&SyncPlayerResponse{},
+}
+
+func TestMessages(t *testing.T) {
+	for _, m := range testMessages {
+		e := &encoder{}
+		m.encode(e)
+		got := reflect.New(reflect.TypeOf(m).Elem()).Interface().(message)
+		d := &decoder{buf: e.buf}
+		got.decode(d)
+		if err := d.done(); err != nil {
+			t.Fatalf("%T: %v", m, err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%T: got %+v, want %+v", m, got, m)
+		}
+		// 截断的消息返回错误而不是 panic
+		for n := 0; n < len(e.buf); n++ {
+			d := &decoder{buf: e.buf[:n]}
+			got.decode(d)
+			if d.done() == nil {
+				t.Errorf("%T: decoded %d of %d bytes", m, n, len(e.buf))
+			}
+		}
+	}
+}
+
+type echoService struct{}
+
+func (echoService) SyncTime(req *SyncTimeRequest, rep *SyncTimeResponse) error {
+	if req.Ticks < 0 {
+		return errors.New("negative ticks")
+	}
+	return nil
+}
+
+func (echoService) Handshake(req *HandshakeRequest, rep *HandshakeResponse) error {
+	rep.Name = "alice"
+	return nil
+}
+
+func TestCodec(t *testing.T) {
+	a, b := net.Pipe()
+	serve(b)
+	c := rpc.NewClientWithCodec(newClientCodec(a))
+	defer c.Close()
+
+	rep := new(HandshakeResponse)
+	if err := c.Call("Status.Handshake", &HandshakeRequest{ClientID: 1}, rep); err != nil || rep.Name != "alice" {
+		t.Fatalf("call %+v %v", rep, err)
+	}
+	err := c.Call("Status.SyncTime", &SyncTimeRequest{Ticks: -1}, new(SyncTimeResponse))
+	if err == nil || err.Error() != "negative ticks" {
+		t.Fatalf("error %v", err)
+	}
+	// 对方没有注册的服务
+	if err := c.Call("Block.FetchChunk", &FetchChunkRequest{}, new(FetchChunkResponse)); err == nil {
+		t.Fatal("call to missing service")
+	}
+	if err := c.Call("Status.Handshake", &HandshakeRequest{}, rep); err != nil {
+		t.Fatalf("call after error: %v", err)
+	}
+}
+
+// serve 在 conn 上运行新的服务, 返回的 channel 在服务结束后关闭
+func serve(conn net.Conn) (*codec, chan bool) {
+	server := newServerCodec(conn)
+	srv := rpc.NewServer()
+	srv.RegisterName("Status", echoService{})
+	done := make(chan bool)
+	go func() {
+		srv.ServeCodec(server)
+		close(done)
+	}()
+	return server, done
+}
+
+func TestVersionMismatch(t *testing.T) {
+	a, b := net.Pipe()
+	server, done := serve(b)
+
+	// 新版本的调用方收到被调用方的版本
+	go func() {
+		a.Write([]byte(shimLine))
+		a.Write(append(protocolMagic[:], 0, ProtocolVersion+1))
+	}()
+	b6 := make([]byte, 6)
+	if _, err := io.ReadFull(a, b6); err != nil || string(b6) != string(append(protocolMagic[:], 0, ProtocolVersion)) {
+		t.Fatalf("callee version %q %v", b6, err)
+	}
+	<-done
+	if verr := server.Err(); !errors.Is(verr, ErrVersion) || !strings.Contains(verr.Error(), fmt.Sprintf("peer speaks v%d", ProtocolVersion+1)) {
+		t.Fatalf("callee error %v", verr)
+	}
+}
+
+func TestOldJSONPeer(t *testing.T) {
+	// 旧的 JSON-RPC 调用方收到版本错误
+	a, b := net.Pipe()
+	server, done := serve(b)
+	old := jsonrpc.NewClient(a)
+	err := old.Call("Status.Handshake", &HandshakeRequest{}, new(HandshakeResponse))
+	if err == nil || !strings.Contains(err.Error(), "binary protocol") {
+		t.Fatalf("old caller error %v", err)
+	}
+	<-done
+	old.Close()
+	if !errors.Is(server.Err(), ErrVersion) {
+		t.Fatalf("callee error %v", server.Err())
+	}
+
+	// 旧的 JSON-RPC 被调用方回复 shimLine, 新的调用方报告版本错误
+	a, b = net.Pipe()
+	oldSrv := rpc.NewServer()
+	oldSrv.RegisterName("Status", echoService{})
+	go oldSrv.ServeCodec(jsonrpc.NewServerCodec(b))
+	codec := newClientCodec(a)
+	c := rpc.NewClientWithCodec(codec)
+	defer c.Close()
+	if err := c.Call("Status.Handshake", &HandshakeRequest{}, new(HandshakeResponse)); err == nil {
+		t.Fatal("call to old callee")
+	}
+	var verr *VersionError
+	if err := codec.peerError(time.Second); !errors.As(err, &verr) || verr.Peer != 0 {
+		t.Fatalf("caller error %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+var errShortMessage = errors.New("short message")
+
+// message 可以用二进制协议发送的请求和响应, 字段按顺序编码, 没有字段名
+type message interface {
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) int(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *encoder) float32(v float32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) float64(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.uint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// decoder 出错后不再读取, 所有的值为 0, 最后检查 err
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+func (d *decoder) uint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail(errShortMessage)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) int() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail(errShortMessage)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) next(n int) []byte {
+	if len(d.buf) < n {
+		d.fail(errShortMessage)
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) float32() float32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+func (d *decoder) float64() float64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	b := d.next(1)
+	if b == nil {
+		return false
+	}
+	if b[0] > 1 {
+		d.fail(errors.New("bad bool"))
+	}
+	return b[0] == 1
+}
+
+// count 读取长度, 每个元素至少 min 字节, 不能超过剩下的数据
+func (d *decoder) count(min int) int {
+	n := d.uint()
+	if n > uint64(len(d.buf)/min) {
+		d.fail(errShortMessage)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes() []byte {
+	b := d.next(d.count(1))
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+func (d *decoder) string() string {
+	return string(d.next(d.count(1)))
+}
+
+func (d *decoder) done() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = errors.New("extra bytes after message")
+	}
+	return d.err
+}
+
+// block service
+
+func (m *UpdateBlockRequest) encode(e *encoder) {
+	e.int(int64(m.Id))
+	e.int(int64(m.P))
+	e.int(int64(m.Q))
+	e.int(int64(m.X))
+	e.int(int64(m.Y))
+	e.int(int64(m.Z))
+	e.bool(m.Block != nil)
+	if m.Block != nil {
+		e.int(int64(m.Block.Type))
+		e.int(int64(m.Block.Life))
+	}
+	e.string(m.Version)
+}
+
+func (m *UpdateBlockRequest) decode(d *decoder) {
+	m.Id = int32(d.int())
+	m.P = int(d.int())
+	m.Q = int(d.int())
+	m.X = int(d.int())
+	m.Y = int(d.int())
+	m.Z = int(d.int())
+	m.Block = nil
+	if d.bool() {
+		m.Block = &world.Block{Type: int(d.int()), Life: int(d.int())}
+	}
+	m.Version = d.string()
+}
+
+func (m *UpdateBlockResponse) encode(e *encoder) { e.string(m.Version) }
+func (m *UpdateBlockResponse) decode(d *decoder) { m.Version = d.string() }
+
+func (m *FetchChunkRequest) encode(e *encoder) {
+	e.int(int64(m.P))
+	e.int(int64(m.Q))
+	e.string(m.Version)
+}
+
+func (m *FetchChunkRequest) decode(d *decoder) {
+	m.P = int(d.int())
+	m.Q = int(d.int())
+	m.Version = d.string()
+}
+
+// FetchChunkResponse 只发送 Data, 旧的 Blocks 格式只在 JSON-RPC 中使用
+func (m *FetchChunkResponse) encode(e *encoder) {
+	e.bytes(m.Data)
+	e.string(m.Version)
+}
+
+func (m *FetchChunkResponse) decode(d *decoder) {
+	m.Blocks = nil
+	m.Data = d.bytes()
+	m.Version = d.string()
+}
+
+// player service
+
+func (m *PlayerState) encode(e *encoder) {
+	e.float32(m.X)
+	e.float32(m.Y)
+	e.float32(m.Z)
+	e.float32(m.Rx)
+	e.float32(m.Ry)
+}
+
+func (m *PlayerState) decode(d *decoder) {
+	m.X = d.float32()
+	m.Y = d.float32()
+	m.Z = d.float32()
+	m.Rx = d.float32()
+	m.Ry = d.float32()
+}
+
+func (m *UpdateStateRequest) encode(e *encoder) {
+	e.int(int64(m.Id))
+	m.State.encode(e)
+}
+
+func (m *UpdateStateRequest) decode(d *decoder) {
+	m.Id = int32(d.int())
+	m.State.decode(d)
+}
+
+func (m *UpdateStateResponse) encode(e *encoder) {
+	e.uint(uint64(len(m.Players)))
+	for id, s := range m.Players {
+		e.int(int64(id))
+		s.encode(e)
+	}
+}
+
+func (m *UpdateStateResponse) decode(d *decoder) {
+	// 每个玩家至少 1 字节的 id 和 20 字节的状态
+	n := d.count(21)
+	m.Players = make(map[int32]PlayerState, n)
+	for i := 0; i < n; i++ {
+		id := int32(d.int())
+		var s PlayerState
+		s.decode(d)
+		m.Players[id] = s
+	}
+}
+
+func (m *PlayerJoinRequest) encode(e *encoder) {
+	e.int(int64(m.Id))
+	e.string(m.Name)
+	m.State.encode(e)
+}
+
+func (m *PlayerJoinRequest) decode(d *decoder) {
+	m.Id = int32(d.int())
+	m.Name = d.string()
+	m.State.decode(d)
+}
+
+func (m *PlayerJoinResponse) encode(e *encoder) {}
+func (m *PlayerJoinResponse) decode(d *decoder) {}
+
+func (m *RemovePlayerRequest) encode(e *encoder) { e.int(int64(m.Id)) }
+func (m *RemovePlayerRequest) decode(d *decoder) { m.Id = int32(d.int()) }
+
+func (m *RemovePlayerResponse) encode(e *encoder) {}
+func (m *RemovePlayerResponse) decode(d *decoder) {}
+
+// status service
+
+func (m *SyncTimeRequest) encode(e *encoder) { e.int(m.Ticks) }
+func (m *SyncTimeRequest) decode(d *decoder) { m.Ticks = d.int() }
+
+func (m *SyncTimeResponse) encode(e *encoder) {}
+func (m *SyncTimeResponse) decode(d *decoder) {}
+
+func (m *SyncWeatherRequest) encode(e *encoder) {
+	e.int(int64(m.State.Type))
+	e.float64(m.State.Remaining)
+}
+
+func (m *SyncWeatherRequest) decode(d *decoder) {
+	m.State.Type = world.WeatherType(d.int())
+	m.State.Remaining = d.float64()
+}
+
+func (m *SyncWeatherResponse) encode(e *encoder) {}
+func (m *SyncWeatherResponse) decode(d *decoder) {}
+
+func (m *HandshakeRequest) encode(e *encoder) {
+	e.int(int64(m.Protocol))
+	e.int(int64(m.ClientID))
+	e.uint(uint64(m.Capabilities))
+	e.string(m.Server.MOTD)
+	e.string(m.Server.Dimension)
+	e.int(m.Server.Seed)
+	e.int(int64(m.Server.Players))
+	e.int(int64(m.Server.MaxPlayers))
+	e.string(m.Server.BlockHash)
+}
+
+func (m *HandshakeRequest) decode(d *decoder) {
+	m.Protocol = int(d.int())
+	m.ClientID = int32(d.int())
+	m.Capabilities = Capability(d.uint())
+	m.Server.MOTD = d.string()
+	m.Server.Dimension = d.string()
+	m.Server.Seed = d.int()
+	m.Server.Players = int(d.int())
+	m.Server.MaxPlayers = int(d.int())
+	m.Server.BlockHash = d.string()
+}
+
+func (m *HandshakeResponse) encode(e *encoder) {
+	e.int(int64(m.Protocol))
+	e.string(m.Name)
+	e.uint(uint64(m.Capabilities))
+}
+
+func (m *HandshakeResponse) decode(d *decoder) {
+	m.Protocol = int(d.int())
+	m.Name = d.string()
+	m.Capabilities = Capability(d.uint())
+}
+
+func (m *AcceptRequest) encode(e *encoder) { e.uint(uint64(m.Capabilities)) }
+func (m *AcceptRequest) decode(d *decoder) { m.Capabilities = Capability(d.uint()) }
+
+func (m *AcceptResponse) encode(e *encoder) {}
+func (m *AcceptResponse) decode(d *decoder) {}
+
+func (m *RejectRequest) encode(e *encoder) { e.string(m.Reason) }
+func (m *RejectRequest) decode(d *decoder) { m.Reason = d.string() }
+
+func (m *RejectResponse) encode(e *encoder) {}
+func (m *RejectResponse) decode(d *decoder) {}
+
+func (m *SyncPlayerRequest) encode(e *encoder) {
+	p := &m.Player
+	e.string(p.Name)
+	e.string(p.Dimension)
+	for _, v := range p.Pos {
+		e.float32(v)
+	}
+	e.float32(p.Rx)
+	e.float32(p.Ry)
+	e.bool(p.Flying)
+	e.int(int64(p.Health))
+	e.int(int64(p.Inventory.Selected))
+	e.bool(p.Respawn != nil)
+	if p.Respawn != nil {
+		e.string(p.Respawn.Dimension)
+		e.int(int64(p.Respawn.Pos.X))
+		e.int(int64(p.Respawn.Pos.Y))
+		e.int(int64(p.Respawn.Pos.Z))
+	}
+}
+
+func (m *SyncPlayerRequest) decode(d *decoder) {
+	p := &m.Player
+	p.Name = d.string()
+	p.Dimension = d.string()
+	for i := range p.Pos {
+		p.Pos[i] = d.float32()
+	}
+	p.Rx = d.float32()
+	p.Ry = d.float32()
+	p.Flying = d.bool()
+	p.Health = int(d.int())
+	p.Inventory.Selected = int(d.int())
+	p.Respawn = nil
+	if d.bool() {
+		p.Respawn = &world.RespawnPoint{Dimension: d.string()}
+		p.Respawn.Pos = world.Vec3{X: int(d.int()), Y: int(d.int()), Z: int(d.int())}
+	}
+}
+
+func (m *SyncPlayerResponse) encode(e *encoder) {}
+func (m *SyncPlayerResponse) decode(d *decoder) {}
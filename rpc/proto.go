@@ -1,4 +1,6 @@
-package world
+package rpc
+
+import "github.com/humboldt-xie/tinycraft/world"
 
 // block service
 
@@ -6,7 +8,7 @@ type UpdateBlockRequest struct {
 	Id      int32
 	P, Q    int
 	X, Y, Z int
-	Block   *Block
+	Block   *world.Block
 	Version string // used by server
 }
 
@@ -20,7 +22,8 @@ type FetchChunkRequest struct {
 }
 
 type FetchChunkResponse struct {
-	Blocks  [][4]int
+	Blocks  [][4]int // 旧的格式, 只有 Data 为空时使用
+	Data    []byte   // EncodeChunk 编码的方块
 	Version string
 }
 
@@ -40,9 +43,92 @@ type UpdateStateResponse struct {
 	Players map[int32]PlayerState
 }
 
+type PlayerJoinRequest struct {
+	Id    int32
+	Name  string
+	State PlayerState
+}
+
+type PlayerJoinResponse struct {
+}
+
 type RemovePlayerRequest struct {
 	Id int32
 }
 
 type RemovePlayerResponse struct {
 }
+
+// status service
+
+type SyncTimeRequest struct {
+	Ticks int64
+}
+
+type SyncTimeResponse struct {
+}
+
+type SyncWeatherRequest struct {
+	State world.WeatherState
+}
+
+type SyncWeatherResponse struct {
+}
+
+// Capability 客户端或者服务器支持的功能, 握手时取双方都支持的
+type Capability uint64
+
+const (
+	// CapPlayers 同步其他玩家的加入, 位置和离开
+	CapPlayers Capability = 1 << iota
+	// CapBlocks 推送其他玩家修改的方块
+	CapBlocks
+	// CapWorldSync 同步世界时间和天气
+	CapWorldSync
+)
+
+// ServerInfo 握手时服务器发送给客户端的信息
+type ServerInfo struct {
+	MOTD       string
+	Dimension  string
+	Seed       int64
+	Players    int // 在线的玩家, 不包括新连接的客户端
+	MaxPlayers int // 0 不限制
+	BlockHash  string
+}
+
+type HandshakeRequest struct {
+	Protocol     int
+	ClientID     int32
+	Capabilities Capability
+	Server       ServerInfo
+}
+
+type HandshakeResponse struct {
+	Protocol     int
+	Name         string // 玩家的名字, 服务器用来读取和保存玩家
+	Capabilities Capability
+}
+
+// AcceptRequest 服务器接受了客户端, 玩家已经恢复
+type AcceptRequest struct {
+	Capabilities Capability // 双方都支持的功能
+}
+
+type AcceptResponse struct {
+}
+
+// RejectRequest 服务器拒绝客户端的原因, 之后断开连接
+type RejectRequest struct {
+	Reason string
+}
+
+type RejectResponse struct {
+}
+
+type SyncPlayerRequest struct {
+	Player world.PlayerRecord
+}
+
+type SyncPlayerResponse struct {
+}
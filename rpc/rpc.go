@@ -1,42 +1,191 @@
-package world
+package rpc
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unicode"
 
+	"github.com/go-gl/mathgl/mgl32"
 	"github.com/hashicorp/yamux"
+	"github.com/humboldt-xie/tinycraft/world"
 )
 
 var (
 	serverAddr = flag.String("s", "", "server address")
 	listenAddr = flag.String("l", "", "listen address")
+	maxReach   = flag.Float64("reach", 10, "max distance from a player to the blocks it changes on the server")
+	viewRange  = flag.Float64("player-range", 128, "max distance of the other players the server sends to a client")
+	motd       = flag.String("motd", "", "message of the day the server shows to joining clients")
+	maxPlayers = flag.Int("max-players", 0, "max players online on the server, 0 for no limit")
 
 	client *Client
+
+	// BlockUpdated 客户端收到服务器推送的方块时调用, 游戏用来修改世界并更新渲染. 为 nil 时只修改世界
+	BlockUpdated func(id world.Vec3, b *world.Block)
+	// PlayerUpdated 客户端收到其他玩家加入或者新的位置时调用, name 只在加入时不为空
+	PlayerUpdated func(id int32, name string, s world.Position)
+	// PlayerRemoved 其他玩家断开连接时调用
+	PlayerRemoved func(id int32)
 )
 
+func playerState(p world.Position) PlayerState {
+	return PlayerState{X: p.X(), Y: p.Y(), Z: p.Z(), Rx: p.Rx, Ry: p.Ry}
+}
+
+func (s PlayerState) position() world.Position {
+	return world.Position{Vec3: mgl32.Vec3{s.X, s.Y, s.Z}, Rx: s.Rx, Ry: s.Ry}
+}
+
+// serverCapabilities 服务器支持的功能
+const serverCapabilities = CapPlayers | CapBlocks | CapWorldSync
+
+// maxNameLen 玩家名字的最大长度
+const maxNameLen = 32
+
+// sendQueue 每个客户端等待发送的调用数, 超过时客户端跟不上, 断开连接
+const sendQueue = 256
+
 type Session struct {
 	ClientID   int32
 	masterConn net.Conn
 	*rpc.Client
+	player *world.Player
+	caps   Capability // 握手时双方都支持的功能
+
+	out  chan *outCall
+	drop sync.Once
+}
+
+// outCall 等待发送给客户端的调用, 不需要回复
+type outCall struct {
+	method     string
+	req, reply interface{}
+}
+
+func newSession(id int32, conn net.Conn, c *rpc.Client) *Session {
+	return &Session{
+		ClientID:   id,
+		masterConn: conn,
+		Client:     c,
+		out:        make(chan *outCall, sendQueue),
+	}
+}
+
+// send 把调用放入发送队列, 不等待客户端. 队列满时断开连接, 慢的客户端不影响其他客户端
+func (sess *Session) send(method string, req, reply interface{}) {
+	select {
+	case sess.out <- &outCall{method: method, req: req, reply: reply}:
+	default:
+		sess.drop.Do(func() {
+			log.Printf("client %d falls behind, disconnecting", sess.ClientID)
+			sess.masterConn.Close()
+		})
+	}
+}
+
+// writeLoop 按顺序发送队列中的调用, 直到 done 关闭
+func (sess *Session) writeLoop(done chan bool) {
+	for {
+		select {
+		case c := <-sess.out:
+			sess.Go(c.method, c.req, c.reply, nil)
+		case <-done:
+			return
+		}
+	}
 }
 
 type Server struct {
-	*rpc.Server
+	world    *world.World
 	clientid int32
 	sessions sync.Map
+	// joinMutex 通知玩家加入和离开时同时修改 sessions, 同时加入的玩家也能互相收到通知
+	joinMutex sync.Mutex
+
+	// MOTD 和 MaxPlayers 默认为 -motd 和 -max-players, 在 Serve 前修改
+	MOTD       string
+	MaxPlayers int
+
+	mutex    sync.Mutex
+	online   map[int32]string // admit 接受的客户端的名字
+	listener net.Listener
+	conns    map[net.Conn]bool
+	wg       sync.WaitGroup
+	done     chan bool
+	closed   bool
+}
+
+// NewServer 创建 w 的服务器, 用 Serve 接受连接
+func NewServer(w *world.World) *Server {
+	return &Server{
+		world:      w,
+		MOTD:       *motd,
+		MaxPlayers: *maxPlayers,
+		online:     make(map[int32]string),
+		conns:      make(map[net.Conn]bool),
+		done:       make(chan bool),
+	}
+}
+
+// Info 握手时发送给客户端的服务器信息
+func (s *Server) Info() ServerInfo {
+	dim := s.world.Dimension()
+	s.mutex.Lock()
+	players := len(s.online)
+	s.mutex.Unlock()
+	return ServerInfo{
+		MOTD:       s.MOTD,
+		Dimension:  dim.Name,
+		Seed:       dim.Generator.Seed,
+		Players:    players,
+		MaxPlayers: s.MaxPlayers,
+		BlockHash:  world.BlockRegistryHash(),
+	}
+}
+
+// admit 检查握手的回复, 返回拒绝客户端的原因, 为空时接受.
+// 接受时同时预留名字和位置, 客户端断开后用 release 释放
+func (s *Server) admit(id int32, rep *HandshakeResponse) string {
+	if rep.Protocol != ProtocolVersion {
+		return fmt.Sprintf("protocol version mismatch: server speaks v%d, client speaks v%d", ProtocolVersion, rep.Protocol)
+	}
+	if len(rep.Name) > maxNameLen || strings.IndexFunc(rep.Name, unicode.IsControl) != -1 {
+		return fmt.Sprintf("bad player name %q", rep.Name)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// 没有名字的客户端不保存, 可以有多个
+	for _, name := range s.online {
+		if rep.Name != "" && name == rep.Name {
+			return fmt.Sprintf("player %s is already online", rep.Name)
+		}
+	}
+	if s.MaxPlayers > 0 && len(s.online) >= s.MaxPlayers {
+		return fmt.Sprintf("server is full (%d/%d players)", len(s.online), s.MaxPlayers)
+	}
+	s.online[id] = rep.Name
+	return ""
+}
+
+// release 释放 admit 预留的名字和位置
+func (s *Server) release(id int32) {
+	s.mutex.Lock()
+	delete(s.online, id)
+	s.mutex.Unlock()
 }
 
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
 	id := atomic.AddInt32(&s.clientid, 1)
 	log.Printf("allocated %d for %s", id, conn.RemoteAddr())
-	// send id to client, handshake done.
 
 	ysess, err := yamux.Server(conn, nil)
 	if err != nil {
@@ -50,77 +199,308 @@ func (s *Server) handleConn(conn net.Conn) {
 		return
 	}
 
-	sess := &Session{
-		ClientID:   id,
-		masterConn: conn,
-		Client:     rpc.NewClientWithCodec(jsonrpc.NewClientCodec(clientConn)),
-	}
+	codec := newClientCodec(clientConn)
+	sess := newSession(id, conn, rpc.NewClientWithCodec(codec))
 	defer sess.Client.Close()
 	defer sess.masterConn.Close()
-
-	divCall := sess.Go("Status.InitClient", &InitClientRequest{ClientID: id}, new(InitClientResponse), nil)
-	replyCall := <-divCall.Done // will be equal to divCall
-
-	if replyCall.Error != nil {
-		log.Print(replyCall.Error)
+	done := make(chan bool)
+	defer close(done)
+	go sess.writeLoop(done)
+
+	// 握手: 发送服务器的信息, 客户端回复名字和支持的功能, 服务器决定接受或者拒绝
+	info := s.Info()
+	hs := &HandshakeRequest{Protocol: ProtocolVersion, ClientID: id, Capabilities: serverCapabilities, Server: info}
+	hsRep := new(HandshakeResponse)
+	err = sess.Call("Status.Handshake", hs, hsRep)
+	if err != nil {
+		// 旧的客户端可能在回复后就关闭了 stream, 调用的错误不一定是版本错误
+		if perr := codec.peerError(time.Second); perr != nil {
+			err = perr
+		}
+		log.Printf("%s(%d) init error:%s", conn.RemoteAddr(), id, err)
+		var verr *VersionError
+		if errors.As(err, &verr) && verr.Peer == 0 {
+			// 旧的客户端已经收到 shimLine, 它的调用都回复版本错误
+			sconn, err := ysess.Accept()
+			if err == nil {
+				rejectJSONCalls(sconn)
+			}
+		}
 		return
 	}
 
-	s.sessions.Store(id, sess)
+	name := hsRep.Name
+	if reason := s.admit(id, hsRep); reason != "" {
+		log.Printf("%s(%d) rejected %s: %s", conn.RemoteAddr(), id, name, reason)
+		// 等客户端收到原因后再断开
+		err := sess.Call("Status.Reject", &RejectRequest{Reason: reason}, new(RejectResponse))
+		if err != nil {
+			log.Printf("%s(%d) reject error:%s", conn.RemoteAddr(), id, err)
+		}
+		return
+	}
+	defer s.release(id)
+	sess.caps = hsRep.Capabilities & serverCapabilities
+	sess.player = world.NewPlayer(s.world.Spawn(), nil, nil)
+	sess.player.Name = name
+	err = s.world.Join(sess.player)
+	if err != nil {
+		log.Printf("load player %s error:%s", name, err)
+	}
+	s.world.PinArea(sess, world.NearBlock(sess.player.State().Vec3).Chunkid(), 1)
+	defer func() {
+		s.world.PinArea(sess, world.Vec3{}, -1)
+		err := s.world.Leave(sess.player)
+		if err != nil {
+			log.Printf("save player %s error:%s", name, err)
+		}
+	}()
 
-	//s.playerCallback("online", id)
+	s.join(sess)
+	defer s.leave(sess)
+	// 客户端收到 Accept 时玩家已经恢复
+	record := sess.player.Record(s.world.Dimension().Name)
+	err = sess.Call("Status.SyncPlayer", &SyncPlayerRequest{Player: *record}, new(SyncPlayerResponse))
+	if err != nil {
+		log.Printf("%s(%d) sync player error:%s", conn.RemoteAddr(), id, err)
+		return
+	}
+	if sess.caps&CapWorldSync != 0 {
+		sess.send("Status.SyncTime", &SyncTimeRequest{Ticks: s.world.Clock().Ticks()}, new(SyncTimeResponse))
+		sess.send("Status.SyncWeather", &SyncWeatherRequest{State: s.world.Weather().State()}, new(SyncWeatherResponse))
+	}
+	sess.send("Status.Accept", &AcceptRequest{Capabilities: sess.caps}, new(AcceptResponse))
 
-	//serveRpc(sess)
 	sconn, err := ysess.Accept()
 	if err != nil {
 		log.Print(err)
 		return
 	}
-	s.ServeCodec(jsonrpc.NewServerCodec(sconn))
+	// 每个连接的服务知道请求来自哪个玩家
+	srv := rpc.NewServer()
+	srv.RegisterName("Block", &BlockService{world: s.world, server: s, sess: sess})
+	srv.RegisterName("Player", &PlayerService{server: s, sess: sess})
+	srv.ServeCodec(newServerCodec(sconn))
 
-	s.sessions.Delete(id)
-	//s.playerCallback("offline", id)
 	log.Printf("%s(%d) closed connection", conn.RemoteAddr(), id)
 }
 
-func (s *Server) Serve(l net.Listener) {
+// broadcast 发送给支持 c 的客户端
+// join 新玩家和已经在线的玩家互相通知, 然后加入 sessions
+func (s *Server) join(sess *Session) {
+	s.joinMutex.Lock()
+	defer s.joinMutex.Unlock()
+	join := &PlayerJoinRequest{Id: sess.ClientID, Name: sess.player.Name, State: playerState(sess.player.State())}
+	s.broadcast(CapPlayers, "Player.PlayerJoin", join, func() interface{} { return new(PlayerJoinResponse) })
+	if sess.caps&CapPlayers != 0 {
+		s.sessions.Range(func(k, v interface{}) bool {
+			other := v.(*Session)
+			req := &PlayerJoinRequest{Id: other.ClientID, Name: other.player.Name, State: playerState(other.player.State())}
+			sess.send("Player.PlayerJoin", req, new(PlayerJoinResponse))
+			return true
+		})
+	}
+	s.sessions.Store(sess.ClientID, sess)
+}
+
+// leave 从 sessions 中删除玩家, 通知其他玩家
+func (s *Server) leave(sess *Session) {
+	s.joinMutex.Lock()
+	defer s.joinMutex.Unlock()
+	s.sessions.Delete(sess.ClientID)
+	s.broadcast(CapPlayers, "Player.RemovePlayer", &RemovePlayerRequest{Id: sess.ClientID}, func() interface{} { return new(RemovePlayerResponse) })
+}
+
+func (s *Server) broadcast(c Capability, method string, req interface{}, newReply func() interface{}) {
+	s.broadcastExcept(0, c, method, req, newReply)
+}
+
+// broadcastExcept 发送给除了 id 以外支持 c 的客户端, 不等待慢的客户端
+func (s *Server) broadcastExcept(id int32, c Capability, method string, req interface{}, newReply func() interface{}) {
+	s.sessions.Range(func(k, v interface{}) bool {
+		sess := v.(*Session)
+		if sess.ClientID != id && sess.caps&c != 0 {
+			sess.send(method, req, newReply())
+		}
+		return true
+	})
+}
+
+// checkBlock 检查客户端对方块的修改: 方块类型, 离玩家的距离, chunk 已加载, 不在出生点保护范围内
+func (s *Server) checkBlock(sess *Session, id world.Vec3, b *world.Block) error {
+	if b == nil || b.BlockType() == nil {
+		return fmt.Errorf("bad block %v", b)
+	}
+	if b.Life < 0 || b.Life > 100 {
+		return fmt.Errorf("bad block life %d", b.Life)
+	}
+	// 玩家的位置由同一个连接上并发的 UpdateState 修改
+	pos := sess.player.State().Vec3
+	center := mgl32.Vec3{float32(id.X), float32(id.Y), float32(id.Z)}
+	if d := pos.Sub(center).Len(); d > float32(*maxReach) {
+		return fmt.Errorf("block %v out of reach (%.1f)", id, d)
+	}
+	if s.world.TryChunk(id.Chunkid()) == nil {
+		return fmt.Errorf("chunk %v not loaded", id.Chunkid())
+	}
+	if s.world.Protected(id) {
+		return fmt.Errorf("block %v is protected by spawn", id)
+	}
+	return nil
+}
+
+// syncWorldLoop 定期把世界时间和天气推送给所有客户端, 天气变化时立即推送
+func (s *Server) syncWorldLoop() {
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	events := s.world.Watcher.Watch(16)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick.C:
+			req := &SyncTimeRequest{Ticks: s.world.Clock().Ticks()}
+			s.broadcast(CapWorldSync, "Status.SyncTime", req, func() interface{} { return new(SyncTimeResponse) })
+		case ev, ok := <-events:
+			if !ok {
+				events = s.world.Watcher.Watch(16)
+				continue
+			}
+			if e, ok := ev.(world.Event); !ok || e.Type != "Weather.Update" {
+				continue
+			}
+			req := &SyncWeatherRequest{State: s.world.Weather().State()}
+			s.broadcast(CapWorldSync, "Status.SyncWeather", req, func() interface{} { return new(SyncWeatherResponse) })
+		}
+	}
+}
+
+// Serve 接受 l 上的连接, Close 后返回 nil
+func (s *Server) Serve(l net.Listener) error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
+	s.mutex.Unlock()
+	go s.syncWorldLoop()
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			log.Print(err)
-			continue
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.Print(err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return nil
 		}
-		go s.handleConn(conn)
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mutex.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.handleConn(conn)
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+		}()
+	}
+}
+
+// Close 停止接受连接并断开所有客户端, 等到所有玩家保存后返回
+func (s *Server) Close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
 	}
+	s.mutex.Unlock()
+	s.wg.Wait()
 }
 
 type Client struct {
 	*rpc.Client
 	ClientID  int32
+	Server    ServerInfo // 握手时收到的服务器信息
+	world     *world.World
 	rpcServer *rpc.Server
-	waitInit  chan bool
+	waitInit  chan error // 握手的结果
+}
+
+// handshakeDone 通知 InitClient 握手的结果, 只有第一个结果有效
+func (c *Client) handshakeDone(err error) {
+	select {
+	case c.waitInit <- err:
+	default:
+	}
 }
 
-func InitService() error {
+// clientCapabilities 客户端支持的功能, 没有设置 PlayerUpdated 时不需要其他玩家
+func clientCapabilities() Capability {
+	c := CapBlocks | CapWorldSync
+	if PlayerUpdated != nil {
+		c |= CapPlayers
+	}
+	return c
+}
+
+// InitService -l 不为空时在游戏中启动服务器
+func InitService(w *world.World) (*Server, error) {
 	if *listenAddr == "" {
-		return nil
+		return nil, nil
 	}
 	l, err := net.Listen("tcp", *listenAddr)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	server := &Server{
-		Server: rpc.NewServer(),
-	}
-	server.RegisterName("Block", &BlockService{})
-	server.RegisterName("Player", &PlayerService{})
-	go server.Serve(l)
-	return nil
+	server := NewServer(w)
+	go func() {
+		err := server.Serve(l)
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+	return server, nil
+}
+
+// handshakeTimeout 客户端等待服务器握手的时间
+const handshakeTimeout = 10 * time.Second
+
+// RejectError 服务器在握手时拒绝了客户端
+type RejectError struct {
+	Reason string
 }
 
-func InitClient() error {
+func (e *RejectError) Error() string {
+	return "server rejected the connection: " + e.Reason
+}
+
+// InitClient -s 不为空时连接服务器, 玩家 p 的状态由服务器恢复, 返回服务器的信息.
+// 服务器拒绝时返回 *RejectError, 协议不同时返回 *VersionError
+func InitClient(w *world.World, p *world.Player) (*ServerInfo, error) {
 	if *serverAddr == "" {
-		return nil
+		return nil, nil
 	}
 	addr := *serverAddr
 	if strings.Index(addr, ":") == -1 {
@@ -128,65 +508,116 @@ func InitClient() error {
 	}
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	client = &Client{
+	c := &Client{
+		world:     w,
 		rpcServer: rpc.NewServer(),
-		waitInit:  make(chan bool, 1),
+		waitInit:  make(chan error, 1),
 	}
-	client.rpcServer.RegisterName("Block", &BlockService{})
-	client.rpcServer.RegisterName("Player", &PlayerService{})
-	client.rpcServer.RegisterName("Status", &StatusService{})
+	c.rpcServer.RegisterName("Block", &BlockService{world: w})
+	c.rpcServer.RegisterName("Player", &PlayerService{})
+	c.rpcServer.RegisterName("Status", &StatusService{world: w, player: p, client: c})
 
 	sess, err := yamux.Client(conn, nil)
 	if err != nil {
-		log.Panic(err)
+		conn.Close()
+		return nil, err
 	}
-
 	clientConn, err := sess.Open()
 	if err != nil {
-		log.Panic(err)
+		sess.Close()
+		return nil, err
 	}
-	client.Client = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(clientConn))
-
+	c.Client = rpc.NewClientWithCodec(newClientCodec(clientConn))
 	clientService, err := sess.Accept()
 	if err != nil {
-		log.Panic(err)
+		c.Close()
+		sess.Close()
+		return nil, err
 	}
 
-	go client.rpcServer.ServeCodec(jsonrpc.NewServerCodec(clientService))
-	<-client.waitInit
-	return nil
+	codec := newServerCodec(clientService)
+	served := make(chan bool)
+	go func() {
+		c.rpcServer.ServeCodec(codec)
+		close(served)
+	}()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	select {
+	case err = <-c.waitInit:
+	case <-served:
+		// 服务器拒绝后断开连接时原因已经在 waitInit 中
+		select {
+		case err = <-c.waitInit:
+		default:
+			err = codec.Err()
+			if err == nil {
+				err = errors.New("server closed the connection during handshake")
+			}
+		}
+	case <-timeout.C:
+		err = errors.New("server handshake timed out")
+	}
+	if err != nil {
+		c.Close()
+		sess.Close()
+		return nil, err
+	}
+	client = c
+	// 之后加载的 chunk 从服务器获取
+	w.SetRemote(ClientFetchChunk)
+	return &c.Server, nil
 }
 
-func ClientFetchChunk(id Vec3, f func(bid Vec3, w *Block)) {
-	if client == nil {
-		return
+// ClientFetchChunk 从服务器获取 chunk 写入客户端的 store, 版本和本地相同时服务器不发送方块
+func ClientFetchChunk(id world.Vec3) error {
+	c := client
+	if c == nil {
+		return nil
 	}
 	req := FetchChunkRequest{
 		P:       id.X,
 		Q:       id.Z,
-		Version: store.GetChunkVersion(id),
+		Version: c.world.ChunkVersion(id),
 	}
 	rep := new(FetchChunkResponse)
-	err := client.Call("Block.FetchChunk", req, rep)
+	err := c.Call("Block.FetchChunk", &req, rep)
 	if err == rpc.ErrShutdown {
-		return
+		return nil
 	}
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if req.Version == rep.Version {
+		return nil
 	}
-	for _, b := range rep.Blocks {
-		f(Vec3{b[0], b[1], b[2]}, NewBlock(b[3]))
+	var blocks map[world.Vec3]*world.Block
+	if rep.Data != nil {
+		blocks, err = world.DecodeChunk(id, rep.Data)
+		if err != nil {
+			return err
+		}
+	} else {
+		// 旧的 JSON-RPC 格式
+		blocks = make(map[world.Vec3]*world.Block, len(rep.Blocks))
+		for _, b := range rep.Blocks {
+			bid := world.Vec3{X: b[0], Y: b[1], Z: b[2]}
+			blocks[bid] = world.NewBlock(b[3])
+		}
 	}
-	if req.Version != rep.Version {
-		store.UpdateChunkVersion(id, rep.Version)
+	_, err = c.world.ImportChunk(id, blocks)
+	if err != nil {
+		return err
 	}
+	return c.world.UpdateChunkVersion(id, rep.Version)
 }
 
-func ClientUpdateBlock(id Vec3, w *Block) {
+// ClientUpdateBlock 把方块的修改发送给服务器, 服务器拒绝时返回错误
+func ClientUpdateBlock(id world.Vec3, w *world.Block) error {
 	if client == nil {
-		return
+		return nil
 	}
 	cid := id.Chunkid()
 	req := &UpdateBlockRequest{
@@ -201,97 +632,210 @@ func ClientUpdateBlock(id Vec3, w *Block) {
 	rep := new(UpdateBlockResponse)
 	err := client.Call("Block.UpdateBlock", req, rep)
 	if err == rpc.ErrShutdown {
-		return
+		return nil
 	}
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
-	store.UpdateChunkVersion(id.Chunkid(), rep.Version)
+	return client.world.UpdateChunkVersion(id.Chunkid(), rep.Version)
 }
 
-func ClientUpdatePlayerState(state Position) {
+// ClientUpdatePlayerState 上传玩家的位置, 用 PlayerUpdated 通知服务器返回的附近的玩家
+func ClientUpdatePlayerState(state world.Position) error {
 	if client == nil {
-		return
+		return nil
 	}
 	req := &UpdateStateRequest{
-		Id: client.ClientID,
+		Id:    client.ClientID,
+		State: playerState(state),
 	}
-	s := &req.State
-	s.X, s.Y, s.Z, s.Rx, s.Ry = state.X(), state.Y(), state.Z(), state.Rx, state.Ry
 	rep := new(UpdateStateResponse)
 	err := client.Call("Player.UpdateState", req, rep)
 	if err == rpc.ErrShutdown {
-		return
+		return nil
 	}
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if PlayerUpdated != nil {
+		for id, player := range rep.Players {
+			PlayerUpdated(id, "", player.position())
+		}
 	}
+	return nil
+}
 
-	/*for id, player := range rep.Players {
-		game.playerRender.UpdateOrAdd(id, player, false)
-	}*/
+// Connected 是否连接了服务器
+func Connected() bool {
+	return client != nil
 }
 
 type StatusService struct {
+	world  *world.World
+	player *world.Player
+	client *Client
+}
+
+// Handshake 检查服务器的协议, 回复玩家的名字和客户端支持的功能
+func (s *StatusService) Handshake(req *HandshakeRequest, rep *HandshakeResponse) error {
+	rep.Protocol = ProtocolVersion
+	if req.Protocol != ProtocolVersion {
+		err := &VersionError{Local: ProtocolVersion, Peer: req.Protocol}
+		s.client.handshakeDone(err)
+		return err
+	}
+	log.Printf("handshake as client %d, %d players on %s", req.ClientID, req.Server.Players, req.Server.Dimension)
+	s.client.ClientID = req.ClientID
+	s.client.Server = req.Server
+	rep.Name = s.player.Name
+	rep.Capabilities = clientCapabilities()
+	return nil
+}
+
+// Accept 服务器接受了客户端, 握手完成
+func (s *StatusService) Accept(req *AcceptRequest, rep *AcceptResponse) error {
+	log.Printf("joined server, capabilities %b", req.Capabilities)
+	s.client.handshakeDone(nil)
+	return nil
+}
+
+// Reject 服务器拒绝了客户端, 之后会断开连接
+func (s *StatusService) Reject(req *RejectRequest, rep *RejectResponse) error {
+	s.client.handshakeDone(&RejectError{Reason: req.Reason})
+	return nil
 }
-type InitClientRequest struct {
-	ClientID int32
+
+// SyncPlayer 恢复服务器保存的玩家
+func (s *StatusService) SyncPlayer(req *SyncPlayerRequest, rep *SyncPlayerResponse) error {
+	s.player.Apply(&req.Player)
+	s.player.SetPos(req.Player.Pos)
+	return nil
 }
-type InitClientResponse struct {
+
+func (s *StatusService) SyncTime(req *SyncTimeRequest, rep *SyncTimeResponse) error {
+	s.world.Clock().SetTicks(req.Ticks)
+	return nil
 }
 
-func (s *StatusService) InitClient(req *InitClientRequest, rep *InitClientResponse) error {
-	log.Printf("init client %d\n", req.ClientID)
-	client.ClientID = req.ClientID
-	client.waitInit <- true
+func (s *StatusService) SyncWeather(req *SyncWeatherRequest, rep *SyncWeatherResponse) error {
+	s.world.Weather().SetState(req.State)
 	return nil
 }
 
+// BlockService 在服务器中 server 和 sess 不为 nil, 为发出请求的玩家服务
 type BlockService struct {
+	world  *world.World
+	server *Server
+	sess   *Session
 }
 
 func (s *BlockService) FetchChunk(req *FetchChunkRequest, rep *FetchChunkResponse) error {
-	id := Vec3{req.P, 0, req.Q}
-	version := store.GetChunkVersion(id)
+	id := world.Vec3{X: req.P, Z: req.Q}
+	version := s.world.ChunkVersion(id)
 	rep.Version = version
 	if req.Version == version {
 		return nil
 	}
-	store.RangeBlocks(id, func(bid Vec3, w *Block) {
-		rep.Blocks = append(rep.Blocks, [...]int{bid.X, bid.Y, bid.Z, w.Type})
-	})
+	// store 中只有修改, 发送加载后完整的 chunk
+	c := s.world.Chunk(id)
+	if c == nil {
+		return fmt.Errorf("chunk %v not loaded", id)
+	}
+	data, err := c.Snapshot().Encode(true)
+	if err != nil {
+		return err
+	}
+	rep.Data = data
 	return nil
 }
+
+// UpdateBlock 服务器检查并修改方块, 然后推送给其他客户端; 客户端修改服务器推送的方块
 func (s *BlockService) UpdateBlock(req *UpdateBlockRequest, rep *UpdateBlockResponse) error {
-	log.Printf("rpc::UpdateBlock:%v", *req)
-	//bid := Vec3{req.X, req.Y, req.Z}
+	id := world.Vec3{X: req.X, Y: req.Y, Z: req.Z}
+	cid := id.Chunkid()
+	if cid.X != req.P || cid.Z != req.Q {
+		return fmt.Errorf("block %v not in chunk %d,%d", id, req.P, req.Q)
+	}
+	if s.server == nil {
+		if req.Block == nil {
+			return fmt.Errorf("block %v: no block", id)
+		}
+		b := &world.Block{Type: req.Block.Type, Life: req.Block.Life}
+		if BlockUpdated != nil {
+			BlockUpdated(id, b)
+		} else {
+			s.world.UpdateBlock(id, b)
+		}
+		if req.Version != "" {
+			return s.world.UpdateChunkVersion(cid, req.Version)
+		}
+		return nil
+	}
 
-	//game.world.UpdateBlock(bid, req.Block)
-	//game.blockRender.DirtyBlock(bid)
+	err := s.server.checkBlock(s.sess, id, req.Block)
+	if err != nil {
+		log.Printf("reject block from %s(%d): %s", s.sess.player.Name, s.sess.ClientID, err)
+		return err
+	}
+	s.world.UpdateBlock(id, &world.Block{Type: req.Block.Type, Life: req.Block.Life})
+	version, err := s.world.BumpChunkVersion(cid)
+	if err != nil {
+		log.Printf("update chunk %v version error:%s", cid, err)
+	}
+	rep.Version = version
+	push := *req
+	push.Id = s.sess.ClientID
+	push.Version = version
+	s.server.broadcastExcept(s.sess.ClientID, CapBlocks, "Block.UpdateBlock", &push, func() interface{} { return new(UpdateBlockResponse) })
 	return nil
 }
 
+// PlayerService 在服务器中 server 和 sess 不为 nil
 type PlayerService struct {
+	server *Server
+	sess   *Session
 }
 
 func (s *PlayerService) UpdateState(req *UpdateStateRequest, rep *UpdateStateResponse) error {
-	/*game.playerRender.UpdateOrAdd(req.Id, req.State, false)
+	if s.server == nil {
+		return nil
+	}
+	p := s.sess.player
+	p.UpdateState(req.State.position())
+	me := p.State().Vec3
+	// 玩家附近的 chunk 保持加载, 修改方块时不需要等待
+	s.server.world.PinArea(s.sess, world.NearBlock(me).Chunkid(), 1)
 	rep.Players = make(map[int32]PlayerState)
-	game.players.Range(func(k, v interface{}) bool {
+	if s.sess.caps&CapPlayers == 0 {
+		return nil
+	}
+	s.server.sessions.Range(func(k, v interface{}) bool {
 		id := k.(int32)
-		p := v.(*Player)
-		if id == req.Id {
+		if id == s.sess.ClientID {
+			return true
+		}
+		pos := v.(*Session).player.State()
+		if pos.Sub(me).Len() > float32(*viewRange) {
 			return true
 		}
-		state := PlayerState{X: p.X(), Y: p.Y(), Z: p.Z(), Rx: p.Rx, Ry: p.Ry}
-		rep.Players[id] = state
+		rep.Players[id] = playerState(pos)
 		return true
-	})*/
+	})
+	return nil
+}
 
+// PlayerJoin 客户端: 其他玩家连接了服务器
+func (s *PlayerService) PlayerJoin(req *PlayerJoinRequest, rep *PlayerJoinResponse) error {
+	if PlayerUpdated != nil {
+		PlayerUpdated(req.Id, req.Name, req.State.position())
+	}
 	return nil
 }
 
+// RemovePlayer 客户端: 其他玩家断开了连接
 func (s *PlayerService) RemovePlayer(req *RemovePlayerRequest, rep *RemovePlayerResponse) error {
-	//game.playerRender.Remove(req.Id)
+	if PlayerRemoved != nil {
+		PlayerRemoved(req.Id)
+	}
 	return nil
 }
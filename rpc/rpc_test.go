@@ -0,0 +1,344 @@
+package rpc
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+func TestServer(t *testing.T) {
+	flag.Set("store", "mem:")
+	err := world.InitStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer world.CloseStore()
+	w := world.NewWorld(2)
+	saved := world.NewPlayer(mgl32.Vec3{}, nil, nil)
+	saved.Name = "alice"
+	w.Join(saved)
+	saved.SetPos(mgl32.Vec3{3, 40, 5})
+	if !saved.Flying() {
+		saved.FlipFlying()
+	}
+	w.Leave(saved)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(w)
+	server.MOTD = "welcome"
+	server.MaxPlayers = 1
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+
+	// 客户端恢复服务器保存的玩家
+	var seen []world.Position
+	PlayerUpdated = func(id int32, name string, s world.Position) {
+		if id == 99 {
+			seen = append(seen, s)
+		}
+	}
+	defer func() { PlayerUpdated = nil }()
+	// 客户端的 World 使用另一个维度, 不和服务器共用
+	err = world.CreateDimension(&world.Dimension{Name: "client", Generator: w.Dimension().Generator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cw, err := world.OpenWorld("client", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flag.Set("s", l.Addr().String())
+	p := world.NewPlayer(mgl32.Vec3{}, nil, nil)
+	p.Name = "alice"
+	info, err := InitClient(cw, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MOTD != "welcome" || info.Players != 0 || info.BlockHash != world.BlockRegistryHash() {
+		t.Fatalf("server info %+v", info)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.State().Vec3 != (mgl32.Vec3{3, 40, 5}) || !p.Flying() {
+		if time.Now().After(deadline) {
+			t.Fatalf("player not restored: %v", p.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 同名的玩家和超过人数的客户端被拒绝, 不影响已经连接的客户端
+	connected := client
+	dup := world.NewPlayer(mgl32.Vec3{}, nil, nil)
+	dup.Name = "alice"
+	_, err = InitClient(cw, dup)
+	var rerr *RejectError
+	if !errors.As(err, &rerr) || !strings.Contains(rerr.Reason, "already online") {
+		t.Fatalf("duplicate name: %v", err)
+	}
+	carol := world.NewPlayer(mgl32.Vec3{}, nil, nil)
+	carol.Name = "carol"
+	_, err = InitClient(cw, carol)
+	if !errors.As(err, &rerr) || !strings.Contains(rerr.Reason, "full") {
+		t.Fatalf("full server: %v", err)
+	}
+	if client != connected {
+		t.Fatal("rejected client replaced the connected one")
+	}
+
+	// 另一个玩家修改方块, 服务器检查后推送给客户端
+	pushed := make(chan world.Vec3, 1)
+	BlockUpdated = func(id world.Vec3, b *world.Block) {
+		if b.Type == 4 {
+			pushed <- id
+		}
+	}
+	defer func() { BlockUpdated = nil }()
+	bob := world.NewPlayer(mgl32.Vec3{0, 30, 0}, nil, nil)
+	bob.Name = "bob"
+	blocks := &BlockService{world: w, server: server, sess: &Session{ClientID: 99, player: bob, caps: serverCapabilities}}
+	w.Chunk(world.Vec3{})
+	update := func(id world.Vec3, b *world.Block) (*UpdateBlockResponse, error) {
+		cid := id.Chunkid()
+		rep := new(UpdateBlockResponse)
+		err := blocks.UpdateBlock(&UpdateBlockRequest{P: cid.X, Q: cid.Z, X: id.X, Y: id.Y, Z: id.Z, Block: b}, rep)
+		return rep, err
+	}
+	for _, bad := range []struct {
+		id world.Vec3
+		b  *world.Block
+	}{
+		{world.Vec3{X: 1, Y: 30, Z: 1}, nil},
+		{world.Vec3{X: 1, Y: 30, Z: 1}, &world.Block{Type: -1, Life: 100}},
+		{world.Vec3{X: 1, Y: 30, Z: 1}, &world.Block{Type: 4, Life: 200}},
+		{world.Vec3{X: 40, Y: 30, Z: 1}, world.NewBlock(4)},
+	} {
+		if _, err := update(bad.id, bad.b); err == nil {
+			t.Errorf("accepted %v at %v", bad.b, bad.id)
+		}
+	}
+	id := world.Vec3{X: 2, Y: 31, Z: 3}
+	rep, err := update(id, world.NewBlock(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Version == "" || w.ChunkVersion(id.Chunkid()) != rep.Version || w.Block(id).Type != 4 {
+		t.Fatalf("update: version %q, block %v", rep.Version, w.Block(id))
+	}
+	select {
+	case got := <-pushed:
+		if got != id {
+			t.Fatalf("pushed %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("update not pushed")
+	}
+
+	// 客户端加载 chunk 时从服务器获取
+	far := world.Vec3{X: 100, Y: 31, Z: -40}
+	w.UpdateBlock(far, world.NewBlock(5))
+	version, err := w.BumpChunkVersion(far.Chunkid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := cw.Chunk(far.Chunkid()).Block(far); b == nil || b.Type != 5 {
+		t.Fatalf("client block %v", b)
+	}
+	if v := cw.ChunkVersion(far.Chunkid()); v != version {
+		t.Fatalf("client chunk version %q, want %q", v, version)
+	}
+	if err := ClientFetchChunk(far.Chunkid()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 连接服务器时本地不改变天气
+	weather := cw.Weather().State()
+	cw.Update(weather.Remaining + 1)
+	if got := cw.Weather().State(); got != weather {
+		t.Fatalf("client weather changed from %v to %v", weather, got)
+	}
+	// 命令也不能修改服务器决定的时间和天气
+	ctx := &world.CommandContext{World: cw, Player: p}
+	for _, line := range []string{"/weather rain", "/time set night"} {
+		if _, err := world.RunCommand(ctx, line); err != world.ErrRemote {
+			t.Fatalf("%s on client: %v", line, err)
+		}
+	}
+	if _, err := world.RunCommand(ctx, "/time query"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务器只返回范围内的其他玩家
+	server.sessions.Store(int32(99), blocks.sess)
+	if err := ClientUpdatePlayerState(world.Position{Vec3: mgl32.Vec3{1, 30, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	bob.SetPos(mgl32.Vec3{500, 30, 0})
+	if err := ClientUpdatePlayerState(world.Position{Vec3: mgl32.Vec3{1, 30, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	server.sessions.Delete(int32(99))
+	if len(seen) != 1 || seen[0].Vec3 != (mgl32.Vec3{0, 30, 0}) {
+		t.Fatalf("players seen %v", seen)
+	}
+
+	// 关闭时保存客户端最后的位置
+	ClientUpdatePlayerState(world.Position{Vec3: mgl32.Vec3{7, 30, -2}})
+	server.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	r, err := w.LoadPlayer("alice")
+	if err != nil || r == nil || r.Pos != (mgl32.Vec3{7, 30, -2}) || !r.Flying {
+		t.Fatalf("saved player %+v %v", r, err)
+	}
+}
+
+func TestUpdateStateAndBlock(t *testing.T) {
+	flag.Set("store", "mem:")
+	err := world.InitStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer world.CloseStore()
+	w := world.NewWorld(2)
+	w.Chunk(world.Vec3{})
+	server := NewServer(w)
+	bob := world.NewPlayer(mgl32.Vec3{0, 30, 0}, nil, nil)
+	sess := &Session{ClientID: 1, player: bob, caps: serverCapabilities}
+	server.sessions.Store(sess.ClientID, sess)
+
+	// net/rpc 在不同的 goroutine 中处理同一个连接的请求
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		players := &PlayerService{server: server, sess: sess}
+		for i := 0; i < 100; i++ {
+			req := &UpdateStateRequest{Id: 1, State: PlayerState{X: float32(i % 3), Y: 30}}
+			players.UpdateState(req, new(UpdateStateResponse))
+		}
+	}()
+	blocks := &BlockService{world: w, server: server, sess: sess}
+	for i := 0; i < 100; i++ {
+		req := &UpdateBlockRequest{X: 1, Y: 31, Z: i % 3, Block: world.NewBlock(4)}
+		if err := blocks.UpdateBlock(req, new(UpdateBlockResponse)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestJoinConcurrent(t *testing.T) {
+	server := &Server{}
+	sessions := make([]*Session, 8)
+	for i := range sessions {
+		sess := newSession(int32(i+1), nil, nil)
+		sess.caps = CapPlayers
+		sess.player = world.NewPlayer(mgl32.Vec3{}, nil, nil)
+		sess.player.Name = fmt.Sprintf("p%d", i+1)
+		sessions[i] = sess
+	}
+	// 同时加入的玩家也互相收到对方的名字
+	var wg sync.WaitGroup
+	for _, sess := range sessions {
+		wg.Add(1)
+		go func(sess *Session) {
+			defer wg.Done()
+			server.join(sess)
+		}(sess)
+	}
+	wg.Wait()
+	for _, sess := range sessions {
+		names := make(map[int32]string)
+		for len(sess.out) > 0 {
+			c := <-sess.out
+			if req, ok := c.req.(*PlayerJoinRequest); ok {
+				names[req.Id] = req.Name
+			}
+		}
+		for _, other := range sessions {
+			if other != sess && names[other.ClientID] != other.player.Name {
+				t.Fatalf("%s got %v", sess.player.Name, names)
+			}
+		}
+	}
+}
+
+func TestAdmitConcurrent(t *testing.T) {
+	server := &Server{MaxPlayers: 3, online: make(map[int32]string)}
+	// 同时握手的同名客户端只接受一个, 总数不超过 MaxPlayers
+	admitted := make([]bool, 16)
+	var wg sync.WaitGroup
+	for i := range admitted {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "alice"
+			if i%2 == 1 {
+				name = fmt.Sprintf("p%d", i)
+			}
+			admitted[i] = server.admit(int32(i+1), &HandshakeResponse{Protocol: ProtocolVersion, Name: name}) == ""
+		}(i)
+	}
+	wg.Wait()
+	alice, total := 0, 0
+	for i, ok := range admitted {
+		if ok {
+			total++
+			if i%2 == 0 {
+				alice++
+			}
+		}
+	}
+	if alice > 1 || total != 3 {
+		t.Fatalf("admitted %d alice, %d total", alice, total)
+	}
+	for i, ok := range admitted {
+		if ok {
+			server.release(int32(i + 1))
+		}
+	}
+	if reason := server.admit(100, &HandshakeResponse{Protocol: ProtocolVersion, Name: "alice"}); reason != "" {
+		t.Fatalf("admit after release: %s", reason)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	// 客户端读取版本后不再读取, 发送阻塞在 writeLoop 中
+	a, b := net.Pipe()
+	go io.ReadFull(b, make([]byte, len(shimLine)+6))
+	master, peer := net.Pipe()
+	sess := newSession(1, master, rpc.NewClientWithCodec(newClientCodec(a)))
+	defer sess.Close()
+	done := make(chan bool)
+	defer close(done)
+	go sess.writeLoop(done)
+
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < sendQueue+2; i++ {
+			sess.send("Status.SyncTime", &SyncTimeRequest{Ticks: int64(i)}, new(SyncTimeResponse))
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("send blocked on a slow client")
+	}
+	// 跟不上的客户端被断开
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("slow client not disconnected: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package schematic
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+var (
+	schemDir = flag.String("schem-dir", "schematics", "directory of .schem files used by /schem")
+)
+
+func Load(path string) (*world.Clipboard, error) {
+	m, err := world.DefaultBlockMap()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, m)
+}
+
+func Save(path string, cb *world.Clipboard) error {
+	m, err := world.DefaultBlockMap()
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = Write(f, cb, m)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func init() {
+	const usage = "/schem <paste|save> <name> | list"
+	world.RegisterCommand("schem", usage, func(ctx *world.CommandContext, args []string) (string, error) {
+		if len(args) == 1 && args[0] == "list" {
+			return world.ListCommandFiles(*schemDir, ".schem")
+		}
+		if len(args) != 2 {
+			return "", world.UsageError(usage)
+		}
+		path, err := world.CommandFile(*schemDir, args[1], ".schem")
+		if err != nil {
+			return "", err
+		}
+		session := ctx.World.EditSession(ctx.Player)
+		switch args[0] {
+		case "paste":
+			cb, err := Load(path)
+			if err != nil {
+				return "", err
+			}
+			n := session.PasteClipboard(cb, ctx.Player.Foot())
+			return fmt.Sprintf("%d blocks changed", n), nil
+		case "save":
+			r, err := session.Selection()
+			if err != nil {
+				return "", err
+			}
+			cb := ctx.World.CopyRegion(r, ctx.Player.Foot())
+			err = os.MkdirAll(*schemDir, 0755)
+			if err != nil {
+				return "", err
+			}
+			err = Save(path, cb)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d blocks saved to %s", len(cb.Blocks), path), nil
+		}
+		return "", world.UsageError(usage)
+	})
+}
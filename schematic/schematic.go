@@ -0,0 +1,229 @@
+// Package schematic 读写 Sponge Schematic v2 (.schem) 格式
+package schematic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/humboldt-xie/tinycraft/nbt"
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+const (
+	Version = 2
+	// DataVersion 导出时写入的 Minecraft 数据版本 (1.16.5)
+	DataVersion = 2586
+
+	airName = "minecraft:air"
+	// 没有映射的方块导出为 tinycraft:<id>, 导入时可以还原
+	namePrefix = "tinycraft:"
+)
+
+var (
+	ErrBadSchematic = errors.New("schematic: bad format")
+	ErrVersion      = errors.New("schematic: unsupported version")
+)
+
+func blockID(m *world.BlockMap, name string) (int, bool) {
+	if strings.HasPrefix(name, namePrefix) {
+		id, err := strconv.Atoi(strings.TrimPrefix(name, namePrefix))
+		return id, err == nil && world.IsBlockType(id)
+	}
+	id, ok := m.ID(name)
+	if ok && id != world.TypeAir && !world.IsBlockType(id) {
+		return 0, false
+	}
+	return id, ok
+}
+
+func blockName(m *world.BlockMap, id int) string {
+	if name, ok := m.Name(id); ok {
+		return name
+	}
+	return namePrefix + strconv.Itoa(id)
+}
+
+func readVarint(data []byte, pos *int) (int, error) {
+	v, shift := 0, uint(0)
+	for {
+		if *pos >= len(data) || shift > 28 {
+			return 0, ErrBadSchematic
+		}
+		b := data[*pos]
+		*pos++
+		v |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+}
+
+func appendVarint(data []byte, v int) []byte {
+	for v >= 0x80 {
+		data = append(data, byte(v&0x7f|0x80))
+		v >>= 7
+	}
+	return append(data, byte(v))
+}
+
+// Read 读取 schematic 为剪贴板, 坐标相对于 WorldEdit 保存时的参考点
+// 没有映射的方块会被忽略
+func Read(r io.Reader, m *world.BlockMap) (*world.Clipboard, error) {
+	_, root, err := nbt.ReadGzip(r)
+	if err != nil {
+		return nil, err
+	}
+	// 部分工具会把内容放在名为 Schematic 的子 compound 中
+	if c, ok := root.Compound("Schematic"); ok {
+		root = c
+	}
+	if v, _ := root.Int("Version"); v != 1 && v != 2 {
+		return nil, ErrVersion
+	}
+	w, _ := root.Int("Width")
+	h, _ := root.Int("Height")
+	l, _ := root.Int("Length")
+	w, h, l = w&0xffff, h&0xffff, l&0xffff
+	if w*h*l > 1<<24 {
+		return nil, ErrBadSchematic
+	}
+	palette, ok := root.Compound("Palette")
+	if !ok {
+		return nil, ErrBadSchematic
+	}
+	data, ok := root.Bytes("BlockData")
+	if !ok {
+		return nil, ErrBadSchematic
+	}
+
+	types := make(map[int]int, len(palette))
+	unknown := make(map[string]bool)
+	for name := range palette {
+		idx, ok := palette.Int(name)
+		if !ok {
+			return nil, ErrBadSchematic
+		}
+		id, ok := blockID(m, name)
+		if !ok {
+			unknown[name] = true
+			id = world.TypeAir
+		}
+		types[idx] = id
+	}
+	if len(unknown) > 0 {
+		log.Printf("schematic: %d unmapped block names ignored", len(unknown))
+	}
+
+	var offset world.Vec3
+	if meta, ok := root.Compound("Metadata"); ok {
+		offset.X, _ = meta.Int("WEOffsetX")
+		offset.Y, _ = meta.Int("WEOffsetY")
+		offset.Z, _ = meta.Int("WEOffsetZ")
+	}
+
+	cb := &world.Clipboard{Blocks: make(map[world.Vec3]*world.Block)}
+	pos := 0
+	for i := 0; i < w*h*l; i++ {
+		idx, err := readVarint(data, &pos)
+		if err != nil {
+			return nil, err
+		}
+		tp, ok := types[idx]
+		if !ok {
+			return nil, fmt.Errorf("%w: palette index %d", ErrBadSchematic, idx)
+		}
+		if tp == world.TypeAir {
+			continue
+		}
+		x, z, y := i%w, (i/w)%l, i/(w*l)
+		cb.Blocks[world.Vec3{X: x, Y: y, Z: z}.Add(offset)] = world.NewBlock(tp)
+	}
+	return cb, nil
+}
+
+// Write 把剪贴板写为 schematic, 剪贴板的原点保存为 WorldEdit 的参考点
+func Write(wr io.Writer, cb *world.Clipboard, m *world.BlockMap) error {
+	if len(cb.Blocks) == 0 {
+		return errors.New("schematic: empty clipboard")
+	}
+	var ids []world.Vec3
+	for id := range cb.Blocks {
+		ids = append(ids, id)
+	}
+	r := world.NewRegion(ids[0], ids[0])
+	for _, id := range ids {
+		r = world.NewRegion(world.Vec3{X: min(r.Min.X, id.X), Y: min(r.Min.Y, id.Y), Z: min(r.Min.Z, id.Z)},
+			world.Vec3{X: max(r.Max.X, id.X), Y: max(r.Max.Y, id.Y), Z: max(r.Max.Z, id.Z)})
+	}
+	size := r.Size()
+	if size.X > 0xffff || size.Y > 0xffff || size.Z > 0xffff {
+		return errors.New("schematic: region too large")
+	}
+
+	palette := nbt.Compound{airName: int32(0)}
+	indexes := map[int]int{world.TypeAir: 0}
+	// 按 BlockType 排序, 保证输出稳定
+	var tps []int
+	for _, b := range cb.Blocks {
+		if _, ok := indexes[b.Type]; !ok {
+			indexes[b.Type] = -1
+			tps = append(tps, b.Type)
+		}
+	}
+	sort.Ints(tps)
+	for _, tp := range tps {
+		indexes[tp] = len(palette)
+		palette[blockName(m, tp)] = int32(indexes[tp])
+	}
+
+	data := make([]byte, 0, r.Volume())
+	for y := 0; y < size.Y; y++ {
+		for z := 0; z < size.Z; z++ {
+			for x := 0; x < size.X; x++ {
+				idx := 0
+				if b, ok := cb.Blocks[r.Min.Add(world.Vec3{X: x, Y: y, Z: z})]; ok {
+					idx = indexes[b.Type]
+				}
+				data = appendVarint(data, idx)
+			}
+		}
+	}
+
+	root := nbt.Compound{
+		"Version":     int32(Version),
+		"DataVersion": int32(DataVersion),
+		"Width":       int16(size.X),
+		"Height":      int16(size.Y),
+		"Length":      int16(size.Z),
+		"Offset":      []int32{int32(r.Min.X), int32(r.Min.Y), int32(r.Min.Z)},
+		"PaletteMax":  int32(len(palette)),
+		"Palette":     palette,
+		"BlockData":   data,
+		"Metadata": nbt.Compound{
+			"WEOffsetX": int32(r.Min.X),
+			"WEOffsetY": int32(r.Min.Y),
+			"WEOffsetZ": int32(r.Min.Z),
+		},
+	}
+	return nbt.WriteGzip(wr, "Schematic", root)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
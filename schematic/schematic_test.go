@@ -0,0 +1,50 @@
+package schematic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, tp := range []int{1, 3, 99} {
+		world.RegisterBlockType(tp, &world.BlockType{Type: tp, Model: world.DTBlock})
+	}
+	m := world.NewBlockMap([]world.BlockName{
+		{Name: "minecraft:air", Id: 0},
+		{Name: "minecraft:grass_block", Id: 1},
+		{Name: "minecraft:stone", Id: 3},
+	})
+	cb := &world.Clipboard{Blocks: map[world.Vec3]*world.Block{
+		{X: -2, Y: 0, Z: 1}:  world.NewBlock(1),
+		{X: 3, Y: 5, Z: -4}:  world.NewBlock(3),
+		{X: 0, Y: 130, Z: 0}: world.NewBlock(99),
+	}}
+	var buf bytes.Buffer
+	err := Write(&buf, cb, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Blocks) != len(cb.Blocks) {
+		t.Fatalf("got %d blocks, want %d", len(got.Blocks), len(cb.Blocks))
+	}
+	for id, b := range cb.Blocks {
+		if got.Blocks[id] == nil || got.Blocks[id].Type != b.Type {
+			t.Errorf("block %v: got %v, want %d", id, got.Blocks[id], b.Type)
+		}
+	}
+}
+
+func TestBlockMapStates(t *testing.T) {
+	m := world.NewBlockMap([]world.BlockName{{Name: "minecraft:oak_log", Id: 5}})
+	for _, name := range []string{"minecraft:oak_log", "minecraft:oak_log[axis=y]", "oak_log"} {
+		if id, ok := m.ID(name); !ok || id != 5 {
+			t.Errorf("%s: got %d %v", name, id, ok)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package vox
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/humboldt-xie/tinycraft/world"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	voxDir         = flag.String("vox-dir", "vox", "directory of .vox files used by /vox and structures")
+	blockColorPath = flag.String("blockcolors", "mods/blockcolors.yaml", "representative colour of block types used by .vox import")
+	structurePath  = flag.String("structures", "mods/structures.yaml", "structure templates placed by terrain generation")
+)
+
+type blockColor struct {
+	Id    int    `yaml:"id"`
+	Color string `yaml:"color"`
+}
+
+type colorConfig struct {
+	Colors []blockColor `yaml:"colors"`
+}
+
+// ColorMap 把颜色映射到最接近的方块类型
+type ColorMap struct {
+	types  []int
+	colors []color.RGBA
+}
+
+func parseColor(s string) (color.RGBA, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(s, "#")) != 6 {
+		return color.RGBA{}, fmt.Errorf("bad colour %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+func LoadColorMap(path string) (*ColorMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config colorConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	m := &ColorMap{}
+	for _, c := range config.Colors {
+		rgba, err := parseColor(c.Color)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %s", c.Id, err)
+		}
+		if !world.IsBlockType(c.Id) {
+			log.Printf("blockcolors: unknown block type %d", c.Id)
+			continue
+		}
+		m.types = append(m.types, c.Id)
+		m.colors = append(m.colors, rgba)
+	}
+	if len(m.types) == 0 {
+		return nil, fmt.Errorf("%s: no block colours", path)
+	}
+	return m, nil
+}
+
+// Nearest 返回颜色最接近的方块类型, 使用 redmean 加权距离
+func (m *ColorMap) Nearest(c color.RGBA) int {
+	best, bestd := m.types[0], -1
+	for i, bc := range m.colors {
+		rm := (int(c.R) + int(bc.R)) / 2
+		dr, dg, db := int(c.R)-int(bc.R), int(c.G)-int(bc.G), int(c.B)-int(bc.B)
+		d := (512+rm)*dr*dr>>8 + 4*dg*dg + (767-rm)*db*db>>8
+		if bestd < 0 || d < bestd {
+			best, bestd = m.types[i], d
+		}
+	}
+	return best
+}
+
+// ToClipboard 转换为剪贴板, z 轴向上转为 y 轴向上, 原点在模型底面中心
+// 透明的颜色被忽略
+func ToClipboard(f *File, m *ColorMap) *world.Clipboard {
+	cb := &world.Clipboard{Blocks: make(map[world.Vec3]*world.Block)}
+	types := make(map[uint8]int)
+	for _, model := range f.Models {
+		for _, v := range model.Voxels {
+			c := f.Palette[v.Index]
+			if c.A == 0 {
+				continue
+			}
+			tp, ok := types[v.Index]
+			if !ok {
+				tp = m.Nearest(c)
+				types[v.Index] = tp
+			}
+			id := world.Vec3{
+				X: int(v.X) - model.SizeX/2,
+				Y: int(v.Z),
+				Z: model.SizeY - 1 - int(v.Y) - model.SizeY/2,
+			}
+			cb.Blocks[id] = world.NewBlock(tp)
+		}
+	}
+	return cb
+}
+
+func Load(path string) (*world.Clipboard, error) {
+	m, err := LoadColorMap(*blockColorPath)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := Read(file)
+	if err != nil {
+		return nil, err
+	}
+	return ToClipboard(f, m), nil
+}
+
+type structureConfig struct {
+	Structures []struct {
+		Name   string  `yaml:"name"`
+		File   string  `yaml:"file"`
+		Chance float64 `yaml:"chance"`
+	} `yaml:"structures"`
+}
+
+// LoadStructures 读取 -structures 配置, 把其中的 .vox 注册为建筑模板, 需要在生成地形之前调用
+func LoadStructures() error {
+	data, err := ioutil.ReadFile(*structurePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var config structureConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return err
+	}
+	for _, s := range config.Structures {
+		path, err := world.CommandFile(*voxDir, s.File, ".vox")
+		if err != nil {
+			return err
+		}
+		cb, err := Load(path)
+		if err != nil {
+			return fmt.Errorf("structure %s: %s", s.Name, err)
+		}
+		err = world.RegisterStructure(&world.Structure{Name: s.Name, Blocks: cb.Blocks, Chance: s.Chance})
+		if err != nil {
+			return fmt.Errorf("structure %s: %s", s.Name, err)
+		}
+		log.Printf("structure %s: %d blocks, chance %g", s.Name, len(cb.Blocks), s.Chance)
+	}
+	return nil
+}
+
+func init() {
+	const usage = "/vox paste <name> | list"
+	world.RegisterCommand("vox", usage, func(ctx *world.CommandContext, args []string) (string, error) {
+		if len(args) == 1 && args[0] == "list" {
+			return world.ListCommandFiles(*voxDir, ".vox")
+		}
+		if len(args) < 2 {
+			return "", world.UsageError(usage)
+		}
+		path, err := world.CommandFile(*voxDir, args[1], ".vox")
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case args[0] == "paste" && len(args) == 2:
+			cb, err := Load(path)
+			if err != nil {
+				return "", err
+			}
+			n := ctx.World.EditSession(ctx.Player).PasteClipboard(cb, ctx.Player.Foot())
+			return fmt.Sprintf("%d blocks changed", n), nil
+		}
+		return "", world.UsageError(usage)
+	})
+}
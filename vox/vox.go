@@ -0,0 +1,200 @@
+// Package vox 读取 MagicaVoxel 的 .vox 文件
+//
+// 文件格式见 https://github.com/ephtracy/voxel-model/blob/master/MagicaVoxel-file-format-vox.txt
+// 只读取模型 (SIZE, XYZI) 和调色板 (RGBA), 场景图中的变换被忽略, 多个模型叠放在原点
+package vox
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// MagicaVoxel 的模型每个方向最多 256 个体素
+	maxModelSize = 256
+	maxChunkSize = 1 << 26
+)
+
+var (
+	ErrBadFile = errors.New("vox: bad file")
+)
+
+type Voxel struct {
+	X, Y, Z uint8
+	// Index 调色板下标, 1-255
+	Index uint8
+}
+
+// Model 坐标系和 MagicaVoxel 相同, z 轴向上
+type Model struct {
+	SizeX, SizeY, SizeZ int
+	Voxels              []Voxel
+}
+
+type File struct {
+	Version int
+	Models  []*Model
+	// Palette 按调色板下标索引, 0 号不使用
+	Palette [256]color.RGBA
+}
+
+type chunkHeader struct {
+	ID           [4]byte
+	ContentSize  int32
+	ChildrenSize int32
+}
+
+func Read(r io.Reader) (*File, error) {
+	br := bufio.NewReader(r)
+	var header struct {
+		Magic   [4]byte
+		Version int32
+	}
+	err := binary.Read(br, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != "VOX " {
+		return nil, ErrBadFile
+	}
+	var main chunkHeader
+	err = binary.Read(br, binary.LittleEndian, &main)
+	if err != nil {
+		return nil, err
+	}
+	if string(main.ID[:]) != "MAIN" || main.ContentSize < 0 || main.ChildrenSize < 0 {
+		return nil, ErrBadFile
+	}
+	_, err = io.CopyN(ioutil.Discard, br, int64(main.ContentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{Version: int(header.Version), Palette: DefaultPalette}
+	var size *Model
+	children := io.LimitReader(br, int64(main.ChildrenSize))
+	for {
+		var ch chunkHeader
+		err := binary.Read(children, binary.LittleEndian, &ch)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ch.ContentSize < 0 || ch.ContentSize > maxChunkSize || ch.ChildrenSize < 0 {
+			return nil, ErrBadFile
+		}
+		content := make([]byte, ch.ContentSize)
+		_, err = io.ReadFull(children, content)
+		if err != nil {
+			return nil, err
+		}
+		// 除了 MAIN 以外的 chunk 都没有需要读取的子 chunk
+		_, err = io.CopyN(ioutil.Discard, children, int64(ch.ChildrenSize))
+		if err != nil {
+			return nil, err
+		}
+		switch string(ch.ID[:]) {
+		case "SIZE":
+			size, err = readSize(content)
+		case "XYZI":
+			if size == nil {
+				return nil, fmt.Errorf("%w: XYZI without SIZE", ErrBadFile)
+			}
+			err = readVoxels(size, content)
+			f.Models = append(f.Models, size)
+			size = nil
+		case "RGBA":
+			err = readPalette(&f.Palette, content)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(f.Models) == 0 {
+		return nil, fmt.Errorf("%w: no model", ErrBadFile)
+	}
+	return f, nil
+}
+
+func readSize(content []byte) (*Model, error) {
+	if len(content) < 12 {
+		return nil, ErrBadFile
+	}
+	m := &Model{
+		SizeX: int(int32(binary.LittleEndian.Uint32(content[0:]))),
+		SizeY: int(int32(binary.LittleEndian.Uint32(content[4:]))),
+		SizeZ: int(int32(binary.LittleEndian.Uint32(content[8:]))),
+	}
+	for _, s := range []int{m.SizeX, m.SizeY, m.SizeZ} {
+		if s <= 0 || s > maxModelSize {
+			return nil, fmt.Errorf("%w: model size %d", ErrBadFile, s)
+		}
+	}
+	return m, nil
+}
+
+func readVoxels(m *Model, content []byte) error {
+	if len(content) < 4 {
+		return ErrBadFile
+	}
+	n := int(binary.LittleEndian.Uint32(content))
+	if n < 0 || n > (len(content)-4)/4 {
+		return ErrBadFile
+	}
+	m.Voxels = make([]Voxel, 0, n)
+	for i := 0; i < n; i++ {
+		b := content[4+i*4:]
+		v := Voxel{X: b[0], Y: b[1], Z: b[2], Index: b[3]}
+		if int(v.X) >= m.SizeX || int(v.Y) >= m.SizeY || int(v.Z) >= m.SizeZ {
+			return fmt.Errorf("%w: voxel out of model", ErrBadFile)
+		}
+		m.Voxels = append(m.Voxels, v)
+	}
+	return nil
+}
+
+// readPalette RGBA chunk 中第 i 个颜色对应调色板下标 i+1
+func readPalette(p *[256]color.RGBA, content []byte) error {
+	if len(content) < 256*4 {
+		return ErrBadFile
+	}
+	for i := 0; i < 255; i++ {
+		b := content[i*4:]
+		p[i+1] = color.RGBA{b[0], b[1], b[2], b[3]}
+	}
+	return nil
+}
+
+// DefaultPalette 文件中没有 RGBA chunk 时使用的 MagicaVoxel 默认调色板:
+// 6x6x6 的颜色立方体 (去掉黑色), 然后是红, 绿, 蓝和灰色的渐变
+var DefaultPalette = func() [256]color.RGBA {
+	var p [256]color.RGBA
+	levels := []uint8{0xff, 0xcc, 0x99, 0x66, 0x33, 0x00}
+	i := 1
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				if r == 0 && g == 0 && b == 0 {
+					continue
+				}
+				p[i] = color.RGBA{r, g, b, 0xff}
+				i++
+			}
+		}
+	}
+	ramp := []uint8{0xee, 0xdd, 0xbb, 0xaa, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	for _, c := range []color.RGBA{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {1, 1, 1, 0}} {
+		for _, v := range ramp {
+			p[i] = color.RGBA{c.R * v, c.G * v, c.B * v, 0xff}
+			i++
+		}
+	}
+	return p
+}()
@@ -0,0 +1,99 @@
+package vox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+
+	"github.com/humboldt-xie/tinycraft/world"
+)
+
+func chunk(id string, content []byte, children []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, [2]int32{int32(len(content)), int32(len(children))})
+	buf.Write(content)
+	buf.Write(children)
+	return buf.Bytes()
+}
+
+func makeFile(withPalette bool) []byte {
+	size := new(bytes.Buffer)
+	binary.Write(size, binary.LittleEndian, [3]int32{4, 2, 3})
+	voxels := new(bytes.Buffer)
+	binary.Write(voxels, binary.LittleEndian, int32(2))
+	voxels.Write([]byte{0, 0, 0, 1, 3, 1, 2, 2})
+
+	var children []byte
+	children = append(children, chunk("PACK", []byte{1, 0, 0, 0}, nil)...)
+	children = append(children, chunk("SIZE", size.Bytes(), nil)...)
+	children = append(children, chunk("XYZI", voxels.Bytes(), nil)...)
+	if withPalette {
+		palette := make([]byte, 256*4)
+		copy(palette, []byte{0x80, 0x80, 0x80, 0xff, 0x10, 0x10, 0xe0, 0xff})
+		children = append(children, chunk("RGBA", palette, nil)...)
+	}
+	children = append(children, chunk("nTRN", make([]byte, 16), nil)...)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("VOX ")
+	binary.Write(buf, binary.LittleEndian, int32(150))
+	buf.Write(chunk("MAIN", nil, children))
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	f, err := Read(bytes.NewReader(makeFile(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Models) != 1 || len(f.Models[0].Voxels) != 2 {
+		t.Fatalf("got %+v", f.Models)
+	}
+	m := f.Models[0]
+	if m.SizeX != 4 || m.SizeY != 2 || m.SizeZ != 3 {
+		t.Fatalf("size %d %d %d", m.SizeX, m.SizeY, m.SizeZ)
+	}
+	if f.Palette[2] != (color.RGBA{0x10, 0x10, 0xe0, 0xff}) {
+		t.Fatalf("palette %v", f.Palette[2])
+	}
+
+	f, err = Read(bytes.NewReader(makeFile(false)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Palette[1] != (color.RGBA{0xff, 0xff, 0xff, 0xff}) || f.Palette[255] != (color.RGBA{0x11, 0x11, 0x11, 0xff}) {
+		t.Fatalf("default palette %v %v", f.Palette[1], f.Palette[255])
+	}
+
+	for _, bad := range [][]byte{nil, []byte("VOX \x96\x00\x00\x00MAIN"), makeFile(true)[:60]} {
+		if _, err := Read(bytes.NewReader(bad)); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestToClipboard(t *testing.T) {
+	f, err := Read(bytes.NewReader(makeFile(true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &ColorMap{
+		types:  []int{3, 46},
+		colors: []color.RGBA{{0x7f, 0x7f, 0x7f, 0xff}, {0, 0, 0xcd, 0xff}},
+	}
+	cb := ToClipboard(f, m)
+	want := map[world.Vec3]int{
+		{X: -2, Y: 0, Z: 0}: 3,
+		{X: 1, Y: 2, Z: -1}: 46,
+	}
+	if len(cb.Blocks) != len(want) {
+		t.Fatalf("got %d blocks", len(cb.Blocks))
+	}
+	for id, tp := range want {
+		if b := cb.Blocks[id]; b == nil || b.Type != tp {
+			t.Errorf("%v: got %v want %d", id, b, tp)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package world
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var storeFlag = flag.String("store", "", "world store, bolt:<path> or mem:, default bolt:<-db>")
+
+// StoreBackend 一种 Store 的实现, 用 "<name>:<path>" 选择
+type StoreBackend struct {
+	// Open 打开 path 的 store
+	Open func(path string) (Store, error)
+	// OpenSnapshot 只读打开 Store.Backup 写入的文件
+	OpenSnapshot func(path string) (Store, error)
+}
+
+var (
+	backendsMutex sync.Mutex
+	backends      = map[string]*StoreBackend{}
+
+	// storeURL InitStore 打开的 store, 备份使用同一种格式
+	storeURL string
+)
+
+// RegisterStore 注册 Store 的实现
+func RegisterStore(name string, b *StoreBackend) {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+	backends[name] = b
+}
+
+// StoreBackends 返回所有注册的实现的名字
+func StoreBackends() []string {
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseStoreURL(url string) (*StoreBackend, string, error) {
+	name, path := url, ""
+	if i := strings.Index(url, ":"); i >= 0 {
+		name, path = url[:i], url[i+1:]
+	}
+	backendsMutex.Lock()
+	b, ok := backends[name]
+	backendsMutex.Unlock()
+	if !ok {
+		return nil, "", fmt.Errorf("unknown store %q, want one of %s", url, strings.Join(StoreBackends(), ", "))
+	}
+	return b, path, nil
+}
+
+// OpenStore 打开 "bolt:gocraft.db", "mem:" 这样的 store
+func OpenStore(url string) (Store, error) {
+	b, path, err := parseStoreURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return b.Open(path)
+}
+
+// openSnapshot 用 InitStore 打开的 store 的格式打开备份
+func openSnapshot(path string) (Store, error) {
+	b, _, err := parseStoreURL(storeURL)
+	if err != nil {
+		return nil, err
+	}
+	return b.OpenSnapshot(path)
+}
+
+// storeBaseName 备份文件名的前缀, 数据库的文件名或者实现的名字
+func storeBaseName() string {
+	url := storeURL
+	name, path := url, ""
+	if i := strings.Index(url, ":"); i >= 0 {
+		name, path = url[:i], url[i+1:]
+	}
+	if path == "" {
+		return name
+	}
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
@@ -0,0 +1,340 @@
+package world
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	backupDir      = flag.String("backup-dir", "backups", "directory of world backups")
+	backupInterval = flag.Duration("backup-interval", time.Hour, "time between automatic backups, 0 disables them")
+	backupKeep     = flag.Int("backup-keep", 24, "number of backups kept, older ones are deleted")
+
+	ErrNoBackup = errors.New("no such backup")
+)
+
+const backupTimeFormat = "20060102-150405"
+
+// Backup 把数据库复制到 -backup-dir, 返回备份的名字
+func Backup() (string, error) {
+	if store == nil {
+		return "", errors.New("store not initialized")
+	}
+	err := os.MkdirAll(*backupDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	name := storeBaseName() + "-" + time.Now().Format(backupTimeFormat) + ".db"
+	path := filepath.Join(*backupDir, name)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	n, err := store.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return "", err
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("backup %s: %d bytes", name, n)
+	return name, nil
+}
+
+// Backups 返回所有备份的名字, 从旧到新
+func Backups() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(*backupDir, "*-*-*.db"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	// 按名字最后的时间排序
+	stamp := func(name string) string {
+		name = strings.TrimSuffix(name, ".db")
+		if len(name) < len(backupTimeFormat) {
+			return name
+		}
+		return name[len(name)-len(backupTimeFormat):]
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return stamp(names[i]) < stamp(names[j])
+	})
+	return names, nil
+}
+
+// rotateBackups 删除最新的 keep 个以外的备份
+func rotateBackups(keep int) error {
+	names, err := Backups()
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		err := os.Remove(filepath.Join(*backupDir, names[0]))
+		if err != nil {
+			return err
+		}
+		log.Printf("backup %s removed", names[0])
+		names = names[1:]
+	}
+	return nil
+}
+
+// StartBackups 按 -backup-interval 定期备份, 在 InitStore 之后调用
+func StartBackups() {
+	if *backupInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(*backupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			_, err := Backup()
+			if err != nil {
+				log.Printf("backup error:%s", err)
+				continue
+			}
+			err = rotateBackups(*backupKeep)
+			if err != nil {
+				log.Printf("rotate backups error:%s", err)
+			}
+		}
+	}()
+}
+
+// OpenBackup 只读打开备份, name 为 Backups 返回的名字, "latest" 表示最新的备份
+func OpenBackup(name string) (Store, error) {
+	if name == "latest" {
+		names, err := Backups()
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, ErrNoBackup
+		}
+		name = names[len(names)-1]
+	}
+	path := filepath.Join(*backupDir, filepath.Base(name))
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoBackup, name)
+	}
+	return openSnapshot(path)
+}
+
+// RestoreRegion 把 r 中的方块恢复为备份中的状态, 返回修改的方块数量
+func (w *World) RestoreRegion(snap Store, r Region) (int, error) {
+	minC, maxC := r.Min.Chunkid(), r.Max.Chunkid()
+	var cids []Vec3
+	for x := minC.X; x <= maxC.X; x++ {
+		for z := minC.Z; z <= maxC.Z; z++ {
+			cids = append(cids, Vec3{x, 0, z})
+		}
+	}
+	return w.restore(snap, cids, r)
+}
+
+// RestoreAll 把整个维度恢复为备份中的状态
+func (w *World) RestoreAll(snap Store) (int, error) {
+	ss, err := snap.Dimension(w.dim.Name)
+	if err != nil {
+		return 0, err
+	}
+	seen := make(map[Vec3]bool)
+	var cids []Vec3
+	for _, s := range []Store{w.store, ss} {
+		chunks, err := s.Chunks()
+		if err != nil {
+			return 0, err
+		}
+		for _, cid := range chunks {
+			if !seen[cid] {
+				seen[cid] = true
+				cids = append(cids, cid)
+			}
+		}
+	}
+	all := Region{
+		Min: Vec3{math.MinInt32, math.MinInt32, math.MinInt32},
+		Max: Vec3{math.MaxInt32, math.MaxInt32, math.MaxInt32},
+	}
+	return w.restore(snap, cids, all)
+}
+
+func (w *World) restore(snap Store, cids []Vec3, r Region) (int, error) {
+	ss, err := snap.Dimension(w.dim.Name)
+	if err != nil {
+		return 0, err
+	}
+	puts := make(map[Vec3]*Block)
+	var dels []Vec3
+	for _, cid := range cids {
+		cur := make(map[Vec3]*Block)
+		err := w.store.RangeBlocks(cid, func(id Vec3, b *Block) {
+			cur[id] = b
+		})
+		if err != nil {
+			return 0, err
+		}
+		old := make(map[Vec3]*Block)
+		err = ss.RangeBlocks(cid, func(id Vec3, b *Block) {
+			old[id] = b
+		})
+		if err != nil {
+			return 0, err
+		}
+		for id := range cur {
+			if _, ok := old[id]; !ok && r.Contains(id) {
+				dels = append(dels, id)
+			}
+		}
+		for id, b := range old {
+			c, ok := cur[id]
+			if r.Contains(id) && (!ok || c.Type != b.Type || c.Life != b.Life) {
+				puts[id] = b
+			}
+		}
+		err = w.restoreGenerate(ss, cid, r)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(dels) > 0 {
+		err := w.store.DeleteBlocks(dels)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(puts) > 0 {
+		err := w.store.UpdateBlocks(puts)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// Generate 的填充会跨越 chunk 边界
+	reload := make(map[Vec3]bool)
+	for _, cid := range cids {
+		for dx := -1; dx <= 1; dx++ {
+			for dz := -1; dz <= 1; dz++ {
+				reload[Vec3{cid.X + dx, 0, cid.Z + dz}] = true
+			}
+		}
+	}
+	for cid := range reload {
+		err := w.reloadChunk(cid)
+		if err != nil {
+			return len(dels) + len(puts), err
+		}
+	}
+	return len(dels) + len(puts), nil
+}
+
+// restoreGenerate 把 r 中的 Generate 触发位置恢复为备份中的
+func (w *World) restoreGenerate(ss Store, cid Vec3, r Region) error {
+	generateMutex.Lock()
+	defer generateMutex.Unlock()
+	var old []Vec3
+	err := ss.GetState(generateKey(cid), &old)
+	if err != nil {
+		return err
+	}
+	cur := w.loadGenerate(cid)
+	var ids []Vec3
+	for _, id := range cur {
+		if !r.Contains(id) {
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range old {
+		if r.Contains(id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 && len(cur) == 0 {
+		return nil
+	}
+	return w.store.UpdateState(generateKey(cid), ids)
+}
+
+func init() {
+	RegisterCommand("backup", "/backup [list]", func(ctx *CommandContext, args []string) (string, error) {
+		switch {
+		case len(args) == 0:
+			name, err := Backup()
+			if err != nil {
+				return "", err
+			}
+			err = rotateBackups(*backupKeep)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("backup %s written", name), nil
+		case len(args) == 1 && args[0] == "list":
+			names, err := Backups()
+			if err != nil {
+				return "", err
+			}
+			return strings.Join(names, "\n"), nil
+		}
+		return "", UsageError("/backup [list]")
+	})
+
+	const usage = "/restore <backup|latest> [chunk|world]"
+	RegisterCommand("restore", usage, func(ctx *CommandContext, args []string) (string, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return "", UsageError(usage)
+		}
+		snap, err := OpenBackup(args[0])
+		if err != nil {
+			return "", err
+		}
+		defer snap.Close()
+		var n int
+		switch {
+		case len(args) == 1:
+			r, serr := ctx.World.EditSession(ctx.Player).Selection()
+			if serr != nil {
+				return "", serr
+			}
+			n, err = ctx.World.RestoreRegion(snap, r)
+		case args[1] == "chunk":
+			n, err = ctx.World.RestoreRegion(snap, chunkRegion(ctx.Player.Foot().Chunkid()))
+		case args[1] == "world":
+			// 所有维度
+			for _, name := range Dimensions() {
+				w, oerr := OpenWorld(name, ctx.World.radius)
+				if oerr != nil {
+					return "", oerr
+				}
+				m, rerr := w.RestoreAll(snap)
+				n += m
+				if rerr != nil {
+					return "", rerr
+				}
+			}
+		default:
+			return "", UsageError(usage)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d blocks restored", n), nil
+	})
+}
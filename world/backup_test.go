@@ -0,0 +1,66 @@
+package world
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	testBackends(t, testBackupRestore)
+}
+
+func testBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { *backupDir = old }(*backupDir)
+	*backupDir = dir
+
+	w := NewWorld(2)
+	a, b := Vec3{1, 40, 1}, Vec3{40, 40, 40}
+	w.Chunks([]Vec3{a.Chunkid(), b.Chunkid()})
+	w.UpdateBlock(a, NewBlock(4))
+	w.UpdateBlock(b, NewBlock(4))
+	name, err := Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.UpdateBlock(a, NewBlock(5))
+	w.UpdateBlock(b, NewBlock(5))
+	w.UpdateBlock(b.Up(), NewBlock(5))
+
+	snap, err := OpenBackup("latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	// 只恢复 a 附近
+	n, err := w.RestoreRegion(snap, NewRegion(Vec3{0, 0, 0}, Vec3{5, 50, 5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || w.Block(a).Type != 4 || w.Block(b).Type != 5 {
+		t.Fatalf("restore region: %d, a %v b %v", n, w.Block(a), w.Block(b))
+	}
+	n, err = w.RestoreAll(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || w.Block(b).Type != 4 || w.Block(b.Up()).Type != TypeAir {
+		t.Fatalf("restore all: %d, b %v above %v", n, w.Block(b), w.Block(b.Up()))
+	}
+
+	names, err := Backups()
+	if err != nil || len(names) != 1 || names[0] != name {
+		t.Fatalf("backups %v %v", names, err)
+	}
+	if err := rotateBackups(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBackup("latest"); err == nil {
+		t.Error("backup not rotated")
+	}
+}
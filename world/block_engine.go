@@ -1,5 +1,12 @@
 package world
 
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+)
+
 const (
 	_ ModelType = iota
 	DTAir
@@ -47,6 +54,12 @@ func (v Vec3) Front() Vec3 {
 func (v Vec3) Back() Vec3 {
 	return Vec3{v.X, v.Y, v.Z - 1}
 }
+func (v Vec3) Add(o Vec3) Vec3 {
+	return Vec3{v.X + o.X, v.Y + o.Y, v.Z + o.Z}
+}
+func (v Vec3) Sub(o Vec3) Vec3 {
+	return Vec3{v.X - o.X, v.Y - o.Y, v.Z - o.Z}
+}
 
 type BlockEngine struct {
 }
@@ -104,6 +117,29 @@ func RegisterBlockType(id int, ty *BlockType) {
 	idToType[id] = ty
 }
 
+// BlockRegistryHash 注册的方块类型的摘要, 客户端和服务器的方块不同时不一样
+func BlockRegistryHash() string {
+	ids := make([]int, 0, len(idToType))
+	for id := range idToType {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	h := sha256.New()
+	for _, id := range ids {
+		t := idToType[id]
+		var b [4]int64
+		b[0], b[1] = int64(id), int64(t.Model)
+		if t.IsTransparent {
+			b[2] = 1
+		}
+		if t.IsObstacle {
+			b[3] = 1
+		}
+		binary.Write(h, binary.LittleEndian, b)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
 // 是否透明 返回true 则不绘制
 func (b *Block) IsTransparent() bool {
 	if b == nil {
@@ -0,0 +1,91 @@
+package world
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	blockMapPath = flag.String("blockmap", "mods/blockmap.yaml", "block name to block type mapping used by importers")
+)
+
+type BlockName struct {
+	Name string `yaml:"name"`
+	Id   int    `yaml:"id"`
+}
+
+type blockMapConfig struct {
+	Blocks []BlockName `yaml:"blocks"`
+}
+
+// BlockMap 外部方块名(例如 minecraft:stone)和 BlockType 之间的映射
+type BlockMap struct {
+	ids   map[string]int
+	names map[int]string
+}
+
+func NewBlockMap(names []BlockName) *BlockMap {
+	m := &BlockMap{ids: make(map[string]int), names: make(map[int]string)}
+	for _, n := range names {
+		m.ids[n.Name] = n.Id
+		// 同一个 id 的第一个名字用于导出
+		if _, ok := m.names[n.Id]; !ok {
+			m.names[n.Id] = n.Name
+		}
+	}
+	return m
+}
+
+func LoadBlockMap(path string) (*BlockMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config blockMapConfig
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockMap(config.Blocks), nil
+}
+
+var (
+	defaultBlockMap     *BlockMap
+	defaultBlockMapErr  error
+	defaultBlockMapOnce sync.Once
+)
+
+// DefaultBlockMap 读取 -blockmap 指定的映射文件
+func DefaultBlockMap() (*BlockMap, error) {
+	defaultBlockMapOnce.Do(func() {
+		defaultBlockMap, defaultBlockMapErr = LoadBlockMap(*blockMapPath)
+	})
+	return defaultBlockMap, defaultBlockMapErr
+}
+
+// ID 返回方块名对应的 BlockType, 名字中的状态 (例如 [axis=y]) 和缺省的 minecraft: 前缀会被忽略
+func (m *BlockMap) ID(name string) (int, bool) {
+	if id, ok := m.ids[name]; ok {
+		return id, true
+	}
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+		if id, ok := m.ids[name]; ok {
+			return id, true
+		}
+	}
+	if !strings.Contains(name, ":") {
+		id, ok := m.ids["minecraft:"+name]
+		return id, ok
+	}
+	return 0, false
+}
+
+func (m *BlockMap) Name(id int) (string, bool) {
+	name, ok := m.names[id]
+	return name, ok
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"math"
 	"sync"
+	"sync/atomic"
 
 	"github.com/go-gl/mathgl/mgl32"
 )
@@ -35,11 +36,29 @@ type ChunkLine struct {
 }
 
 type Chunk struct {
-	id      Vec3
-	version int64
+	id Vec3
+	// world 用于 Snapshot 读取相邻的 chunk
+	world *World
+	// lock 修改方块时加写锁, 保证 Snapshot 读到的方块, version, minY 和 maxY 一致
+	lock    sync.RWMutex
+	version int64 // 原子操作
 	maxY    int
 	minY    int
 	blocks  sync.Map // map[Vec3]int
+
+	snapMutex sync.Mutex
+	snap      *ChunkSnapshot
+	snapKey   [5]int64
+	// generated 没有修改时的方块, 用于判断修改是否需要保存
+	generated sync.Map // map[Vec3]paletteEntry
+
+	state int32 // ChunkState
+	pins  int32
+	count int64 // blocks 中的方块数量, 用于估计内存
+
+	mutex    sync.Mutex
+	pending  map[Vec3]*Block // 还没有写入 store 的修改
+	flushing int             // 正在写入的批次
 }
 
 func NewChunk(id Vec3) *Chunk {
@@ -57,7 +76,7 @@ func (c *Chunk) Id() Vec3 {
 	return c.id
 }
 func (c *Chunk) V() int64 {
-	return c.version
+	return atomic.LoadInt64(&c.version)
 }
 
 func (c *Chunk) Block(id Vec3) *Block {
@@ -80,23 +99,42 @@ func (c *Chunk) add(id Vec3, w *Block) {
 	if id.Chunkid() != c.id {
 		log.Panicf("id %v chunk %v", id, c.id)
 	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if id.Y > c.maxY {
 		c.maxY = id.Y
 	}
 	if id.Y < c.minY {
 		c.minY = id.Y
 	}
-	c.version += 1
+	atomic.AddInt64(&c.version, 1)
 	w.ID = id
-	c.blocks.Store(id, w)
+	if _, loaded := c.blocks.LoadOrStore(id, w); loaded {
+		c.blocks.Store(id, w)
+	} else {
+		atomic.AddInt64(&c.count, 1)
+	}
 }
 
 func (c *Chunk) del(id Vec3) {
 	if id.Chunkid() != c.id {
 		log.Panicf("id %v chunk %v", id, c.id)
 	}
-	c.version += 1
-	c.blocks.Delete(id)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	atomic.AddInt64(&c.version, 1)
+	if _, loaded := c.blocks.LoadAndDelete(id); loaded {
+		atomic.AddInt64(&c.count, -1)
+	}
+}
+
+// isGenerated b 是否和 id 处生成的方块相同
+func (c *Chunk) isGenerated(id Vec3, b *Block) bool {
+	g, ok := c.generated.Load(id)
+	if !ok {
+		return sameAsGenerated(id, paletteEntry{}, false, b)
+	}
+	return sameAsGenerated(id, g.(paletteEntry), true, b)
 }
 
 func (c *Chunk) RangeBlocks(f func(id Vec3, w *Block)) {
@@ -0,0 +1,199 @@
+package world
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// chunk 的二进制格式, 用于 store 和网络传输:
+//
+//	magic "GCK" | version byte | flags byte | body
+//
+// body (flags&chunkFlagDeflate 时用 deflate 压缩):
+//
+//	uvarint 调色板长度 n, 然后 n 个 (varint Type, varint Life)
+//	varint minY, uvarint 高度 h
+//	byte bits, 然后 16*16*h 个格子的调色板下标 +1 (0 表示没有方块),
+//	按 (y*16+z)*16+x 排列, 每个 uint64 (little endian) 放 64/bits 个下标
+const (
+	chunkVersion     = 1
+	chunkFlagDeflate = 1
+	chunkMaxHeight   = 1 << 12
+	chunkMaxPalette  = 1 << 16
+)
+
+var (
+	chunkMagic = []byte("GCK")
+
+	ErrBadChunkData = errors.New("bad chunk data")
+)
+
+type paletteEntry struct {
+	Type, Life int
+}
+
+// EncodeChunk 编码 cid 中的方块, blocks 中不属于 cid 的方块返回错误
+func EncodeChunk(cid Vec3, blocks map[Vec3]*Block, compress bool) ([]byte, error) {
+	minY, maxY := 0, -1
+	index := make(map[paletteEntry]int)
+	var palette []paletteEntry
+	for id, b := range blocks {
+		if id.Chunkid() != cid {
+			return nil, fmt.Errorf("block %v not in chunk %v", id, cid)
+		}
+		if maxY < minY {
+			minY, maxY = id.Y, id.Y
+		}
+		minY, maxY = minInt(minY, id.Y), maxInt(maxY, id.Y)
+		e := paletteEntry{b.Type, b.Life}
+		if _, ok := index[e]; !ok {
+			index[e] = -1
+			palette = append(palette, e)
+		}
+	}
+	// 调色板排序后编码结果是确定的
+	sort.Slice(palette, func(i, j int) bool {
+		if palette[i].Type != palette[j].Type {
+			return palette[i].Type < palette[j].Type
+		}
+		return palette[i].Life < palette[j].Life
+	})
+	for i, e := range palette {
+		index[e] = i
+	}
+	height := maxY - minY + 1
+	if height > chunkMaxHeight {
+		return nil, fmt.Errorf("chunk %v too high: %d", cid, height)
+	}
+
+	body := new(bytes.Buffer)
+	var tmp [binary.MaxVarintLen64]byte
+	putUvarint := func(v uint64) { body.Write(tmp[:binary.PutUvarint(tmp[:], v)]) }
+	putVarint := func(v int64) { body.Write(tmp[:binary.PutVarint(tmp[:], v)]) }
+	putUvarint(uint64(len(palette)))
+	for _, e := range palette {
+		putVarint(int64(e.Type))
+		putVarint(int64(e.Life))
+	}
+	putVarint(int64(minY))
+	putUvarint(uint64(height))
+	bits := 1
+	for 1<<uint(bits) < len(palette)+1 {
+		bits++
+	}
+	body.WriteByte(byte(bits))
+	cells := make([]uint64, packedLen(ChunkWidth*ChunkWidth*height, bits))
+	perLong := 64 / bits
+	x0, z0 := cid.X*ChunkWidth, cid.Z*ChunkWidth
+	for id, b := range blocks {
+		i := ((id.Y-minY)*ChunkWidth+(id.Z-z0))*ChunkWidth + (id.X - x0)
+		v := uint64(index[paletteEntry{b.Type, b.Life}] + 1)
+		cells[i/perLong] |= v << uint(i%perLong*bits)
+	}
+	binary.Write(body, binary.LittleEndian, cells)
+
+	out := new(bytes.Buffer)
+	out.Write(chunkMagic)
+	out.WriteByte(chunkVersion)
+	if !compress {
+		out.WriteByte(0)
+		out.Write(body.Bytes())
+		return out.Bytes(), nil
+	}
+	out.WriteByte(chunkFlagDeflate)
+	zw, _ := flate.NewWriter(out, flate.BestSpeed)
+	zw.Write(body.Bytes())
+	err := zw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func packedLen(n, bits int) int {
+	perLong := 64 / bits
+	return (n + perLong - 1) / perLong
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// DecodeChunk 解码 EncodeChunk 的结果
+func DecodeChunk(cid Vec3, data []byte) (map[Vec3]*Block, error) {
+	if len(data) < len(chunkMagic)+2 || !bytes.Equal(data[:len(chunkMagic)], chunkMagic) {
+		return nil, ErrBadChunkData
+	}
+	version, flags := data[len(chunkMagic)], data[len(chunkMagic)+1]
+	if version != chunkVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrBadChunkData, version)
+	}
+	var r byteReader = bytes.NewReader(data[len(chunkMagic)+2:])
+	if flags&chunkFlagDeflate != 0 {
+		body, err := ioutil.ReadAll(flate.NewReader(r))
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(body)
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > chunkMaxPalette {
+		return nil, ErrBadChunkData
+	}
+	palette := make([]paletteEntry, n)
+	for i := range palette {
+		tp, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrBadChunkData
+		}
+		life, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, ErrBadChunkData
+		}
+		palette[i] = paletteEntry{int(tp), int(life)}
+	}
+	minY, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, ErrBadChunkData
+	}
+	height, err := binary.ReadUvarint(r)
+	if err != nil || height > chunkMaxHeight {
+		return nil, ErrBadChunkData
+	}
+	bits, err := r.ReadByte()
+	if err != nil || bits == 0 || bits > 32 {
+		return nil, ErrBadChunkData
+	}
+	total := ChunkWidth * ChunkWidth * int(height)
+	cells := make([]uint64, packedLen(total, int(bits)))
+	err = binary.Read(r, binary.LittleEndian, cells)
+	if err != nil {
+		return nil, ErrBadChunkData
+	}
+
+	blocks := make(map[Vec3]*Block)
+	perLong := 64 / int(bits)
+	mask := uint64(1)<<bits - 1
+	x0, z0 := cid.X*ChunkWidth, cid.Z*ChunkWidth
+	for i := 0; i < total; i++ {
+		v := cells[i/perLong] >> uint(i%perLong*int(bits)) & mask
+		if v == 0 {
+			continue
+		}
+		if v > uint64(len(palette)) {
+			return nil, ErrBadChunkData
+		}
+		e := palette[v-1]
+		id := Vec3{x0 + i%ChunkWidth, int(minY) + i/(ChunkWidth*ChunkWidth), z0 + i/ChunkWidth%ChunkWidth}
+		blocks[id] = &Block{ID: id, Type: e.Type, Life: e.Life}
+	}
+	return blocks, nil
+}
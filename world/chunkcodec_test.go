@@ -0,0 +1,117 @@
+package world
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func randomChunk(cid Vec3, n int) map[Vec3]*Block {
+	r := rand.New(rand.NewSource(1))
+	blocks := make(map[Vec3]*Block)
+	for i := 0; i < n; i++ {
+		id := Vec3{cid.X*ChunkWidth + r.Intn(ChunkWidth), r.Intn(80) - 20, cid.Z*ChunkWidth + r.Intn(ChunkWidth)}
+		b := NewBlock(r.Intn(66))
+		if i%7 == 0 {
+			b.Life = r.Intn(100)
+		}
+		blocks[id] = b
+	}
+	return blocks
+}
+
+func TestChunkCodec(t *testing.T) {
+	for _, cid := range []Vec3{{0, 0, 0}, {-3, 0, 7}} {
+		for _, n := range []int{0, 1, 500} {
+			for _, compress := range []bool{false, true} {
+				blocks := randomChunk(cid, n)
+				data, err := EncodeChunk(cid, blocks, compress)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := DecodeChunk(cid, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != len(blocks) {
+					t.Fatalf("%v %d: got %d blocks", cid, n, len(got))
+				}
+				for id, b := range blocks {
+					g := got[id]
+					if g == nil || g.Type != b.Type || g.Life != b.Life || g.ID != id {
+						t.Fatalf("%v: got %+v want %+v", id, g, b)
+					}
+				}
+			}
+		}
+	}
+
+	_, err := EncodeChunk(Vec3{0, 0, 0}, map[Vec3]*Block{{16, 0, 0}: NewBlock(1)}, false)
+	if err == nil {
+		t.Error("no error for block outside chunk")
+	}
+	data, _ := EncodeChunk(Vec3{0, 0, 0}, randomChunk(Vec3{0, 0, 0}, 100), false)
+	for _, bad := range [][]byte{nil, []byte("GCK"), data[:len(data)/2], append([]byte("GCX"), data[3:]...)} {
+		if _, err := DecodeChunk(Vec3{0, 0, 0}, bad); err == nil {
+			t.Errorf("no error for %d bytes", len(bad))
+		}
+	}
+}
+
+func TestMigrateBlockKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "old.db")
+
+	// 旧格式: 每个方块一个 key, json 值
+	blocks := randomChunk(Vec3{-1, 0, 2}, 50)
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket(blockBucket)
+		if err != nil {
+			return err
+		}
+		for id, b := range blocks {
+			err := bkt.Put(encodeBlockDbKey(id.Chunkid(), id), encodeBlockDbValue(b))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got := make(map[Vec3]*Block)
+	err = s.RangeBlocks(Vec3{-1, 0, 2}, func(id Vec3, b *Block) {
+		got[id] = b
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(blocks) {
+		t.Fatalf("got %d blocks, want %d", len(got), len(blocks))
+	}
+	for id, b := range blocks {
+		if got[id] == nil || got[id].Type != b.Type {
+			t.Fatalf("%v: got %+v want %+v", id, got[id], b)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package world
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 一天的 tick 数, 0 日出 6000 正午 12000 日落 18000 午夜
+	DayTicks = 24000
+
+	TimeSunrise  = 0
+	TimeNoon     = 6000
+	TimeSunset   = 12000
+	TimeMidnight = 18000
+)
+
+var (
+	dayLength = flag.Duration("daylen", 20*time.Minute, "length of a full day")
+)
+
+type ClockState struct {
+	Ticks int64
+}
+
+type Clock struct {
+	mutex sync.Mutex
+	ticks int64
+	frac  float64
+}
+
+func NewClock(ticks int64) *Clock {
+	return &Clock{ticks: ticks}
+}
+
+func (c *Clock) Ticks() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ticks
+}
+
+func (c *Clock) SetTicks(ticks int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ticks = ticks
+	c.frac = 0
+}
+
+func (c *Clock) Advance(dt float64) {
+	if *dayLength <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.frac += dt * DayTicks / dayLength.Seconds()
+	n := math.Floor(c.frac)
+	c.frac -= n
+	c.ticks += int64(n)
+}
+
+// TimeOfDay 返回 [0,1) 之间的一天中的时间, 0 为日出
+func (c *Clock) TimeOfDay() float32 {
+	t := c.Ticks() % DayTicks
+	if t < 0 {
+		t += DayTicks
+	}
+	return float32(t) / DayTicks
+}
+
+// SunAngle 太阳高度角(弧度), 日出为 0, 正午为 π/2
+func (c *Clock) SunAngle() float32 {
+	return c.TimeOfDay() * 2 * math.Pi
+}
+
+// Daylight 返回 [0,1] 的日照强度, 在日出日落附近平滑过渡
+func (c *Clock) Daylight() float32 {
+	s := sin(c.SunAngle())
+	return max(0, min(1, s*4+0.5))
+}
+
+func (c *Clock) String() string {
+	t := c.Ticks()
+	day := t / DayTicks
+	tod := t % DayTicks
+	// tick 0 对应早上 6 点
+	minutes := (tod*24*60/DayTicks + 6*60) % (24 * 60)
+	return fmt.Sprintf("day %d %02d:%02d (%d)", day, minutes/60, minutes%60, t)
+}
+
+func (w *World) Clock() *Clock {
+	return w.clock
+}
+
+func (w *World) loadClock() {
+	var state ClockState
+	if w.store != nil {
+		err := w.store.GetState("time", &state)
+		if err != nil {
+			log.Printf("load world time error:%s", err)
+		}
+	}
+	w.clock = NewClock(state.Ticks)
+}
+
+func (w *World) saveClock() {
+	if w.store == nil {
+		return
+	}
+	err := w.store.UpdateState("time", &ClockState{Ticks: w.clock.Ticks()})
+	if err != nil {
+		log.Printf("save world time error:%s", err)
+	}
+}
+
+func parseTime(s string) (int64, error) {
+	names := map[string]int64{
+		"day":      1000,
+		"sunrise":  TimeSunrise,
+		"noon":     TimeNoon,
+		"sunset":   TimeSunset,
+		"night":    13000,
+		"midnight": TimeMidnight,
+	}
+	if t, ok := names[s]; ok {
+		return t, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func init() {
+	usage := "/time set <day|noon|night|midnight|ticks> | /time add <ticks> | /time query"
+	RegisterCommand("time", usage, func(ctx *CommandContext, args []string) (string, error) {
+		clock := ctx.World.Clock()
+		if len(args) == 1 && args[0] == "query" {
+			return clock.String(), nil
+		}
+		if len(args) != 2 {
+			return "", UsageError(usage)
+		}
+		if ctx.World.Remote() {
+			return "", ErrRemote
+		}
+		t, err := parseTime(args[1])
+		if err != nil {
+			return "", UsageError(usage)
+		}
+		switch args[0] {
+		case "set":
+			// 保持天数, 只修改一天中的时间
+			now := clock.Ticks()
+			clock.SetTicks(now - now%DayTicks + t)
+		case "add":
+			clock.SetTicks(clock.Ticks() + t)
+		default:
+			return "", UsageError(usage)
+		}
+		ctx.World.saveClock()
+		return clock.String(), nil
+	})
+}
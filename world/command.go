@@ -0,0 +1,110 @@
+package world
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrRemote 连接服务器时由服务器决定的状态不能在客户端修改
+	ErrRemote = errors.New("controlled by the server")
+)
+
+type CommandContext struct {
+	World  *World
+	Player *Player
+	// Travel 把玩家移动到另一个维度, 为 nil 时不能切换维度
+	Travel func(w *World)
+}
+
+type CommandFunc func(ctx *CommandContext, args []string) (string, error)
+
+type Command struct {
+	Name  string
+	Usage string
+	Run   CommandFunc
+}
+
+var (
+	commandsMutex sync.Mutex
+	commands      = map[string]*Command{}
+)
+
+func RegisterCommand(name, usage string, f CommandFunc) {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	commands[name] = &Command{Name: name, Usage: usage, Run: f}
+}
+
+func Commands() []*Command {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	var cmds []*Command
+	for _, c := range commands {
+		cmds = append(cmds, c)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+// RunCommand 执行一行命令, 例如 "/time set day"
+func RunCommand(ctx *CommandContext, line string) (string, error) {
+	args := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "/"))
+	if len(args) == 0 {
+		return "", nil
+	}
+	commandsMutex.Lock()
+	cmd, ok := commands[args[0]]
+	commandsMutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+	return cmd.Run(ctx, args[1:])
+}
+
+// UsageError 命令参数错误时返回
+func UsageError(usage string) error {
+	return fmt.Errorf("usage: %s", usage)
+}
+
+// CommandFile 命令读写的文件只允许 dir 下的文件名, 没有扩展名时加上 ext
+func CommandFile(dir, name, ext string) (string, error) {
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", fmt.Errorf("bad file name %q", name)
+	}
+	if filepath.Ext(name) == "" {
+		name += ext
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// ListCommandFiles dir 下扩展名为 ext 的文件, 返回去掉扩展名后用空格分开的名字
+func ListCommandFiles(dir, ext string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(f), ext))
+	}
+	return strings.Join(names, " "), nil
+}
+
+func init() {
+	RegisterCommand("help", "/help", func(ctx *CommandContext, args []string) (string, error) {
+		var lines []string
+		for _, c := range Commands() {
+			lines = append(lines, c.Usage)
+		}
+		return strings.Join(lines, "\n"), nil
+	})
+}
@@ -0,0 +1,279 @@
+package world
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+)
+
+// store 只保存对生成地形的修改, 删除生成的方块保存为空气.
+// Generate 在低处填充的方块不保存, 只记录触发的位置, 加载 chunk 时重放.
+
+var generateMutex sync.Mutex
+
+func generateKey(cid Vec3) string {
+	return fmt.Sprintf("generate/%d,%d", cid.X, cid.Z)
+}
+
+// loadGenerate 返回 chunk 中触发过 Generate 的位置, 按触发顺序
+func (w *World) loadGenerate(cid Vec3) []Vec3 {
+	var ids []Vec3
+	err := w.store.GetState(generateKey(cid), &ids)
+	if err != nil {
+		log.Printf("load generate %v error:%s", cid, err)
+	}
+	return ids
+}
+
+func (w *World) addGenerate(id Vec3) {
+	generateMutex.Lock()
+	defer generateMutex.Unlock()
+	cid := id.Chunkid()
+	ids := w.loadGenerate(cid)
+	for _, o := range ids {
+		if o == id {
+			return
+		}
+	}
+	err := w.store.UpdateState(generateKey(cid), append(ids, id))
+	if err != nil {
+		log.Printf("save generate %v error:%s", id, err)
+	}
+}
+
+// removeGenerate 删除 r 中的触发位置
+func (w *World) removeGenerate(cid Vec3, r Region) error {
+	generateMutex.Lock()
+	defer generateMutex.Unlock()
+	ids := w.loadGenerate(cid)
+	var keep []Vec3
+	for _, id := range ids {
+		if !r.Contains(id) {
+			keep = append(keep, id)
+		}
+	}
+	if len(keep) == len(ids) {
+		return nil
+	}
+	return w.store.UpdateState(generateKey(cid), keep)
+}
+
+// generateFill 计算 Generate(id) 填充的方块, 范围在 id 周围 5 格以内
+func generateFill(id Vec3, f func(Vec3, *Block)) {
+	nw := typeSandBlock
+	if noise2(-float32(id.X)*0.1, float32(id.Y)*0.1, 4, 0.8, 2) > 0.6 {
+		nw = typeGrassBlock
+		width := 10
+		//length := 10
+		height := 5
+		y := id.Y - height
+		minY := id.Y - height
+		maxY := id.Y
+		minX := id.X - width/2
+		maxX := id.X + width/2
+		minZ := id.Z - width/2
+		maxZ := id.Z + width/2
+		for ; y <= maxY; y++ {
+			for x := minX; x <= id.X+width/2; x++ {
+				for z := id.Z - width/2; z <= id.Z+width/2; z++ {
+					if y == minY || y == maxY || x == minX || x == maxX || z == minZ || z == maxZ {
+						nw = typeGrassBlock
+					} else {
+						nw = TypeAir
+					}
+					f(Vec3{x, y, z}, NewBlock(nw))
+				}
+			}
+		}
+	}
+	for x := id.X - 1; x <= id.X+1; x++ {
+		for y := id.Y - 1; y <= id.Y+1; y++ {
+			for z := id.Z - 1; z <= id.Z+1; z++ {
+				f(Vec3{x, y, z}, NewBlock(nw))
+			}
+		}
+	}
+}
+
+// generatedChunk 返回没有修改时 chunk 中的方块: 生成的地形, 加上附近 chunk 中触发的 Generate 填充
+func (w *World) generatedChunk(cid Vec3) map[Vec3]*Block {
+	m := w.dim.Generator.chunk(cid)
+	for dx := -1; dx <= 1; dx++ {
+		for dz := -1; dz <= 1; dz++ {
+			for _, t := range w.loadGenerate(Vec3{cid.X + dx, 0, cid.Z + dz}) {
+				generateFill(t, func(id Vec3, b *Block) {
+					// 和 CreateBlock 一样只填充空的位置, 12 以上的空位置是空气
+					if id.Chunkid() != cid || id.Y >= 12 {
+						return
+					}
+					if _, ok := m[id]; !ok {
+						m[id] = b
+					}
+				})
+			}
+		}
+	}
+	return m
+}
+
+// sameAsGenerated b 是否和生成的方块相同, ok 为 false 表示没有生成方块
+func sameAsGenerated(id Vec3, g paletteEntry, ok bool, b *Block) bool {
+	if b == nil {
+		return false
+	}
+	if !ok {
+		return b.Type == TypeAir && id.Y >= 12
+	}
+	return g == paletteEntry{b.Type, b.Life}
+}
+
+// buildChunk 由生成的地形和 store 中的修改构建 chunk, 连接服务器时先从服务器更新 store
+func (w *World) buildChunk(id Vec3) (*Chunk, error) {
+	if fetch := w.remoteFetch(); fetch != nil {
+		// 失败时使用本地保存的 chunk
+		if err := fetch(id); err != nil {
+			log.Printf("fetch chunk(%v) error:%s", id, err)
+		}
+	}
+	chunk := NewChunk(id)
+	chunk.world = w
+	for bid, b := range w.generatedChunk(id) {
+		chunk.generated.Store(bid, paletteEntry{b.Type, b.Life})
+		chunk.add(bid, b)
+	}
+	err := w.store.RangeBlocks(id, func(bid Vec3, b *Block) {
+		chunk.add(bid, b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// saveChanges 保存修改, 已加载的 chunk 的修改记录在 chunk 中, 写入失败时在 Save 和卸载时重试
+func (w *World) saveChanges(changes map[Vec3]*Block) {
+	if w.store == nil {
+		return
+	}
+	chunks := make(map[Vec3]map[Vec3]*Block)
+	for id, b := range changes {
+		cid := id.Chunkid()
+		if chunks[cid] == nil {
+			chunks[cid] = make(map[Vec3]*Block)
+		}
+		chunks[cid][id] = b
+	}
+	for cid, m := range chunks {
+		chunk, ok := w.loadChunk(cid)
+		if !ok {
+			err := w.writeChanges(nil, m)
+			if err != nil {
+				log.Printf("save blocks error:%s", err)
+			}
+			continue
+		}
+		chunk.markDirty(m)
+		err := w.flushChunk(chunk)
+		if err != nil {
+			log.Printf("save chunk %v error:%s", cid, err)
+		}
+	}
+}
+
+// reloadChunk 重新构建已加载的 chunk
+func (w *World) reloadChunk(cid Vec3) error {
+	old, ok := w.loadChunk(cid)
+	if !ok {
+		return nil
+	}
+	err := w.flushChunk(old)
+	if err != nil {
+		return err
+	}
+	chunk, err := w.buildChunk(cid)
+	if err != nil {
+		return err
+	}
+	// 版本号比 old 大, 渲染会更新
+	w.storeChunk(cid, chunk)
+	w.Watcher.Emit(Event{Type: "Chunk.Update", Data: cid})
+	return nil
+}
+
+// ResetRegion 删除 r 中保存的修改, 恢复为生成的地形, 返回删除的修改数量.
+// r 中的修改触发的 Generate 填充也一起删除, 不能撤销
+func (w *World) ResetRegion(r Region) (int, error) {
+	minC, maxC := r.Min.Chunkid(), r.Max.Chunkid()
+	var ids []Vec3
+	for x := minC.X; x <= maxC.X; x++ {
+		for z := minC.Z; z <= maxC.Z; z++ {
+			cid := Vec3{x, 0, z}
+			err := w.store.RangeBlocks(cid, func(id Vec3, b *Block) {
+				if r.Contains(id) {
+					ids = append(ids, id)
+				}
+			})
+			if err != nil {
+				return 0, err
+			}
+			err = w.removeGenerate(cid, r)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(ids) > 0 {
+		err := w.store.DeleteBlocks(ids)
+		if err != nil {
+			return 0, err
+		}
+	}
+	// Generate 的填充会跨越 chunk 边界
+	for x := minC.X - 1; x <= maxC.X+1; x++ {
+		for z := minC.Z - 1; z <= maxC.Z+1; z++ {
+			err := w.reloadChunk(Vec3{x, 0, z})
+			if err != nil {
+				return len(ids), err
+			}
+		}
+	}
+	return len(ids), nil
+}
+
+// chunkRegion 整个 chunk 所有高度的范围
+func chunkRegion(cid Vec3) Region {
+	return Region{
+		Min: Vec3{cid.X * ChunkWidth, math.MinInt32, cid.Z * ChunkWidth},
+		Max: Vec3{cid.X*ChunkWidth + ChunkWidth - 1, math.MaxInt32, cid.Z*ChunkWidth + ChunkWidth - 1},
+	}
+}
+
+// ResetChunk 把整个 chunk 恢复为生成的地形
+func (w *World) ResetChunk(cid Vec3) (int, error) {
+	return w.ResetRegion(chunkRegion(cid))
+}
+
+func init() {
+	const usage = "/reset [chunk]"
+	RegisterCommand("reset", usage, func(ctx *CommandContext, args []string) (string, error) {
+		var n int
+		var err error
+		switch {
+		case len(args) == 0:
+			r, serr := ctx.World.EditSession(ctx.Player).Selection()
+			if serr != nil {
+				return "", serr
+			}
+			n, err = ctx.World.ResetRegion(r)
+		case len(args) == 1 && args[0] == "chunk":
+			n, err = ctx.World.ResetChunk(ctx.Player.Foot().Chunkid())
+		default:
+			return "", UsageError(usage)
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d changes removed", n), nil
+	})
+}
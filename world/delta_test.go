@@ -0,0 +1,119 @@
+package world
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func storedBlocks(t *testing.T, cid Vec3) map[Vec3]*Block {
+	m := make(map[Vec3]*Block)
+	err := store.RangeBlocks(cid, func(id Vec3, b *Block) {
+		m[id] = b
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// openTestStore 使用内存中的 store, 返回的函数关闭 store
+func openTestStore(t *testing.T) func() {
+	return openTestBackend(t, "mem")
+}
+
+// openTestBackend 在临时目录中打开 name 实现的 store, 返回的函数关闭 store
+func openTestBackend(t *testing.T, name string) func() {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := name + ":" + filepath.Join(dir, "world.db")
+	store, err = OpenStore(url)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	storeURL = url
+	closeWorlds()
+	return func() {
+		store.Close()
+		store = nil
+		storeURL = ""
+		closeWorlds()
+		os.RemoveAll(dir)
+	}
+}
+
+// testBackends 用每一种 store 实现运行 f
+func testBackends(t *testing.T, f func(t *testing.T)) {
+	for _, name := range StoreBackends() {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			defer openTestBackend(t, name)()
+			f(t)
+		})
+	}
+}
+
+func TestDeltaAndReset(t *testing.T) {
+	testBackends(t, testDeltaAndReset)
+}
+
+func testDeltaAndReset(t *testing.T) {
+
+	w := NewWorld(2)
+	cid := Vec3{0, 0, 0}
+	chunk := w.Chunk(cid)
+	h, _ := w.dim.Generator.height(5, 5)
+	top := Vec3{5, h - 1, 5}
+	orig := chunk.Block(top)
+
+	// 放回相同的方块不保存, 删除生成的方块保存为空气
+	w.UpdateBlock(top, NewBlock(orig.Type))
+	if n := len(storedBlocks(t, cid)); n != 0 {
+		t.Fatalf("%d blocks stored for unchanged block", n)
+	}
+	w.UpdateBlock(top, NewBlock(TypeAir))
+	if b := storedBlocks(t, cid)[top]; b == nil || b.Type != TypeAir {
+		t.Fatalf("tombstone %v", b)
+	}
+
+	// Generate 填充的方块不保存, 重新加载时重放
+	low := Vec3{8, 3, 8}
+	w.UpdateBlock(low, NewBlock(4))
+	stored := storedBlocks(t, cid)
+	if len(stored) != 2 {
+		t.Fatalf("%d blocks stored", len(stored))
+	}
+	below := w.Block(low.Down())
+	if below == nil {
+		t.Fatal("no block generated below")
+	}
+	reloaded, err := w.buildChunk(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := reloaded.Block(low.Down()); b == nil || b.Type != below.Type {
+		t.Fatalf("generated %v, reloaded %v", below, b)
+	}
+	if b := reloaded.Block(top); b.Type != TypeAir {
+		t.Fatalf("removed block reloaded as %v", b)
+	}
+
+	n, err := w.ResetChunk(cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(storedBlocks(t, cid)) != 0 {
+		t.Fatalf("reset %d, %d left", n, len(storedBlocks(t, cid)))
+	}
+	chunk = w.Chunk(cid)
+	if b := chunk.Block(top); b.Type != orig.Type {
+		t.Fatalf("reset block %v, want %v", b, orig)
+	}
+	if b := chunk.Block(low.Down()); b != nil {
+		t.Fatalf("generated block %v not reset", b)
+	}
+}
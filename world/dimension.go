@@ -0,0 +1,177 @@
+package world
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DefaultDimension = "overworld"
+
+var (
+	dimFlag = flag.String("dim", DefaultDimension, "dimension opened by NewWorld")
+
+	ErrNoDimension = errors.New("no such dimension")
+)
+
+// Dimension 同一个数据库中的一个世界, 有自己的地形, 方块和出生点
+type Dimension struct {
+	Name      string    `json:"name"`
+	Generator Generator `json:"generator"`
+	// Spawn 新世界出生点的设置, 世界的出生点在附近安全的位置, 见 World.SpawnBlock
+	Spawn Vec3 `json:"spawn"`
+}
+
+func defaultDimension() *Dimension {
+	return &Dimension{
+		Name:      DefaultDimension,
+		Generator: Generator{Type: GeneratorDefault},
+		Spawn:     Vec3{0, 16, 0},
+	}
+}
+
+func dimensionKey(name string) string {
+	return "dimension/" + name
+}
+
+// LoadDimension 读取维度的设置, 默认维度不存在时使用默认设置
+func LoadDimension(name string) (*Dimension, error) {
+	var d *Dimension
+	if store != nil {
+		err := store.GetState(dimensionKey(name), &d)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d == nil {
+		if name != DefaultDimension {
+			return nil, fmt.Errorf("%w: %s", ErrNoDimension, name)
+		}
+		d = defaultDimension()
+	}
+	err := d.Generator.init()
+	if err != nil {
+		return nil, fmt.Errorf("dimension %s: %s", name, err)
+	}
+	return d, nil
+}
+
+// CreateDimension 保存新的维度
+func CreateDimension(d *Dimension) error {
+	if d.Name == "" || strings.ContainsAny(d.Name, "/ ") {
+		return fmt.Errorf("bad dimension name %q", d.Name)
+	}
+	err := d.Generator.init()
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return errors.New("store not initialized")
+	}
+	names := Dimensions()
+	for _, n := range names {
+		if n == d.Name {
+			return fmt.Errorf("dimension %s exists", d.Name)
+		}
+	}
+	err = store.UpdateState(dimensionKey(d.Name), d)
+	if err != nil {
+		return err
+	}
+	return store.UpdateState("dimensions", append(names[1:], d.Name))
+}
+
+// Dimensions 返回所有维度的名字, 第一个是默认维度
+func Dimensions() []string {
+	var names []string
+	if store != nil {
+		store.GetState("dimensions", &names)
+	}
+	sort.Strings(names)
+	return append([]string{DefaultDimension}, names...)
+}
+
+var (
+	worldsMutex sync.Mutex
+	worlds      = map[string]*World{}
+)
+
+// OpenWorld 返回维度的 World, 同一个维度只打开一次
+func OpenWorld(name string, renderRadius int) (*World, error) {
+	worldsMutex.Lock()
+	defer worldsMutex.Unlock()
+	if w, ok := worlds[name]; ok {
+		return w, nil
+	}
+	d, err := LoadDimension(name)
+	if err != nil {
+		return nil, err
+	}
+	var s Store
+	if store != nil {
+		s, err = store.Dimension(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	w := newWorld(d, s, renderRadius)
+	worlds[name] = w
+	return w, nil
+}
+
+// closeWorlds 关闭所有打开的 World, 之后 OpenWorld 重新打开
+func closeWorlds() {
+	worldsMutex.Lock()
+	defer worldsMutex.Unlock()
+	for _, w := range worlds {
+		w.Close()
+	}
+	worlds = map[string]*World{}
+}
+
+func (w *World) Dimension() *Dimension {
+	return w.dim
+}
+
+func init() {
+	const usage = "/dim [<name> | create <name> [default|flat|void] [seed]]"
+	RegisterCommand("dim", usage, func(ctx *CommandContext, args []string) (string, error) {
+		switch {
+		case len(args) == 0:
+			return fmt.Sprintf("%s (in %s)", strings.Join(Dimensions(), " "), ctx.World.dim.Name), nil
+		case len(args) == 1:
+			if ctx.Travel == nil {
+				return "", errors.New("can't change dimension here")
+			}
+			w, err := OpenWorld(args[0], ctx.World.radius)
+			if err != nil {
+				return "", err
+			}
+			ctx.Travel(w)
+			ctx.Player.SetPos(w.RespawnPos(ctx.Player))
+			return fmt.Sprintf("moved to %s", args[0]), nil
+		case args[0] == "create" && len(args) >= 2 && len(args) <= 4:
+			d := &Dimension{Name: args[1], Spawn: Vec3{0, 16, 0}}
+			if len(args) >= 3 {
+				d.Generator.Type = args[2]
+			}
+			if len(args) == 4 {
+				seed, err := strconv.ParseInt(args[3], 10, 64)
+				if err != nil {
+					return "", UsageError(usage)
+				}
+				d.Generator.Seed = seed
+			}
+			err := CreateDimension(d)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("dimension %s created", d.Name), nil
+		}
+		return "", UsageError(usage)
+	})
+}
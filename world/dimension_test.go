@@ -0,0 +1,67 @@
+package world
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDimensions(t *testing.T) {
+	defer openTestStore(t)()
+
+	_, err := OpenWorld("flat", 2)
+	if !errors.Is(err, ErrNoDimension) {
+		t.Fatalf("open missing dimension: %v", err)
+	}
+	err = CreateDimension(&Dimension{Name: "flat", Generator: Generator{Type: GeneratorFlat, Height: 14}, Spawn: Vec3{0, 14, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateDimension(&Dimension{Name: "flat"}); err == nil {
+		t.Error("created dimension twice")
+	}
+	if err := CreateDimension(&Dimension{Name: "x", Generator: Generator{Type: "caves"}}); err == nil {
+		t.Error("created dimension with unknown generator")
+	}
+	if names := Dimensions(); len(names) != 2 || names[0] != DefaultDimension || names[1] != "flat" {
+		t.Fatalf("dimensions %v", names)
+	}
+
+	over := NewWorld(2)
+	flat, err := OpenWorld("flat", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w, _ := OpenWorld("flat", 2); w != flat {
+		t.Error("dimension opened twice")
+	}
+	id := Vec3{3, 13, 3}
+	if b := flat.Chunk(id.Chunkid()).Block(id); b.Type != typeGrassBlock {
+		t.Fatalf("flat block %v", b)
+	}
+	if b := flat.Chunk(id.Chunkid()).Block(id.Up().Up()); b.Type != TypeAir {
+		t.Fatalf("flat block above %v", b)
+	}
+
+	// 两个维度的修改分开保存
+	over.Chunk(id.Chunkid())
+	flat.UpdateBlock(Vec3{3, 20, 3}, NewBlock(4))
+	over.UpdateBlock(Vec3{4, 40, 4}, NewBlock(5))
+	var n int
+	flat.store.RangeBlocks(id.Chunkid(), func(bid Vec3, b *Block) {
+		if bid != (Vec3{3, 20, 3}) || b.Type != 4 {
+			t.Errorf("flat stored %v %v", bid, b)
+		}
+		n++
+	})
+	if n != 1 {
+		t.Fatalf("flat stored %d blocks", n)
+	}
+	closeWorlds()
+	flat, _ = OpenWorld("flat", 2)
+	if flat.Spawn().Y() != 14 || flat.Dimension().Generator.Height != 14 {
+		t.Errorf("reloaded dimension %+v", flat.Dimension())
+	}
+	if b := flat.Chunk(id.Chunkid()).Block(Vec3{4, 40, 4}); b.Type != TypeAir {
+		t.Errorf("overworld block in flat: %v", b)
+	}
+}
@@ -0,0 +1,508 @@
+package world
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const (
+	maxEditHistory = 32
+	maxEditVolume  = 1 << 20
+)
+
+var (
+	ErrNoSelection = errors.New("no selection, use /pos1 and /pos2")
+	ErrNoClipboard = errors.New("clipboard is empty, use /copy")
+	ErrNoHistory   = errors.New("nothing to undo")
+	ErrTooLarge    = errors.New("region too large")
+)
+
+// Region 两个角围成的长方体, 包含边界
+type Region struct {
+	Min, Max Vec3
+}
+
+func NewRegion(a, b Vec3) Region {
+	return Region{
+		Min: Vec3{minInt(a.X, b.X), minInt(a.Y, b.Y), minInt(a.Z, b.Z)},
+		Max: Vec3{maxInt(a.X, b.X), maxInt(a.Y, b.Y), maxInt(a.Z, b.Z)},
+	}
+}
+
+func (r Region) Size() Vec3 {
+	return Vec3{r.Max.X - r.Min.X + 1, r.Max.Y - r.Min.Y + 1, r.Max.Z - r.Min.Z + 1}
+}
+
+func (r Region) Volume() int {
+	s := r.Size()
+	return s.X * s.Y * s.Z
+}
+
+func (r Region) Contains(id Vec3) bool {
+	return id.X >= r.Min.X && id.X <= r.Max.X &&
+		id.Y >= r.Min.Y && id.Y <= r.Max.Y &&
+		id.Z >= r.Min.Z && id.Z <= r.Max.Z
+}
+
+func (r Region) Range(f func(id Vec3)) {
+	for x := r.Min.X; x <= r.Max.X; x++ {
+		for y := r.Min.Y; y <= r.Max.Y; y++ {
+			for z := r.Min.Z; z <= r.Max.Z; z++ {
+				f(Vec3{x, y, z})
+			}
+		}
+	}
+}
+
+func (r Region) Add(offset Vec3) Region {
+	return Region{Min: r.Min.Add(offset), Max: r.Max.Add(offset)}
+}
+
+func (r Region) String() string {
+	s := r.Size()
+	return fmt.Sprintf("%v-%v (%dx%dx%d)", r.Min, r.Max, s.X, s.Y, s.Z)
+}
+
+// Clipboard 复制的方块, 坐标相对于复制时玩家的位置
+type Clipboard struct {
+	Blocks map[Vec3]*Block
+}
+
+// Rotate 绕 y 轴顺时针旋转 90 度的整数倍
+func (c *Clipboard) Rotate(degrees int) *Clipboard {
+	n := ((degrees/90)%4 + 4) % 4
+	nc := &Clipboard{Blocks: make(map[Vec3]*Block, len(c.Blocks))}
+	for id, b := range c.Blocks {
+		for i := 0; i < n; i++ {
+			id = Vec3{-id.Z, id.Y, id.X}
+		}
+		nc.Blocks[id] = b
+	}
+	return nc
+}
+
+// ChangeSet 一次编辑修改的方块, 以及修改之前的值
+type ChangeSet struct {
+	Before map[Vec3]*Block
+	After  map[Vec3]*Block
+}
+
+func (c *ChangeSet) Len() int {
+	return len(c.After)
+}
+
+func copyBlock(b *Block) *Block {
+	if b == nil {
+		return NewBlock(TypeAir)
+	}
+	nb := *b
+	return &nb
+}
+
+// ApplyChanges 批量修改方块: 一次写入 store, 每个受影响的 chunk 只发一次更新事件
+func (w *World) ApplyChanges(changes map[Vec3]*Block) *ChangeSet {
+	cs := &ChangeSet{
+		Before: make(map[Vec3]*Block, len(changes)),
+		After:  make(map[Vec3]*Block, len(changes)),
+	}
+	dirty := make(map[Vec3]bool)
+	for id, b := range changes {
+		cs.Before[id] = copyBlock(w.Block(id))
+		b = copyBlock(b)
+		cs.After[id] = b
+		chunk := w.BlockChunk(id)
+		if chunk != nil {
+			chunk.add(id, b)
+			chunk.markDirty(map[Vec3]*Block{id: b})
+		}
+		for _, n := range []Vec3{id, id.Left(), id.Right(), id.Front(), id.Back()} {
+			dirty[n.Chunkid()] = true
+		}
+	}
+	w.saveChanges(cs.After)
+	for cid := range dirty {
+		w.Watcher.Emit(Event{Type: "Chunk.Update", Data: cid})
+	}
+	return cs
+}
+
+// EditSession 每个玩家的选区, 剪贴板和撤销历史
+type EditSession struct {
+	mutex     sync.Mutex
+	world     *World
+	pos1      *Vec3
+	pos2      *Vec3
+	clipboard *Clipboard
+	history   []*ChangeSet
+	undone    []*ChangeSet
+}
+
+func (w *World) EditSession(p *Player) *EditSession {
+	s, _ := w.editSessions.LoadOrStore(p, &EditSession{world: w})
+	return s.(*EditSession)
+}
+
+func (s *EditSession) SetPos1(id Vec3) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pos1 = &id
+}
+
+func (s *EditSession) SetPos2(id Vec3) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pos2 = &id
+}
+
+func (s *EditSession) Selection() (Region, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.selection()
+}
+
+func (s *EditSession) selection() (Region, error) {
+	if s.pos1 == nil || s.pos2 == nil {
+		return Region{}, ErrNoSelection
+	}
+	r := NewRegion(*s.pos1, *s.pos2)
+	if r.Volume() > maxEditVolume {
+		return r, ErrTooLarge
+	}
+	return r, nil
+}
+
+func (s *EditSession) SetSelection(r Region) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pos1, s.pos2 = &r.Min, &r.Max
+}
+
+func (s *EditSession) apply(changes map[Vec3]*Block) *ChangeSet {
+	cs := s.world.ApplyChanges(changes)
+	s.history = append(s.history, cs)
+	if len(s.history) > maxEditHistory {
+		s.history = s.history[len(s.history)-maxEditHistory:]
+	}
+	s.undone = nil
+	return cs
+}
+
+// edit 对选区中的每个方块调用 f, f 返回 nil 表示不修改
+func (s *EditSession) edit(f func(r Region, id Vec3, b *Block) *Block) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, err := s.selection()
+	if err != nil {
+		return 0, err
+	}
+	changes := make(map[Vec3]*Block)
+	r.Range(func(id Vec3) {
+		nb := f(r, id, s.world.Block(id))
+		if nb != nil {
+			changes[id] = nb
+		}
+	})
+	return s.apply(changes).Len(), nil
+}
+
+func blockTypeOf(b *Block) int {
+	if b == nil {
+		return TypeAir
+	}
+	return b.Type
+}
+
+func (s *EditSession) Fill(tp int) (int, error) {
+	return s.edit(func(r Region, id Vec3, b *Block) *Block {
+		return NewBlock(tp)
+	})
+}
+
+func (s *EditSession) Replace(from, to int) (int, error) {
+	return s.edit(func(r Region, id Vec3, b *Block) *Block {
+		if blockTypeOf(b) != from {
+			return nil
+		}
+		return NewBlock(to)
+	})
+}
+
+func (s *EditSession) Walls(tp int) (int, error) {
+	return s.edit(func(r Region, id Vec3, b *Block) *Block {
+		if id.X == r.Min.X || id.X == r.Max.X || id.Z == r.Min.Z || id.Z == r.Max.Z {
+			return NewBlock(tp)
+		}
+		return nil
+	})
+}
+
+// Hollow 挖空选区内的物体, 只保留外壳
+func (s *EditSession) Hollow() (int, error) {
+	solid := func(id Vec3) bool {
+		return s.world.Block(id).IsObstacle()
+	}
+	return s.edit(func(r Region, id Vec3, b *Block) *Block {
+		if !b.IsObstacle() {
+			return nil
+		}
+		for _, n := range []Vec3{id.Left(), id.Right(), id.Up(), id.Down(), id.Front(), id.Back()} {
+			if !solid(n) {
+				return nil
+			}
+		}
+		return NewBlock(TypeAir)
+	})
+}
+
+// Copy 复制选区到剪贴板, origin 为粘贴时的参考点
+func (s *EditSession) Copy(origin Vec3) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, err := s.selection()
+	if err != nil {
+		return 0, err
+	}
+	s.clipboard = s.world.CopyRegion(r, origin)
+	return len(s.clipboard.Blocks), nil
+}
+
+// CopyRegion 复制区域内的方块, 坐标相对于 origin
+func (w *World) CopyRegion(r Region, origin Vec3) *Clipboard {
+	cb := &Clipboard{Blocks: make(map[Vec3]*Block)}
+	r.Range(func(id Vec3) {
+		b := w.Block(id)
+		if b != nil {
+			cb.Blocks[id.Sub(origin)] = copyBlock(b)
+		}
+	})
+	return cb
+}
+
+func (s *EditSession) Clipboard() *Clipboard {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.clipboard
+}
+
+func (s *EditSession) SetClipboard(cb *Clipboard) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.clipboard = cb
+}
+
+func (s *EditSession) RotateClipboard(degrees int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.clipboard == nil {
+		return ErrNoClipboard
+	}
+	s.clipboard = s.clipboard.Rotate(degrees)
+	return nil
+}
+
+// Paste 以 origin 为参考点粘贴剪贴板
+func (s *EditSession) Paste(origin Vec3) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.clipboard == nil {
+		return 0, ErrNoClipboard
+	}
+	return s.paste(s.clipboard, origin), nil
+}
+
+// PasteClipboard 粘贴指定的剪贴板, 不改变玩家自己的剪贴板, 可以撤销
+func (s *EditSession) PasteClipboard(cb *Clipboard, origin Vec3) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.paste(cb, origin)
+}
+
+func (s *EditSession) paste(cb *Clipboard, origin Vec3) int {
+	changes := make(map[Vec3]*Block, len(cb.Blocks))
+	for id, b := range cb.Blocks {
+		changes[id.Add(origin)] = b
+	}
+	return s.apply(changes).Len()
+}
+
+// Move 把选区内的方块移动 offset, 原位置变为空气, 选区跟着移动
+func (s *EditSession) Move(offset Vec3) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, err := s.selection()
+	if err != nil {
+		return 0, err
+	}
+	changes := make(map[Vec3]*Block)
+	r.Range(func(id Vec3) {
+		changes[id] = NewBlock(TypeAir)
+	})
+	r.Range(func(id Vec3) {
+		b := s.world.Block(id)
+		if b != nil {
+			changes[id.Add(offset)] = b
+		}
+	})
+	cs := s.apply(changes)
+	nr := r.Add(offset)
+	s.pos1, s.pos2 = &nr.Min, &nr.Max
+	return cs.Len(), nil
+}
+
+func (s *EditSession) Undo() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.history) == 0 {
+		return 0, ErrNoHistory
+	}
+	cs := s.history[len(s.history)-1]
+	s.history = s.history[:len(s.history)-1]
+	s.world.ApplyChanges(cs.Before)
+	s.undone = append(s.undone, cs)
+	return cs.Len(), nil
+}
+
+func (s *EditSession) Redo() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.undone) == 0 {
+		return 0, ErrNoHistory
+	}
+	cs := s.undone[len(s.undone)-1]
+	s.undone = s.undone[:len(s.undone)-1]
+	s.world.ApplyChanges(cs.After)
+	s.history = append(s.history, cs)
+	return cs.Len(), nil
+}
+
+func IsBlockType(t int) bool {
+	_, ok := idToType[t]
+	return ok
+}
+
+func parseBlockType(s string) (int, error) {
+	t, err := strconv.Atoi(s)
+	if err != nil || !IsBlockType(t) {
+		return 0, fmt.Errorf("unknown block type %q", s)
+	}
+	return t, nil
+}
+
+// targetBlock 玩家看着的方块, 没有时使用脚下的位置
+func targetBlock(ctx *CommandContext) Vec3 {
+	block, _ := ctx.World.HitTest(ctx.Player.Pos(), ctx.Player.Front())
+	if block != nil {
+		return *block
+	}
+	return ctx.Player.Foot()
+}
+
+func editResult(n int, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d blocks changed", n), nil
+}
+
+func init() {
+	RegisterCommand("pos1", "/pos1", func(ctx *CommandContext, args []string) (string, error) {
+		id := targetBlock(ctx)
+		ctx.World.EditSession(ctx.Player).SetPos1(id)
+		return fmt.Sprintf("pos1 %v", id), nil
+	})
+	RegisterCommand("pos2", "/pos2", func(ctx *CommandContext, args []string) (string, error) {
+		id := targetBlock(ctx)
+		ctx.World.EditSession(ctx.Player).SetPos2(id)
+		return fmt.Sprintf("pos2 %v", id), nil
+	})
+	RegisterCommand("sel", "/sel", func(ctx *CommandContext, args []string) (string, error) {
+		r, err := ctx.World.EditSession(ctx.Player).Selection()
+		if err != nil {
+			return "", err
+		}
+		return r.String(), nil
+	})
+	RegisterCommand("set", "/set <type>", func(ctx *CommandContext, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", UsageError("/set <type>")
+		}
+		tp, err := parseBlockType(args[0])
+		if err != nil {
+			return "", err
+		}
+		return editResult(ctx.World.EditSession(ctx.Player).Fill(tp))
+	})
+	RegisterCommand("replace", "/replace <from> <to>", func(ctx *CommandContext, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", UsageError("/replace <from> <to>")
+		}
+		from, err := parseBlockType(args[0])
+		if err != nil {
+			return "", err
+		}
+		to, err := parseBlockType(args[1])
+		if err != nil {
+			return "", err
+		}
+		return editResult(ctx.World.EditSession(ctx.Player).Replace(from, to))
+	})
+	RegisterCommand("walls", "/walls <type>", func(ctx *CommandContext, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", UsageError("/walls <type>")
+		}
+		tp, err := parseBlockType(args[0])
+		if err != nil {
+			return "", err
+		}
+		return editResult(ctx.World.EditSession(ctx.Player).Walls(tp))
+	})
+	RegisterCommand("hollow", "/hollow", func(ctx *CommandContext, args []string) (string, error) {
+		return editResult(ctx.World.EditSession(ctx.Player).Hollow())
+	})
+	RegisterCommand("copy", "/copy", func(ctx *CommandContext, args []string) (string, error) {
+		n, err := ctx.World.EditSession(ctx.Player).Copy(ctx.Player.Foot())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d blocks copied", n), nil
+	})
+	RegisterCommand("paste", "/paste", func(ctx *CommandContext, args []string) (string, error) {
+		return editResult(ctx.World.EditSession(ctx.Player).Paste(ctx.Player.Foot()))
+	})
+	RegisterCommand("rotate", "/rotate <90|180|270>", func(ctx *CommandContext, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", UsageError("/rotate <90|180|270>")
+		}
+		d, err := strconv.Atoi(args[0])
+		if err != nil || d%90 != 0 {
+			return "", UsageError("/rotate <90|180|270>")
+		}
+		err = ctx.World.EditSession(ctx.Player).RotateClipboard(d)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("clipboard rotated %d", d), nil
+	})
+	RegisterCommand("move", "/move <dx> <dy> <dz>", func(ctx *CommandContext, args []string) (string, error) {
+		if len(args) != 3 {
+			return "", UsageError("/move <dx> <dy> <dz>")
+		}
+		var offset [3]int
+		for i, a := range args {
+			n, err := strconv.Atoi(a)
+			if err != nil {
+				return "", UsageError("/move <dx> <dy> <dz>")
+			}
+			offset[i] = n
+		}
+		return editResult(ctx.World.EditSession(ctx.Player).Move(Vec3{offset[0], offset[1], offset[2]}))
+	})
+	RegisterCommand("undo", "/undo", func(ctx *CommandContext, args []string) (string, error) {
+		return editResult(ctx.World.EditSession(ctx.Player).Undo())
+	})
+	RegisterCommand("redo", "/redo", func(ctx *CommandContext, args []string) (string, error) {
+		return editResult(ctx.World.EditSession(ctx.Player).Redo())
+	})
+}
@@ -0,0 +1,219 @@
+package world
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// editRegion 空中的选区, 不和生成的地形重叠
+var editRegion = Region{Min: Vec3{2, 40, 2}, Max: Vec3{4, 42, 4}}
+
+func newTestEditSession(t *testing.T, w *World) *EditSession {
+	w.Chunk(Vec3{})
+	s := w.EditSession(NewPlayer(mgl32.Vec3{}, nil, nil))
+	s.SetSelection(editRegion)
+	editRegion.Range(func(id Vec3) {
+		if b := w.Block(id); b.Type != TypeAir {
+			t.Fatalf("block %v at %v before edit", b, id)
+		}
+	})
+	return s
+}
+
+// checkRegion 检查 r 中的方块和 store 中保存的修改, 空气不保存
+func checkRegion(t *testing.T, w *World, r Region, want func(id Vec3) int) {
+	t.Helper()
+	stored := storedBlocks(t, Vec3{})
+	r.Range(func(id Vec3) {
+		tp := want(id)
+		if b := w.Block(id); b.Type != tp {
+			t.Fatalf("block %v at %v, want %d", b, id, tp)
+		}
+		b, ok := stored[id]
+		if tp == TypeAir && ok || tp != TypeAir && (!ok || b.Type != tp) {
+			t.Fatalf("stored %v at %v, want %d", b, id, tp)
+		}
+	})
+}
+
+func TestEditOperations(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	s := newTestEditSession(t, w)
+	center := Vec3{3, 41, 3}
+
+	if n, err := s.Fill(typeGrassBlock); err != nil || n != 27 {
+		t.Fatalf("fill %d %v", n, err)
+	}
+	checkRegion(t, w, editRegion, func(id Vec3) int { return typeGrassBlock })
+
+	if n, err := s.Replace(typeGrassBlock, typeSandBlock); err != nil || n != 27 {
+		t.Fatalf("replace %d %v", n, err)
+	}
+	if n, err := s.Replace(typeWood, typeGrassBlock); err != nil || n != 0 {
+		t.Fatalf("replace missing type %d %v", n, err)
+	}
+	checkRegion(t, w, editRegion, func(id Vec3) int { return typeSandBlock })
+
+	// 中间的一列不是墙
+	if n, err := s.Walls(typeWood); err != nil || n != 24 {
+		t.Fatalf("walls %d %v", n, err)
+	}
+	checkRegion(t, w, editRegion, func(id Vec3) int {
+		if id.X == center.X && id.Z == center.Z {
+			return typeSandBlock
+		}
+		return typeWood
+	})
+
+	// 只有中心的方块六面都是实心的
+	if n, err := s.Hollow(); err != nil || n != 1 {
+		t.Fatalf("hollow %d %v", n, err)
+	}
+	checkRegion(t, w, editRegion, func(id Vec3) int {
+		switch {
+		case id == center:
+			return TypeAir
+		case id.X == center.X && id.Z == center.Z:
+			return typeSandBlock
+		}
+		return typeWood
+	})
+}
+
+func TestEditUndoRedo(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	s := newTestEditSession(t, w)
+	if _, err := s.Undo(); err != ErrNoHistory {
+		t.Fatalf("undo without history: %v", err)
+	}
+	s.Fill(typeGrassBlock)
+	s.Replace(typeGrassBlock, typeSandBlock)
+
+	air := func(id Vec3) int { return TypeAir }
+	grass := func(id Vec3) int { return typeGrassBlock }
+	sand := func(id Vec3) int { return typeSandBlock }
+	for i, step := range []struct {
+		f    func() (int, error)
+		want func(id Vec3) int
+	}{
+		{s.Undo, grass},
+		{s.Undo, air},
+		{s.Redo, grass},
+		{s.Redo, sand},
+		{s.Undo, grass},
+	} {
+		if n, err := step.f(); err != nil || n != 27 {
+			t.Fatalf("step %d: %d %v", i, n, err)
+		}
+		checkRegion(t, w, editRegion, step.want)
+	}
+
+	// 新的编辑清除 redo
+	s.Fill(typeWood)
+	if _, err := s.Redo(); err != ErrNoHistory {
+		t.Fatalf("redo after edit: %v", err)
+	}
+	checkRegion(t, w, editRegion, func(id Vec3) int { return typeWood })
+}
+
+func TestEditCopyPaste(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	s := newTestEditSession(t, w)
+	origin := editRegion.Min
+	w.ApplyChanges(map[Vec3]*Block{
+		origin:                    NewBlock(typeGrassBlock),
+		origin.Add(Vec3{1, 0, 0}): NewBlock(typeSandBlock),
+	})
+
+	if _, err := s.Paste(origin); err != ErrNoClipboard {
+		t.Fatalf("paste without clipboard: %v", err)
+	}
+	if n, err := s.Copy(origin); err != nil || n != 27 {
+		t.Fatalf("copy %d %v", n, err)
+	}
+	// 顺时针旋转 90 度后 +x 方向变为 +z
+	if err := s.RotateClipboard(90); err != nil {
+		t.Fatal(err)
+	}
+	to := Vec3{8, 40, 8}
+	if n, err := s.Paste(to); err != nil || n != 27 {
+		t.Fatalf("paste %d %v", n, err)
+	}
+	pasted := Region{Min: to.Add(Vec3{-2, 0, 0}), Max: to.Add(Vec3{0, 2, 2})}
+	checkRegion(t, w, pasted, func(id Vec3) int {
+		switch id {
+		case to:
+			return typeGrassBlock
+		case to.Add(Vec3{0, 0, 1}):
+			return typeSandBlock
+		}
+		return TypeAir
+	})
+
+	// 移动后原来的位置是空气, 选区跟着移动
+	offset := Vec3{0, 0, 4}
+	if n, err := s.Move(offset); err != nil || n != 54 {
+		t.Fatalf("move %d %v", n, err)
+	}
+	moved := editRegion.Add(offset)
+	if r, _ := s.Selection(); r != moved {
+		t.Fatalf("selection %v after move, want %v", r, moved)
+	}
+	checkRegion(t, w, editRegion, func(id Vec3) int { return TypeAir })
+	checkRegion(t, w, moved, func(id Vec3) int {
+		switch id.Sub(offset) {
+		case origin:
+			return typeGrassBlock
+		case origin.Add(Vec3{1, 0, 0}):
+			return typeSandBlock
+		}
+		return TypeAir
+	})
+}
+
+func TestClipboardRotate(t *testing.T) {
+	cb := &Clipboard{Blocks: map[Vec3]*Block{
+		{1, 0, 0}:  NewBlock(typeGrassBlock),
+		{2, 1, -1}: NewBlock(typeSandBlock),
+		{0, 2, 3}:  NewBlock(typeWood),
+	}}
+	r := cb.Rotate(90)
+	if b := r.Blocks[Vec3{0, 0, 1}]; b == nil || b.Type != typeGrassBlock {
+		t.Fatalf("rotated 90: %v", r.Blocks)
+	}
+	for _, c := range []struct{ a, b int }{{90, 270}, {180, 180}, {360, 0}, {-90, 90}, {450, -90}} {
+		if got := cb.Rotate(c.a).Rotate(c.b); !reflect.DeepEqual(got, cb) {
+			t.Errorf("rotate %d then %d: %v", c.a, c.b, got.Blocks)
+		}
+	}
+}
+
+func TestApplyChangesEvents(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	w.Chunks([]Vec3{{0, 0, 0}, {1, 0, 0}})
+	events := w.Watcher.Watch(64)
+	defer w.Watcher.Unwatch(events)
+	// 不在 chunk 边上的方块只影响自己的 chunk
+	w.ApplyChanges(map[Vec3]*Block{
+		{2, 40, 2}:              NewBlock(typeGrassBlock),
+		{3, 41, 5}:              NewBlock(typeGrassBlock),
+		{5, 40, 8}:              NewBlock(typeSandBlock),
+		{ChunkWidth + 4, 40, 4}: NewBlock(typeSandBlock),
+	})
+	updates := make(map[Vec3]int)
+	for len(events) > 0 {
+		if e := (<-events).(Event); e.Type == "Chunk.Update" {
+			updates[e.Data.(Vec3)]++
+		}
+	}
+	want := map[Vec3]int{{0, 0, 0}: 1, {1, 0, 0}: 1}
+	if !reflect.DeepEqual(updates, want) {
+		t.Fatalf("updates %v, want %v", updates, want)
+	}
+}
@@ -0,0 +1,150 @@
+package world
+
+import (
+	"fmt"
+
+	opensimplex "github.com/ojrac/opensimplex-go"
+)
+
+const (
+	GeneratorDefault = "default"
+	GeneratorFlat    = "flat"
+	GeneratorVoid    = "void"
+)
+
+// Generator 维度的地形生成设置
+type Generator struct {
+	Type string `json:"type"`
+	// Seed 噪声的种子, 0 为最初的地形
+	Seed int64 `json:"seed"`
+	// Height flat 地形的高度
+	Height int `json:"height,omitempty"`
+
+	sim *opensimplex.Noise
+}
+
+func (g *Generator) init() error {
+	switch g.Type {
+	case "":
+		g.Type = GeneratorDefault
+	case GeneratorDefault, GeneratorVoid:
+	case GeneratorFlat:
+		if g.Height == 0 {
+			g.Height = 16
+		}
+		if g.Height <= 11 {
+			return fmt.Errorf("flat height %d too low", g.Height)
+		}
+	default:
+		return fmt.Errorf("unknown generator %q", g.Type)
+	}
+	g.sim = sim
+	if g.Seed != 0 {
+		g.sim = opensimplex.NewWithSeed(g.Seed)
+	}
+	return nil
+}
+
+func (g *Generator) noise2(x, y float32, octaves int, persistence, lacunarity float32) float32 {
+	return simplex2(g.sim, x, y, octaves, persistence, lacunarity)
+}
+
+func (g *Generator) noise3(x, y, z float32, octaves int, persistence, lacunarity float32) float32 {
+	return simplex3(g.sim, x, y, z, octaves, persistence, lacunarity)
+}
+
+// chunk 生成 chunk 中的方块
+func (g *Generator) chunk(cid Vec3) map[Vec3]*Block {
+	m := make(map[Vec3]*Block)
+	switch g.Type {
+	case GeneratorVoid:
+		return m
+	case GeneratorFlat:
+		for x := cid.X * ChunkWidth; x < (cid.X+1)*ChunkWidth; x++ {
+			for z := cid.Z * ChunkWidth; z < (cid.Z+1)*ChunkWidth; z++ {
+				for y := 11; y < g.Height; y++ {
+					m[Vec3{x, y, z}] = NewBlock(typeGrassBlock)
+				}
+			}
+		}
+		placeStructures(g, cid, m)
+		return m
+	}
+	p, q := cid.X, cid.Z
+	for dx := 0; dx < ChunkWidth; dx++ {
+		for dz := 0; dz < ChunkWidth; dz++ {
+			x, z := p*ChunkWidth+dx, q*ChunkWidth+dz
+			h, grass := g.height(x, z)
+			tb := typeGrassBlock
+			if !grass {
+				tb = typeSandBlock
+			}
+			// grass and sand
+			for y := 11; y < h; y++ {
+				m[Vec3{x, y, z}] = NewBlock(tb)
+			}
+
+			// flowers
+			if tb == typeGrassBlock {
+				if g.noise2(-float32(x)*0.1, float32(z)*0.1, 4, 0.8, 2) > 0.6 {
+					m[Vec3{x, h, z}] = NewBlock(typeGrass)
+				}
+				if g.noise2(float32(x)*0.05, float32(-z)*0.05, 4, 0.8, 2) > 0.7 {
+					tb := 18 + int(g.noise2(float32(x)*0.1, float32(z)*0.1, 4, 0.8, 2)*7)
+					m[Vec3{x, h, z}] = NewBlock(tb)
+				}
+			}
+
+			// tree
+			if tb == typeGrassBlock {
+				ok := true
+				if dx-4 < 0 || dz-4 < 0 ||
+					dx+4 > ChunkWidth || dz+4 > ChunkWidth {
+					ok = false
+				}
+				if ok && g.noise2(float32(x), float32(z), 6, 0.5, 2) > 0.79 {
+					for y := h + 3; y < h+8; y++ {
+						for ox := -3; ox <= 3; ox++ {
+							for oz := -3; oz <= 3; oz++ {
+								d := ox*ox + oz*oz + (y-h-4)*(y-h-4)
+								if d < 11 {
+									m[Vec3{x + ox, y, z + oz}] = NewBlock(typeLeaves)
+								}
+							}
+						}
+					}
+					for y := h; y < h+7; y++ {
+						m[Vec3{x, y, z}] = NewBlock(typeWood)
+					}
+				}
+			}
+
+			// cloud
+			for y := 64; y < 72; y++ {
+				if g.noise3(float32(x)*0.01, float32(y)*0.1, float32(z)*0.01, 8, 0.5, 2) > 0.69 {
+					m[Vec3{x, y, z}] = NewBlock(typeCloud)
+				}
+			}
+		}
+	}
+	placeStructures(g, cid, m)
+	return m
+}
+
+// height 返回地表上第一层空气的高度, 以及地表是否为草地
+func (g *Generator) height(x, z int) (int, bool) {
+	switch g.Type {
+	case GeneratorVoid:
+		return 0, false
+	case GeneratorFlat:
+		return g.Height, true
+	}
+	f := g.noise2(float32(x)*0.01, float32(z)*0.01, 4, 0.5, 2)
+	h2 := g.noise2(float32(-x)*0.01, float32(-z)*0.01, 2, 0.9, 2)
+	mh := int(h2*32 + 16)
+	h := int(f * float32(mh))
+	if h <= 12 {
+		return 12, false
+	}
+	return h, true
+}
@@ -0,0 +1,52 @@
+package world
+
+import (
+	"errors"
+)
+
+// ImportChunk 把外部地图的一个 chunk 写入 store, blocks 中没有的位置视为空气
+// 生成的地形会被覆盖: 生成器在空气位置放的方块会保存为空气, 和生成的地形相同的方块不保存
+func (w *World) ImportChunk(cid Vec3, blocks map[Vec3]*Block) (int, error) {
+	if w.store == nil {
+		return 0, errors.New("store not initialized")
+	}
+	changes := make(map[Vec3]*Block, len(blocks))
+	for id, b := range blocks {
+		if id.Chunkid() != cid {
+			return 0, errors.New("block out of chunk")
+		}
+		changes[id] = b
+	}
+	generated := w.generatedChunk(cid)
+	for id := range generated {
+		if _, ok := blocks[id]; !ok && id.Chunkid() == cid {
+			changes[id] = NewBlock(TypeAir)
+		}
+	}
+	// 之前保存的修改也被覆盖
+	err := w.store.RangeBlocks(cid, func(id Vec3, b *Block) {
+		if _, ok := changes[id]; !ok {
+			changes[id] = NewBlock(TypeAir)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	var dels []Vec3
+	for id, b := range changes {
+		g, ok := generated[id]
+		var e paletteEntry
+		if ok {
+			e = paletteEntry{g.Type, g.Life}
+		}
+		if sameAsGenerated(id, e, ok, b) {
+			dels = append(dels, id)
+			delete(changes, id)
+		}
+	}
+	err = w.store.DeleteBlocks(dels)
+	if err != nil {
+		return 0, err
+	}
+	return len(changes), w.store.UpdateBlocks(changes)
+}
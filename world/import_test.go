@@ -0,0 +1,34 @@
+package world
+
+import (
+	"testing"
+)
+
+func TestImportChunk(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	cid := Vec3{}
+	// 导入前玩家的修改, 导入的 chunk 中没有
+	err := w.store.UpdateBlocks(map[Vec3]*Block{{2, 40, 2}: NewBlock(typeWood)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := make(map[Vec3]*Block)
+	for id, b := range w.generatedChunk(cid) {
+		if id.Chunkid() == cid {
+			blocks[id] = b
+		}
+	}
+	blocks[Vec3{3, 40, 3}] = NewBlock(typeGrassBlock)
+	n, err := w.ImportChunk(cid, blocks)
+	if err != nil || n != 1 {
+		t.Fatalf("import %d %v", n, err)
+	}
+	stored := storedBlocks(t, cid)
+	if len(stored) != 1 || stored[Vec3{3, 40, 3}] == nil || stored[Vec3{3, 40, 3}].Type != typeGrassBlock {
+		t.Fatalf("stored %v", stored)
+	}
+	if _, err := w.ImportChunk(cid, map[Vec3]*Block{{ChunkWidth, 0, 0}: NewBlock(typeWood)}); err == nil {
+		t.Fatal("imported block out of chunk")
+	}
+}
@@ -0,0 +1,391 @@
+package world
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var chunkMemory = flag.Int("chunk-memory", 256, "MB of blocks kept in loaded chunks, least recently used chunks are unloaded above it")
+
+// blockMemory 每个方块大约占用的内存, 包括 blocks 和 generated 中的项
+const blockMemory = 200
+
+// ChunkState chunk 的生命周期: 后台加载, 加载完成, 有没有保存的修改, 卸载前保存, 已卸载
+type ChunkState int32
+
+const (
+	ChunkLoading ChunkState = iota
+	ChunkLoaded
+	ChunkDirty
+	ChunkSaving
+	ChunkUnloaded
+)
+
+func (s ChunkState) String() string {
+	switch s {
+	case ChunkLoading:
+		return "loading"
+	case ChunkLoaded:
+		return "loaded"
+	case ChunkDirty:
+		return "dirty"
+	case ChunkSaving:
+		return "saving"
+	case ChunkUnloaded:
+		return "unloaded"
+	}
+	return fmt.Sprintf("ChunkState(%d)", int32(s))
+}
+
+func (c *Chunk) State() ChunkState {
+	return ChunkState(atomic.LoadInt32(&c.state))
+}
+
+func (c *Chunk) setState(s ChunkState) {
+	atomic.StoreInt32(&c.state, int32(s))
+}
+
+// Pinned 被玩家, 渲染等使用的 chunk 不会被卸载
+func (c *Chunk) Pinned() bool {
+	return atomic.LoadInt32(&c.pins) > 0
+}
+
+// Unpin 释放 PinChunk 的引用
+func (c *Chunk) Unpin() {
+	if atomic.AddInt32(&c.pins, -1) < 0 {
+		log.Panicf("chunk %v unpinned too many times", c.id)
+	}
+}
+
+// markDirty 记录还没有写入 store 的修改
+func (c *Chunk) markDirty(changes map[Vec3]*Block) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[Vec3]*Block)
+	}
+	for id, b := range changes {
+		c.pending[id] = b
+	}
+	if c.State() == ChunkLoaded {
+		c.setState(ChunkDirty)
+	}
+}
+
+// dirty 有没有写入或者正在写入的修改
+func (c *Chunk) dirty() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.pending) > 0 || c.flushing > 0
+}
+
+// ChunkStats 已加载的 chunk 的统计
+type ChunkStats struct {
+	Loaded  int
+	Dirty   int
+	Pinned  int
+	Memory  int64 // 估计的内存, 字节
+	Evicted int64
+}
+
+func (s ChunkStats) String() string {
+	return fmt.Sprintf("%d loaded (%d dirty, %d pinned), %dMB, %d evicted",
+		s.Loaded, s.Dirty, s.Pinned, s.Memory>>20, s.Evicted)
+}
+
+type chunkEntry struct {
+	chunk *Chunk
+	used  int64
+}
+
+// chunkCache 已加载的 chunk, 超过内存预算时卸载最久没有使用并且没有 pin 的 chunk
+type chunkCache struct {
+	mutex  sync.Mutex
+	chunks map[Vec3]*chunkEntry
+	tick   int64
+	budget int64
+	// versions 卸载的 chunk 的版本号, 重新加载后版本号继续增加, 渲染才会更新
+	versions map[Vec3]int64
+	evicted  int64
+	evicting int32
+}
+
+func newChunkCache(budget int64) *chunkCache {
+	return &chunkCache{
+		chunks:   make(map[Vec3]*chunkEntry),
+		budget:   budget,
+		versions: make(map[Vec3]int64),
+	}
+}
+
+func (cc *chunkCache) get(id Vec3) (*Chunk, bool) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	e, ok := cc.chunks[id]
+	if !ok {
+		return nil, false
+	}
+	cc.tick++
+	e.used = cc.tick
+	return e.chunk, true
+}
+
+// add 加入或者替换 chunk, 版本号大于之前的 chunk
+func (cc *chunkCache) add(id Vec3, c *Chunk) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	if e, ok := cc.chunks[id]; ok {
+		atomic.AddInt64(&c.version, e.chunk.V()+1)
+		e.chunk.setState(ChunkUnloaded)
+	} else if v, ok := cc.versions[id]; ok {
+		atomic.AddInt64(&c.version, v+1)
+		delete(cc.versions, id)
+	}
+	cc.tick++
+	cc.chunks[id] = &chunkEntry{chunk: c, used: cc.tick}
+	c.setState(ChunkLoaded)
+}
+
+// pin 只 pin 已加载的 chunk
+func (cc *chunkCache) pin(id Vec3) *Chunk {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	e, ok := cc.chunks[id]
+	if !ok {
+		return nil
+	}
+	atomic.AddInt32(&e.chunk.pins, 1)
+	return e.chunk
+}
+
+// remove 卸载 c, 这时被 pin 或者已经被替换时返回 false
+func (cc *chunkCache) remove(c *Chunk) bool {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	e, ok := cc.chunks[c.id]
+	if !ok || e.chunk != c || c.Pinned() || c.dirty() {
+		return false
+	}
+	delete(cc.chunks, c.id)
+	cc.versions[c.id] = c.V()
+	cc.evicted++
+	c.setState(ChunkUnloaded)
+	return true
+}
+
+func (cc *chunkCache) all() []*Chunk {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	chunks := make([]*Chunk, 0, len(cc.chunks))
+	for _, e := range cc.chunks {
+		chunks = append(chunks, e.chunk)
+	}
+	return chunks
+}
+
+// victims 超过预算时返回需要卸载的 chunk, 从最久没有使用的开始
+func (cc *chunkCache) victims() []*Chunk {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	var memory int64
+	entries := make([]*chunkEntry, 0, len(cc.chunks))
+	for _, e := range cc.chunks {
+		memory += e.chunk.memory()
+		// 刚加载或者使用的 chunk 不卸载
+		if !e.chunk.Pinned() && e.used != cc.tick {
+			entries = append(entries, e)
+		}
+	}
+	if memory <= cc.budget {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used < entries[j].used
+	})
+	var chunks []*Chunk
+	for _, e := range entries {
+		if memory <= cc.budget {
+			break
+		}
+		memory -= e.chunk.memory()
+		chunks = append(chunks, e.chunk)
+	}
+	return chunks
+}
+
+func (cc *chunkCache) stats() ChunkStats {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	st := ChunkStats{Loaded: len(cc.chunks), Evicted: cc.evicted}
+	for _, e := range cc.chunks {
+		st.Memory += e.chunk.memory()
+		if e.chunk.Pinned() {
+			st.Pinned++
+		}
+		if e.chunk.State() == ChunkDirty {
+			st.Dirty++
+		}
+	}
+	return st
+}
+
+func (c *Chunk) memory() int64 {
+	return atomic.LoadInt64(&c.count) * blockMemory
+}
+
+// PinChunk pin 已加载的 chunk, 没有加载时返回 nil. 用完后调用 Unpin
+func (w *World) PinChunk(id Vec3) *Chunk {
+	return w.chunks.pin(id)
+}
+
+// PinArea 让 owner (比如玩家) 只 pin center 周围 r 个 chunk 中已加载的, 没有加载的请求后台加载.
+// r 小于 0 时释放 owner 所有的 pin
+func (w *World) PinArea(owner interface{}, center Vec3, r int) {
+	w.pinsMutex.Lock()
+	defer w.pinsMutex.Unlock()
+	old := w.pins[owner]
+	pinned := make(map[Vec3]*Chunk)
+	for dx := -r; dx <= r; dx++ {
+		for dz := -r; dz <= r; dz++ {
+			id := Vec3{center.X + dx, 0, center.Z + dz}
+			if c, ok := old[id]; ok && c.State() != ChunkUnloaded {
+				pinned[id] = c
+				delete(old, id)
+				continue
+			}
+			if c := w.PinChunk(id); c != nil {
+				pinned[id] = c
+			} else {
+				w.loader.Request(id, true)
+			}
+		}
+	}
+	for _, c := range old {
+		c.Unpin()
+	}
+	if len(pinned) == 0 {
+		delete(w.pins, owner)
+		return
+	}
+	w.pins[owner] = pinned
+}
+
+// writeChanges 写入 chunk 的修改, 和生成的地形相同的方块从 store 中删除
+func (w *World) writeChanges(c *Chunk, changes map[Vec3]*Block) error {
+	puts := make(map[Vec3]*Block)
+	var dels []Vec3
+	for id, b := range changes {
+		if c != nil && c.isGenerated(id, b) {
+			dels = append(dels, id)
+			continue
+		}
+		puts[id] = b
+	}
+	if len(puts) > 0 {
+		err := w.store.UpdateBlocks(puts)
+		if err != nil {
+			return err
+		}
+	}
+	if len(dels) > 0 {
+		return w.store.DeleteBlocks(dels)
+	}
+	return nil
+}
+
+// flushChunk 写入 chunk 中没有保存的修改, 失败时修改保留, 下次重试
+func (w *World) flushChunk(c *Chunk) error {
+	if w.store == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	batch := c.pending
+	c.pending = nil
+	if len(batch) == 0 {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.flushing++
+	c.mutex.Unlock()
+	err := w.writeChanges(c, batch)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.flushing--
+	if err != nil {
+		if c.pending == nil {
+			c.pending = make(map[Vec3]*Block)
+		}
+		for id, b := range batch {
+			if _, ok := c.pending[id]; !ok {
+				c.pending[id] = b
+			}
+		}
+		return err
+	}
+	if len(c.pending) == 0 && c.flushing == 0 && c.State() == ChunkDirty {
+		c.setState(ChunkLoaded)
+	}
+	return nil
+}
+
+// flushDirty 重试写入失败的修改
+func (w *World) flushDirty() {
+	for _, c := range w.chunks.all() {
+		if c.State() != ChunkDirty {
+			continue
+		}
+		err := w.flushChunk(c)
+		if err != nil {
+			log.Printf("flush chunk %v error:%s", c.id, err)
+		}
+	}
+}
+
+// unloadChunk 保存修改后卸载 chunk, 被 pin 或者保存失败时不卸载
+func (w *World) unloadChunk(c *Chunk) bool {
+	if c.Pinned() {
+		return false
+	}
+	if c.State() == ChunkDirty {
+		c.setState(ChunkSaving)
+		err := w.flushChunk(c)
+		if err != nil {
+			c.setState(ChunkDirty)
+			log.Printf("save chunk %v before unload error:%s", c.id, err)
+			return false
+		}
+	}
+	if !w.chunks.remove(c) {
+		// 保存时又有了新的修改
+		if c.State() == ChunkSaving {
+			if c.dirty() {
+				c.setState(ChunkDirty)
+			} else {
+				c.setState(ChunkLoaded)
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// evictChunks 超过 -chunk-memory 时卸载 chunk, 同时只有一个 goroutine 执行
+func (w *World) evictChunks() {
+	if !atomic.CompareAndSwapInt32(&w.chunks.evicting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&w.chunks.evicting, 0)
+	for _, c := range w.chunks.victims() {
+		if w.unloadChunk(c) {
+			log.Printf("unload chunk %v", c.id)
+		}
+	}
+}
+
+func (w *World) ChunkStats() ChunkStats {
+	return w.chunks.stats()
+}
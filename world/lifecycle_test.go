@@ -0,0 +1,82 @@
+package world
+
+import (
+	"errors"
+	"testing"
+)
+
+// failStore fail 为 true 时写入方块失败
+type failStore struct {
+	Store
+	fail bool
+}
+
+func (s *failStore) UpdateBlocks(blocks map[Vec3]*Block) error {
+	if s.fail {
+		return errors.New("write failed")
+	}
+	return s.Store.UpdateBlocks(blocks)
+}
+
+func TestChunkLifecycle(t *testing.T) {
+	testBackends(t, testChunkLifecycle)
+}
+
+func testChunkLifecycle(t *testing.T) {
+	d, err := LoadDimension(DefaultDimension)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &failStore{Store: store}
+	w := newWorld(d, fs, 2)
+	// 预算只够一个 chunk
+	a, b, c := Vec3{0, 0, 0}, Vec3{1, 0, 0}, Vec3{2, 0, 0}
+	ca := w.Chunk(a)
+	w.chunks.budget = ca.memory()
+	if ca.State() != ChunkLoaded {
+		t.Fatalf("state %v", ca.State())
+	}
+
+	// 写入失败的修改保留在 chunk 中, 卸载前重试
+	id := Vec3{3, 40, 3}
+	fs.fail = true
+	w.UpdateBlock(id, NewBlock(4))
+	if ca.State() != ChunkDirty {
+		t.Fatalf("state after failed write %v", ca.State())
+	}
+	version := ca.V()
+	w.Chunk(b)
+	if _, ok := w.loadChunk(a); !ok {
+		t.Fatal("dirty chunk unloaded")
+	}
+	fs.fail = false
+	w.Chunk(c)
+	if _, ok := w.loadChunk(a); ok || ca.State() != ChunkUnloaded {
+		t.Fatalf("chunk not unloaded: %v", ca.State())
+	}
+	if n := len(storedBlocks(t, a)); n != 1 {
+		t.Fatalf("%d blocks saved on unload", n)
+	}
+
+	// 重新加载后版本号更大, 修改还在
+	ca = w.Chunk(a)
+	if ca.V() <= version || ca.Block(id).Type != 4 {
+		t.Fatalf("reloaded version %d (was %d), block %v", ca.V(), version, ca.Block(id))
+	}
+
+	// pin 的 chunk 不会被卸载
+	w.PinArea("player", a, 0)
+	w.Chunk(b)
+	w.Chunk(c)
+	if _, ok := w.loadChunk(a); !ok {
+		t.Fatal("pinned chunk unloaded")
+	}
+	if st := w.ChunkStats(); st.Pinned != 1 {
+		t.Fatalf("stats %v", st)
+	}
+	w.PinArea("player", a, -1)
+	w.Chunk(b)
+	if _, ok := w.loadChunk(a); ok {
+		t.Fatal("unpinned chunk not unloaded")
+	}
+}
@@ -0,0 +1,275 @@
+package world
+
+import (
+	"container/heap"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var chunkWorkers = flag.Int("chunk-workers", 4, "goroutines loading chunks in the background")
+
+// LoaderStats chunk 加载的统计
+type LoaderStats struct {
+	Queued    int   // 等待加载的 chunk
+	Loading   int   // 正在加载的 chunk
+	Loaded    int64 // 加载完成的 chunk
+	Deduped   int64 // 已经在队列中或正在加载的请求
+	Cancelled int64 // 离开范围后取消的请求
+}
+
+func (s LoaderStats) String() string {
+	return fmt.Sprintf("queued %d, loading %d, loaded %d, %d deduped, %d cancelled",
+		s.Queued, s.Loading, s.Loaded, s.Deduped, s.Cancelled)
+}
+
+type chunkRequest struct {
+	id      Vec3
+	visible bool
+	dist    int // 到 focus 的距离的平方
+	index   int // 在队列中的位置, 正在加载时为 -1
+	loading bool
+	done    chan bool
+	chunk   *Chunk
+}
+
+// chunkQueue 可见的 chunk 优先, 然后按到玩家的距离
+type chunkQueue []*chunkRequest
+
+func (q chunkQueue) Len() int { return len(q) }
+
+func (q chunkQueue) Less(i, j int) bool {
+	if q[i].visible != q[j].visible {
+		return q[i].visible
+	}
+	return q[i].dist < q[j].dist
+}
+
+func (q chunkQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *chunkQueue) Push(x interface{}) {
+	r := x.(*chunkRequest)
+	r.index = len(*q)
+	*q = append(*q, r)
+}
+
+func (q *chunkQueue) Pop() interface{} {
+	old := *q
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	r.index = -1
+	return r
+}
+
+// ChunkLoader 在后台加载 chunk, 同一个 chunk 同时只加载一次.
+// Focus 设置玩家所在的 chunk, 队列按距离排序, 超出范围的请求被取消
+type ChunkLoader struct {
+	w       *World
+	workers int
+	start   sync.Once
+	wg      sync.WaitGroup
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	queue    chunkQueue
+	requests map[Vec3]*chunkRequest
+	center   Vec3
+	radius   int // 0 表示不取消请求
+	stats    LoaderStats
+	closed   bool
+}
+
+func newChunkLoader(w *World, workers int) *ChunkLoader {
+	l := &ChunkLoader{
+		w:        w,
+		workers:  workers,
+		requests: make(map[Vec3]*chunkRequest),
+	}
+	l.cond = sync.NewCond(&l.mutex)
+	return l
+}
+
+func (l *ChunkLoader) distance(id Vec3) int {
+	dx, dz := id.X-l.center.X, id.Z-l.center.Z
+	return dx*dx + dz*dz
+}
+
+// Request 请求在后台加载 chunk, 已经在队列中时只提高优先级. Close 之后不再加载
+func (l *ChunkLoader) Request(id Vec3, visible bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return
+	}
+	l.start.Do(func() {
+		l.wg.Add(l.workers)
+		for i := 0; i < l.workers; i++ {
+			go l.work()
+		}
+	})
+	if _, ok := l.w.loadChunk(id); ok {
+		return
+	}
+	if r, ok := l.requests[id]; ok {
+		l.stats.Deduped++
+		if !r.loading && visible && !r.visible {
+			r.visible = true
+			heap.Fix(&l.queue, r.index)
+		}
+		return
+	}
+	r := &chunkRequest{id: id, visible: visible, dist: l.distance(id), done: make(chan bool)}
+	l.requests[id] = r
+	heap.Push(&l.queue, r)
+	l.cond.Signal()
+}
+
+// Focus 以 center 为中心重新排序队列, 取消距离超过 radius 个 chunk 的请求
+func (l *ChunkLoader) Focus(center Vec3, radius int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.center == center && l.radius == radius {
+		return
+	}
+	l.center, l.radius = center, radius
+	q := l.queue[:0]
+	for _, r := range l.queue {
+		r.dist = l.distance(r.id)
+		if radius > 0 && r.dist > radius*radius {
+			delete(l.requests, r.id)
+			l.stats.Cancelled++
+			continue
+		}
+		q = append(q, r)
+	}
+	for i := len(q); i < len(l.queue); i++ {
+		l.queue[i] = nil
+	}
+	l.queue = q
+	for i, r := range l.queue {
+		r.index = i
+	}
+	heap.Init(&l.queue)
+}
+
+// next 取出优先级最高的请求, 队列为空时等待, Close 之后返回 nil
+func (l *ChunkLoader) next() *chunkRequest {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for l.queue.Len() == 0 && !l.closed {
+		l.cond.Wait()
+	}
+	if l.closed {
+		return nil
+	}
+	r := heap.Pop(&l.queue).(*chunkRequest)
+	r.loading = true
+	return r
+}
+
+func (l *ChunkLoader) work() {
+	defer l.wg.Done()
+	for {
+		r := l.next()
+		if r == nil {
+			return
+		}
+		l.finish(r, l.build(r.id))
+	}
+}
+
+// Close 取消队列中的请求, 等待正在加载的 chunk 完成后停止后台的 goroutine.
+// 之后 Load 仍然可以在当前 goroutine 加载
+func (l *ChunkLoader) Close() {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return
+	}
+	l.closed = true
+	for _, r := range l.queue {
+		delete(l.requests, r.id)
+		l.stats.Cancelled++
+	}
+	l.queue = nil
+	l.cond.Broadcast()
+	l.mutex.Unlock()
+	l.wg.Wait()
+}
+
+func (l *ChunkLoader) build(id Vec3) *Chunk {
+	chunk, err := l.w.buildChunk(id)
+	if err != nil {
+		log.Printf("load chunk(%v) error:%s", id, err)
+		return nil
+	}
+	return chunk
+}
+
+func (l *ChunkLoader) finish(r *chunkRequest, chunk *Chunk) {
+	if chunk != nil {
+		l.w.storeChunk(r.id, chunk)
+	}
+	l.mutex.Lock()
+	delete(l.requests, r.id)
+	if chunk != nil {
+		l.stats.Loaded++
+	}
+	l.mutex.Unlock()
+	r.chunk = chunk
+	close(r.done)
+}
+
+// Load 加载 chunk 并等待, 正在后台加载时等待它完成, 在队列中时直接在当前 goroutine 加载
+func (l *ChunkLoader) Load(id Vec3) *Chunk {
+	l.mutex.Lock()
+	if c, ok := l.w.loadChunk(id); ok {
+		l.mutex.Unlock()
+		return c
+	}
+	r, ok := l.requests[id]
+	if ok && r.loading {
+		l.stats.Deduped++
+		l.mutex.Unlock()
+		<-r.done
+		return r.chunk
+	}
+	if ok {
+		heap.Remove(&l.queue, r.index)
+	} else {
+		r = &chunkRequest{id: id, index: -1, done: make(chan bool)}
+		l.requests[id] = r
+	}
+	r.loading = true
+	l.mutex.Unlock()
+	l.finish(r, l.build(id))
+	return r.chunk
+}
+
+func (l *ChunkLoader) Stats() LoaderStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	st := l.stats
+	st.Queued = l.queue.Len()
+	st.Loading = len(l.requests) - st.Queued
+	return st
+}
+
+func (w *World) Loader() *ChunkLoader {
+	return w.loader
+}
+
+// TryChunk 返回已加载的 chunk, 没有加载时请求后台加载并返回 nil, 不会阻塞
+func (w *World) TryChunk(id Vec3) *Chunk {
+	if c, ok := w.loadChunk(id); ok {
+		return c
+	}
+	w.loader.Request(id, true)
+	return nil
+}
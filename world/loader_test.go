@@ -0,0 +1,86 @@
+package world
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChunkLoaderQueue(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	// 没有 worker, 由测试取出请求
+	l := newChunkLoader(w, 0)
+	l.Focus(Vec3{0, 0, 0}, 3)
+	far, near, visible := Vec3{3, 0, 0}, Vec3{1, 0, 0}, Vec3{2, 0, 2}
+	l.Request(far, false)
+	l.Request(near, false)
+	l.Request(visible, false)
+	l.Request(visible, true)
+	if st := l.Stats(); st.Queued != 3 || st.Deduped != 1 {
+		t.Fatalf("stats %v", st)
+	}
+	if r := l.next(); r.id != visible {
+		t.Fatalf("first %v, want visible %v", r.id, visible)
+	} else {
+		l.finish(r, l.build(r.id))
+	}
+	// 玩家走开后 far 超出范围
+	l.Focus(Vec3{-2, 0, 0}, 3)
+	if st := l.Stats(); st.Queued != 1 || st.Cancelled != 1 || st.Loaded != 1 {
+		t.Fatalf("stats after focus %v", st)
+	}
+	// Load 直接加载队列中的 chunk
+	if c := l.Load(near); c == nil || c.Id() != near {
+		t.Fatalf("load %v: %v", near, c)
+	}
+	if st := l.Stats(); st.Queued != 0 || st.Loading != 0 || st.Loaded != 2 {
+		t.Fatalf("stats after load %v", st)
+	}
+}
+
+func TestTryChunk(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	id := Vec3{5, 0, -3}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.TryChunk(id)
+			w.Chunk(id)
+		}()
+	}
+	wg.Wait()
+	deadline := time.Now().Add(5 * time.Second)
+	for w.TryChunk(id) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("chunk not loaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if st := w.Loader().Stats(); st.Loaded != 1 {
+		t.Fatalf("chunk loaded %d times: %v", st.Loaded, st)
+	}
+}
+
+func TestChunkLoaderClose(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	l := newChunkLoader(w, 2)
+	for x := 0; x < 8; x++ {
+		l.Request(Vec3{x, 0, 0}, false)
+	}
+	// Close 等待 worker 退出
+	l.Close()
+	l.Close()
+	l.Request(Vec3{9, 0, 0}, true)
+	if st := l.Stats(); st.Queued != 0 || st.Loading != 0 || st.Loaded+st.Cancelled != 8 {
+		t.Fatalf("stats after close %v", st)
+	}
+	id := Vec3{10, 0, 0}
+	if c := l.Load(id); c == nil || c.Id() != id {
+		t.Fatalf("load after close %v: %v", id, c)
+	}
+}
@@ -51,6 +51,10 @@ func mix(a, b, factor float32) float32 {
 }
 
 func noise2(x, y float32, octaves int, persistence, lacunarity float32) float32 {
+	return simplex2(sim, x, y, octaves, persistence, lacunarity)
+}
+
+func simplex2(sim *opensimplex.Noise, x, y float32, octaves int, persistence, lacunarity float32) float32 {
 	var (
 		freq  float32 = 1
 		amp   float32 = 1
@@ -67,6 +71,10 @@ func noise2(x, y float32, octaves int, persistence, lacunarity float32) float32
 }
 
 func noise3(x, y, z float32, octaves int, persistence, lacunarity float32) float32 {
+	return simplex3(sim, x, y, z, octaves, persistence, lacunarity)
+}
+
+func simplex3(sim *opensimplex.Noise, x, y, z float32, octaves int, persistence, lacunarity float32) float32 {
 	var (
 		freq  float32 = 1
 		amp   float32 = 1
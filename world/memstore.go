@@ -0,0 +1,294 @@
+package world
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// MemStore 保存在内存中的 Store, 用于测试和不需要保存的服务器.
+// Backup 写入 json, 用 OpenMemSnapshot 打开
+type MemStore struct {
+	data *memData
+	dim  string
+}
+
+// memData 所有维度共用的数据
+type memData struct {
+	mutex   sync.RWMutex
+	dims    map[string]*memDim
+	players map[string][]byte
+}
+
+type memDim struct {
+	chunks   map[Vec3]map[Vec3]*Block
+	versions map[Vec3]string
+	state    map[string][]byte
+}
+
+func newMemDim() *memDim {
+	return &memDim{
+		chunks:   make(map[Vec3]map[Vec3]*Block),
+		versions: make(map[Vec3]string),
+		state:    make(map[string][]byte),
+	}
+}
+
+func NewMemStore() *MemStore {
+	data := &memData{
+		dims:    map[string]*memDim{DefaultDimension: newMemDim()},
+		players: make(map[string][]byte),
+	}
+	return &MemStore{data: data, dim: DefaultDimension}
+}
+
+// d 返回维度的数据, 调用时需要持有锁
+func (s *MemStore) d() *memDim {
+	return s.data.dims[s.dim]
+}
+
+func (s *MemStore) UpdateBlock(id Vec3, w *Block) error {
+	return s.UpdateBlocks(map[Vec3]*Block{id: w})
+}
+
+func (s *MemStore) UpdateBlocks(blocks map[Vec3]*Block) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+	d := s.d()
+	for id, w := range blocks {
+		cid := id.Chunkid()
+		if d.chunks[cid] == nil {
+			d.chunks[cid] = make(map[Vec3]*Block)
+		}
+		d.chunks[cid][id] = copyBlock(w)
+	}
+	return nil
+}
+
+func (s *MemStore) DeleteBlocks(ids []Vec3) error {
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+	d := s.d()
+	for _, id := range ids {
+		cid := id.Chunkid()
+		delete(d.chunks[cid], id)
+		if len(d.chunks[cid]) == 0 {
+			delete(d.chunks, cid)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) UpdatePlayer(r *PlayerRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.data.mutex.Lock()
+	s.data.players[r.Name] = b
+	s.data.mutex.Unlock()
+	return nil
+}
+
+func (s *MemStore) GetPlayer(name string) (*PlayerRecord, error) {
+	s.data.mutex.RLock()
+	b := s.data.players[name]
+	s.data.mutex.RUnlock()
+	if b == nil {
+		return nil, nil
+	}
+	r := new(PlayerRecord)
+	err := json.Unmarshal(b, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *MemStore) RangeBlocks(cid Vec3, f func(bid Vec3, w *Block)) error {
+	s.data.mutex.RLock()
+	blocks := make(map[Vec3]*Block, len(s.d().chunks[cid]))
+	for id, w := range s.d().chunks[cid] {
+		blocks[id] = copyBlock(w)
+	}
+	s.data.mutex.RUnlock()
+	for id, w := range blocks {
+		f(id, w)
+	}
+	return nil
+}
+
+// ChunkData 和 BoltStore 一样返回 EncodeChunk 的结果, 没有保存过的 chunk 返回 nil
+func (s *MemStore) ChunkData(cid Vec3) ([]byte, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+	blocks, ok := s.d().chunks[cid]
+	if !ok {
+		return nil, nil
+	}
+	return EncodeChunk(cid, blocks, *chunkCompress)
+}
+
+func (s *MemStore) UpdateChunkVersion(id Vec3, version string) error {
+	s.data.mutex.Lock()
+	s.d().versions[id] = version
+	s.data.mutex.Unlock()
+	return nil
+}
+
+func (s *MemStore) GetChunkVersion(id Vec3) string {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+	return s.d().versions[id]
+}
+
+// UpdateState 和 BoltStore 一样以 json 保存, 读取时得到的是副本
+func (s *MemStore) UpdateState(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.data.mutex.Lock()
+	s.d().state[key] = b
+	s.data.mutex.Unlock()
+	return nil
+}
+
+func (s *MemStore) GetState(key string, v interface{}) error {
+	s.data.mutex.RLock()
+	b, ok := s.d().state[key]
+	s.data.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (s *MemStore) Chunks() ([]Vec3, error) {
+	s.data.mutex.RLock()
+	defer s.data.mutex.RUnlock()
+	var cids []Vec3
+	for cid := range s.d().chunks {
+		cids = append(cids, cid)
+	}
+	return cids, nil
+}
+
+func (s *MemStore) Dimension(name string) (Store, error) {
+	if name == "" {
+		name = DefaultDimension
+	}
+	s.data.mutex.Lock()
+	defer s.data.mutex.Unlock()
+	if s.data.dims[name] == nil {
+		s.data.dims[name] = newMemDim()
+	}
+	return &MemStore{data: s.data, dim: name}, nil
+}
+
+// memSnapshot Backup 写入的格式, chunk 使用 EncodeChunk 编码
+type memSnapshot struct {
+	Players map[string]json.RawMessage `json:"players,omitempty"`
+	Dims    map[string]memDimSnapshot  `json:"dims"`
+}
+
+type memDimSnapshot struct {
+	Chunks []memChunkSnapshot         `json:"chunks"`
+	State  map[string]json.RawMessage `json:"state,omitempty"`
+}
+
+type memChunkSnapshot struct {
+	ID      Vec3   `json:"id"`
+	Data    []byte `json:"data"`
+	Version string `json:"version,omitempty"`
+}
+
+// Backup 写入所有维度的数据
+func (s *MemStore) Backup(w io.Writer) (int64, error) {
+	s.data.mutex.RLock()
+	snap := memSnapshot{
+		Players: make(map[string]json.RawMessage),
+		Dims:    make(map[string]memDimSnapshot),
+	}
+	for name, b := range s.data.players {
+		snap.Players[name] = b
+	}
+	for name, d := range s.data.dims {
+		ds := memDimSnapshot{State: make(map[string]json.RawMessage)}
+		for cid, blocks := range d.chunks {
+			data, err := EncodeChunk(cid, blocks, *chunkCompress)
+			if err != nil {
+				s.data.mutex.RUnlock()
+				return 0, err
+			}
+			ds.Chunks = append(ds.Chunks, memChunkSnapshot{ID: cid, Data: data})
+		}
+		// 没有方块的 chunk 也可能有版本
+		for cid, v := range d.versions {
+			ds.Chunks = append(ds.Chunks, memChunkSnapshot{ID: cid, Version: v})
+		}
+		for k, v := range d.state {
+			ds.State[k] = v
+		}
+		snap.Dims[name] = ds
+	}
+	s.data.mutex.RUnlock()
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// OpenMemSnapshot 读取 MemStore.Backup 写入的文件
+func OpenMemSnapshot(p string) (Store, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var snap memSnapshot
+	err = json.Unmarshal(b, &snap)
+	if err != nil {
+		return nil, err
+	}
+	s := NewMemStore()
+	for name, b := range snap.Players {
+		s.data.players[name] = b
+	}
+	for name, ds := range snap.Dims {
+		d := newMemDim()
+		for _, c := range ds.Chunks {
+			if c.Version != "" {
+				d.versions[c.ID] = c.Version
+			}
+			if c.Data == nil {
+				continue
+			}
+			blocks, err := DecodeChunk(c.ID, c.Data)
+			if err != nil {
+				return nil, err
+			}
+			d.chunks[c.ID] = blocks
+		}
+		for k, v := range ds.State {
+			d.state[k] = v
+		}
+		s.data.dims[name] = d
+	}
+	return s, nil
+}
+
+// Close 内存中的数据在没有引用后释放
+func (s *MemStore) Close() {
+}
+
+func init() {
+	RegisterStore("mem", &StoreBackend{
+		Open: func(p string) (Store, error) {
+			return NewMemStore(), nil
+		},
+		OpenSnapshot: OpenMemSnapshot,
+	})
+}
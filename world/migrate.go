@@ -0,0 +1,234 @@
+package world
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	migrateDryRun = flag.Bool("migrate-dry-run", false, "run pending db migrations, report and roll them back")
+	migrateBackup = flag.Bool("migrate-backup", true, "copy the db to -backup-dir before migrating it")
+
+	metaBucket = []byte("meta")
+	schemaKey  = []byte("schema")
+
+	ErrMigrateDryRun = errors.New("migration dry run, db not changed")
+)
+
+// Migration 把数据库从 Version-1 升级到 Version, 在一个事务中执行.
+// 没有记录版本的数据库视为版本 0, 所以迁移需要能在已经是新格式的数据上执行
+type Migration struct {
+	Version int
+	Name    string
+	Migrate func(tx *bolt.Tx) error
+}
+
+type MigrateOptions struct {
+	// DryRun 执行迁移后回滚, 返回 ErrMigrateDryRun
+	DryRun bool
+	// BackupDir 不为空时迁移前把数据库复制到这个目录
+	BackupDir string
+}
+
+var (
+	migrationsMutex sync.Mutex
+	migrations      []*Migration
+)
+
+// RegisterMigration 注册迁移, 版本号必须连续
+func RegisterMigration(m *Migration) {
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+// SchemaVersion 当前代码使用的数据库版本
+func SchemaVersion() int {
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func pendingMigrations(version int) ([]*Migration, error) {
+	migrationsMutex.Lock()
+	defer migrationsMutex.Unlock()
+	var ms []*Migration
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s: version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Version > version {
+			ms = append(ms, m)
+		}
+	}
+	return ms, nil
+}
+
+func readSchemaVersion(tx *bolt.Tx) (int, error) {
+	bkt := tx.Bucket(metaBucket)
+	if bkt == nil {
+		return 0, nil
+	}
+	value := bkt.Get(schemaKey)
+	if value == nil {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, fmt.Errorf("bad schema version %q", value)
+	}
+	return v, nil
+}
+
+func writeSchemaVersion(tx *bolt.Tx, v int) error {
+	bkt, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(schemaKey, []byte(strconv.Itoa(v)))
+}
+
+// migrate 检查数据库版本并执行没有执行过的迁移
+func migrate(db *bolt.DB, path string, opts MigrateOptions) error {
+	var version int
+	empty := true
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = readSchemaVersion(tx)
+		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			empty = false
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	current := SchemaVersion()
+	if empty && !opts.DryRun {
+		// 新的数据库不需要迁移
+		return db.Update(func(tx *bolt.Tx) error {
+			return writeSchemaVersion(tx, current)
+		})
+	}
+	if version > current {
+		return fmt.Errorf("db schema version %d is newer than %d supported by this build", version, current)
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if opts.BackupDir != "" && !opts.DryRun {
+		name, err := backupBeforeMigrate(db, path, opts.BackupDir, version)
+		if err != nil {
+			return fmt.Errorf("backup before migration: %s", err)
+		}
+		log.Printf("db backed up to %s before migration", name)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, m := range pending {
+			log.Printf("migrate db to version %d: %s", m.Version, m.Name)
+			err := m.Migrate(tx)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %s", m.Version, m.Name, err)
+			}
+		}
+		err := writeSchemaVersion(tx, current)
+		if err != nil {
+			return err
+		}
+		if opts.DryRun {
+			return ErrMigrateDryRun
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("db migrated from version %d to %d", version, current)
+	return nil
+}
+
+func backupBeforeMigrate(db *bolt.DB, path, dir string, version int) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	// 不用 Backups 的命名, 不会被轮换删除
+	name := filepath.Join(dir, fmt.Sprintf("%s-v%d-premigrate-%s.bak", base, version, time.Now().Format(backupTimeFormat)))
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(name, 0644)
+	})
+	return name, err
+}
+
+// migrateBlockKeys 把旧格式每个方块一个 key 的数据转换为每个 chunk 一个二进制值
+func migrateBlockKeys(tx *bolt.Tx) error {
+	old := tx.Bucket(blockBucket)
+	if old == nil {
+		return nil
+	}
+	chunks := make(map[Vec3]map[Vec3]*Block)
+	n, bad := 0, 0
+	err := old.ForEach(func(k, v []byte) error {
+		cid, bid, err := decodeBlockDbKey(k)
+		if err != nil {
+			log.Printf("skip block: %s", err)
+			bad++
+			return nil
+		}
+		w, err := decodeBlockDbValue(v)
+		if err != nil || w == nil {
+			log.Printf("skip block %v: bad value %q", bid, v)
+			bad++
+			return nil
+		}
+		if chunks[cid] == nil {
+			chunks[cid] = make(map[Vec3]*Block)
+		}
+		chunks[cid][bid] = w
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	bkt, err := tx.CreateBucketIfNotExists(chunkDataBucket)
+	if err != nil {
+		return err
+	}
+	for cid, blocks := range chunks {
+		err := putChunkData(bkt, cid, blocks)
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("migrated %d blocks in %d chunks to chunk data, %d bad blocks skipped", n, len(chunks), bad)
+	return tx.DeleteBucket(blockBucket)
+}
+
+func init() {
+	RegisterMigration(&Migration{Version: 1, Name: "per-block keys to chunk data", Migrate: migrateBlockKeys})
+}
@@ -0,0 +1,106 @@
+package world
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "old.db")
+	backups := filepath.Join(dir, "backups")
+
+	version := func() int {
+		db, err := bolt.Open(path, 0666, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var v int
+		err = db.View(func(tx *bolt.Tx) error {
+			v, err = readSchemaVersion(tx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// 没有版本的旧数据库
+	db, err := bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket(blockBucket)
+		if err != nil {
+			return err
+		}
+		err = bkt.Put(encodeBlockDbKey(Vec3{}, Vec3{1, 2, 3}), encodeBlockDbValue(NewBlock(4)))
+		if err != nil {
+			return err
+		}
+		bkt, err = tx.CreateBucket(cameraBucket)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(cameraBucket, []byte(`{"Vec3":[1,30,2],"Rx":45,"Ry":-10,"T":3.5,"ID":0,"Sens":0.14}`))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenBoltStore(path, MigrateOptions{DryRun: true, BackupDir: backups})
+	if err != ErrMigrateDryRun {
+		t.Fatalf("dry run: %v", err)
+	}
+	if v := version(); v != 0 {
+		t.Fatalf("version after dry run %d", v)
+	}
+
+	s, err := OpenBoltStore(path, MigrateOptions{BackupDir: backups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.GetPlayer(DefaultPlayerName)
+	if err != nil || r == nil || r.Pos != (mgl32.Vec3{1, 30, 2}) || r.Rx != 45 || r.Health != MaxHealth {
+		t.Fatalf("migrated player %v %v", r, err)
+	}
+	s.Close()
+	if v := version(); v != SchemaVersion() {
+		t.Fatalf("version %d, want %d", v, SchemaVersion())
+	}
+	files, _ := filepath.Glob(filepath.Join(backups, "old-v0-premigrate-*.bak"))
+	if len(files) != 1 {
+		t.Fatalf("backups %v", files)
+	}
+
+	// 新版本写入的数据库
+	db, err = bolt.Open(path, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return writeSchemaVersion(tx, SchemaVersion()+1)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewBoltStore(path)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("future version: %v", err)
+	}
+}
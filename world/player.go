@@ -1,12 +1,21 @@
 package world
 
 import (
-	"github.com/go-gl/glfw/v3.3/glfw"
+	"sync"
+	"time"
+
 	"github.com/go-gl/mathgl/mgl32"
 )
 
 type Movement int
 
+var startTime = time.Now()
+
+// now 玩家位置的时间, 启动后的秒数. 不使用 glfw, 服务器中也可以使用
+func now() float64 {
+	return time.Since(startTime).Seconds()
+}
+
 var PlayerID = int32(1)
 
 const (
@@ -44,11 +53,19 @@ func (p *Position) Front() mgl32.Vec3 {
 type Player struct {
 	Position
 	ID      int
+	Name    string
 	Sens    float32
 	pre     Position
 	flying  bool
 	ai      AI
 	Physics Physics
+
+	Health    int
+	Inventory Inventory
+	respawn   *RespawnPoint // /setspawn me 设置的出生点, 用 Respawn 和 SetRespawn 访问
+
+	// mutex 服务器中玩家的位置由连接的 goroutine 更新, 同时被保存和发送给其他玩家
+	mutex sync.Mutex
 }
 
 func (c *Player) Update(dt float64) {
@@ -67,6 +84,8 @@ func (c *Player) Foot() Vec3 {
 }
 
 func (c *Player) State() Position {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.Position
 }
 
@@ -75,7 +94,7 @@ func (c *Player) Move(dir Movement, delta float32) {
 		delta = 5 * delta
 	}
 	c.pre = c.Position
-	c.Position.T = glfw.GetTime()
+	c.Position.T = now()
 	switch dir {
 	case MoveForward:
 		if c.flying {
@@ -94,7 +113,7 @@ func (c *Player) Move(dir Movement, delta float32) {
 	case MoveRight:
 		c.Position.Vec3 = c.Position.Add(c.Right().Mul(delta))
 	}
-	c.Position.T = glfw.GetTime()
+	c.Position.T = now()
 	c.UpdateState(c.Position)
 }
 
@@ -103,7 +122,7 @@ func (c *Player) ChangeAngle(dx, dy float32) {
 		return
 	}
 	c.pre = c.Position
-	c.Position.T = glfw.GetTime()
+	c.Position.T = now()
 	c.Position.Rx += dx * c.Sens
 	c.Position.Ry += dy * c.Sens
 	if c.Position.Ry > 89 {
@@ -121,9 +140,10 @@ func NewPlayer(pos mgl32.Vec3, ai AI, phy Physics) *Player {
 		Physics: phy,
 		Sens:    0.14,
 		flying:  false,
+		Health:  MaxHealth,
 	}
 	//r.players[id] = p
-	p.Position = Position{Vec3: pos, T: glfw.GetTime(), Rx: -90, Ry: 0}
+	p.Position = Position{Vec3: pos, T: now(), Rx: -90, Ry: 0}
 	p.pre = p.Position
 	return p
 }
@@ -145,7 +165,7 @@ func (p *Player) ComputeFootMat() mgl32.Mat4 {
 // 线性插值计算玩家位置
 func (p *Player) ComputeMat() mgl32.Mat4 {
 	t1 := p.Position.T - p.pre.T
-	t2 := glfw.GetTime() - p.Position.T
+	t2 := now() - p.Position.T
 	t := min(float32(t2/t1), 1)
 
 	x := mix(p.Position.X(), p.pre.X(), t)
@@ -166,6 +186,8 @@ func (p *Player) ComputeMat() mgl32.Mat4 {
 }
 
 func (p *Player) UpdateState(s Position) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	p.pre, p.Position = p.Position, s
 }
 
@@ -174,9 +196,11 @@ func (c *Player) Restore(state Position) {
 }
 
 func (c *Player) SetPos(pos mgl32.Vec3) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.pre = c.Position
 	c.Position.Vec3 = pos
-	c.Position.T = glfw.GetTime()
+	c.Position.T = now()
 }
 
 func (c *Player) Pos() mgl32.Vec3 {
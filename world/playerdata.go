@@ -0,0 +1,182 @@
+package world
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/boltdb/bolt"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	MaxHealth = 20
+	// DefaultPlayerName 单机游戏没有指定名字时使用, 旧数据库中的玩家迁移到这个名字
+	DefaultPlayerName = "player"
+)
+
+// Inventory 玩家的物品
+type Inventory struct {
+	// Selected 手中的方块, 游戏方块列表中的下标
+	Selected int `json:"selected"`
+}
+
+// PlayerRecord 保存在 store 中的玩家状态, 按名字保存, 所有维度共用
+type PlayerRecord struct {
+	Name      string     `json:"name"`
+	Dimension string     `json:"dimension"`
+	Pos       mgl32.Vec3 `json:"pos"`
+	Rx        float32    `json:"rx"`
+	Ry        float32    `json:"ry"`
+	Flying    bool       `json:"flying"`
+	Health    int        `json:"health"`
+	Inventory Inventory  `json:"inventory"`
+	// Respawn /setspawn me 设置的出生点
+	Respawn *RespawnPoint `json:"respawn,omitempty"`
+}
+
+// Record 玩家在维度 dim 中的状态
+func (c *Player) Record(dim string) *PlayerRecord {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return &PlayerRecord{
+		Name:      c.Name,
+		Dimension: dim,
+		Pos:       c.Position.Vec3,
+		Rx:        c.Position.Rx,
+		Ry:        c.Position.Ry,
+		Flying:    c.flying,
+		Health:    c.Health,
+		Inventory: c.Inventory,
+		Respawn:   c.respawn,
+	}
+}
+
+// Respawn 玩家自己的出生点, 没有设置时为 nil
+func (c *Player) Respawn() *RespawnPoint {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.respawn
+}
+
+// SetRespawn 设置玩家自己的出生点
+func (c *Player) SetRespawn(r *RespawnPoint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.respawn = r
+}
+
+// Apply 恢复保存的朝向, 飞行, 生命值和物品, 位置由调用的人决定
+func (c *Player) Apply(r *PlayerRecord) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Position.Rx, c.Position.Ry = r.Rx, r.Ry
+	c.pre.Rx, c.pre.Ry = r.Rx, r.Ry
+	c.flying = r.Flying
+	c.Health = r.Health
+	if c.Health <= 0 || c.Health > MaxHealth {
+		c.Health = MaxHealth
+	}
+	c.Inventory = r.Inventory
+	c.respawn = r.Respawn
+}
+
+// LoadPlayer 读取保存的玩家, 没有保存过时返回 nil
+func (w *World) LoadPlayer(name string) (*PlayerRecord, error) {
+	if w.store == nil {
+		return nil, nil
+	}
+	return w.store.GetPlayer(name)
+}
+
+func (w *World) SavePlayer(p *Player) error {
+	if w.store == nil || p.Name == "" {
+		return nil
+	}
+	return w.store.UpdatePlayer(p.Record(w.dim.Name))
+}
+
+// Join 玩家进入这个维度, 恢复保存的状态. 没有保存过或者保存时在其他维度时放在重生点.
+// 加入的玩家在 Save 时一起保存
+func (w *World) Join(p *Player) error {
+	r, err := w.LoadPlayer(p.Name)
+	if err != nil {
+		p.SetPos(w.RespawnPos(p))
+		w.players.Store(p.Name, p)
+		return err
+	}
+	if r != nil {
+		p.Apply(r)
+	}
+	if r != nil && r.Dimension == w.dim.Name {
+		p.SetPos(r.Pos)
+	} else {
+		p.SetPos(w.RespawnPos(p))
+	}
+	w.players.Store(p.Name, p)
+	return nil
+}
+
+// Leave 保存玩家, 不再随世界一起保存
+func (w *World) Leave(p *Player) error {
+	w.players.Delete(p.Name)
+	return w.SavePlayer(p)
+}
+
+// savePlayers 保存所有加入的玩家
+func (w *World) savePlayers() {
+	w.players.Range(func(key, value interface{}) bool {
+		p := value.(*Player)
+		err := w.SavePlayer(p)
+		if err != nil {
+			log.Printf("save player %s error:%s", p.Name, err)
+		}
+		return true
+	})
+}
+
+// legacyCamera 旧版本在 camera bucket 中用固定 key 保存的玩家
+type legacyCamera struct {
+	Vec3   mgl32.Vec3
+	Rx, Ry float32
+}
+
+// migratePlayers 把旧的 camera 转换为 DefaultPlayerName 的 PlayerRecord
+func migratePlayers(tx *bolt.Tx) error {
+	bkt, err := tx.CreateBucketIfNotExists(playerBucket)
+	if err != nil {
+		return err
+	}
+	old := tx.Bucket(cameraBucket)
+	if old == nil {
+		return nil
+	}
+	if v := old.Get(cameraBucket); v != nil && bkt.Get([]byte(DefaultPlayerName)) == nil {
+		var c legacyCamera
+		if err := json.Unmarshal(v, &c); err != nil {
+			log.Printf("skip camera: %s", err)
+		} else {
+			r := &PlayerRecord{
+				Name:      DefaultPlayerName,
+				Dimension: DefaultDimension,
+				Pos:       c.Vec3,
+				Rx:        c.Rx,
+				Ry:        c.Ry,
+				Health:    MaxHealth,
+			}
+			b, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			err = bkt.Put([]byte(DefaultPlayerName), b)
+			if err != nil {
+				return err
+			}
+			log.Printf("migrated camera to player %s", DefaultPlayerName)
+		}
+	}
+	return tx.DeleteBucket(cameraBucket)
+}
+
+func init() {
+	RegisterMigration(&Migration{Version: 2, Name: "camera to per-name players", Migrate: migratePlayers})
+}
@@ -0,0 +1,116 @@
+package world
+
+// ChunkSnapshot chunk 在一个版本的只读副本, 包括四周相邻 chunk 边上的一层方块.
+// 不会再被修改, 可以在生成网格, 网络传输等任何 goroutine 中使用
+type ChunkSnapshot struct {
+	ID         Vec3
+	Version    int64
+	MinY, MaxY int
+
+	blocks map[Vec3]*Block
+	// border 相邻 chunk 中和这个 chunk 相邻的方块, 相邻的 chunk 没有加载时没有
+	border map[Vec3]*Block
+}
+
+// neighbours 左右前后相邻的 chunk
+func (c *Chunk) neighbours() [4]Vec3 {
+	id := c.id
+	return [4]Vec3{
+		{X: id.X - 1, Z: id.Z},
+		{X: id.X + 1, Z: id.Z},
+		{X: id.X, Z: id.Z - 1},
+		{X: id.X, Z: id.Z + 1},
+	}
+}
+
+// Snapshot 返回 chunk 当前的副本, 这个 chunk 和相邻的 chunk 都没有修改时返回同一个副本
+func (c *Chunk) Snapshot() *ChunkSnapshot {
+	var key [5]int64
+	var near [4]*Chunk
+	key[0] = c.V()
+	for i, id := range c.neighbours() {
+		key[i+1] = -1
+		if c.world == nil {
+			continue
+		}
+		if n, ok := c.world.loadChunk(id); ok {
+			near[i] = n
+			key[i+1] = n.V()
+		}
+	}
+
+	c.snapMutex.Lock()
+	defer c.snapMutex.Unlock()
+	if c.snap != nil && c.snapKey == key {
+		return c.snap
+	}
+
+	s := &ChunkSnapshot{ID: c.id, blocks: make(map[Vec3]*Block), border: make(map[Vec3]*Block)}
+	c.lock.RLock()
+	s.Version, s.MinY, s.MaxY = c.V(), c.minY, c.maxY
+	c.blocks.Range(func(key, value interface{}) bool {
+		s.blocks[key.(Vec3)] = copyBlock(value.(*Block))
+		return true
+	})
+	c.lock.RUnlock()
+	key[0] = s.Version
+
+	x0, z0 := c.id.X*ChunkWidth, c.id.Z*ChunkWidth
+	for i, n := range near {
+		if n == nil {
+			continue
+		}
+		n.lock.RLock()
+		for j := 0; j < ChunkWidth; j++ {
+			var x, z int
+			switch i {
+			case 0:
+				x, z = x0-1, z0+j
+			case 1:
+				x, z = x0+ChunkWidth, z0+j
+			case 2:
+				x, z = x0+j, z0-1
+			case 3:
+				x, z = x0+j, z0+ChunkWidth
+			}
+			for y := s.MinY; y <= s.MaxY; y++ {
+				id := Vec3{x, y, z}
+				if b := n.Block(id); b != nil {
+					s.border[id] = copyBlock(b)
+				}
+			}
+		}
+		n.lock.RUnlock()
+	}
+	c.snap, c.snapKey = s, key
+	return s
+}
+
+// Block 和 Chunk.Block 一样, 也可以读取边上相邻的方块, 其他位置返回 nil
+func (s *ChunkSnapshot) Block(id Vec3) *Block {
+	if id.Chunkid() != s.ID {
+		return s.border[id]
+	}
+	if b, ok := s.blocks[id]; ok {
+		return b
+	}
+	if id.Y >= 12 {
+		return NewBlock(TypeAir)
+	}
+	return nil
+}
+
+func (s *ChunkSnapshot) RangeBlocks(f func(id Vec3, w *Block)) {
+	for id, b := range s.blocks {
+		f(id, b)
+	}
+}
+
+func (s *ChunkSnapshot) Len() int {
+	return len(s.blocks)
+}
+
+// Encode 用 EncodeChunk 编码, 用于网络传输
+func (s *ChunkSnapshot) Encode(compress bool) ([]byte, error) {
+	return EncodeChunk(s.ID, s.blocks, compress)
+}
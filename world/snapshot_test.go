@@ -0,0 +1,85 @@
+package world
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChunkSnapshot(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	cid := Vec3{0, 0, 0}
+	c := w.Chunk(cid)
+	w.Chunk(Vec3{1, 0, 0})
+	// 边上只保存和 chunk 中的方块同样高度的
+	edge := Vec3{ChunkWidth, 30, 5}
+	w.UpdateBlock(edge.Left(), NewBlock(4))
+	w.UpdateBlock(edge, NewBlock(4))
+
+	s := c.Snapshot()
+	if s.Version != c.V() {
+		t.Fatalf("version %d, chunk %d", s.Version, c.V())
+	}
+	if c.Snapshot() != s {
+		t.Error("unchanged chunk made a new snapshot")
+	}
+	if b := s.Block(edge); b == nil || b.Type != 4 {
+		t.Fatalf("border block %v", b)
+	}
+	// 修改相邻的 chunk 后有新的副本, 旧的副本不变
+	w.UpdateBlock(edge, NewBlock(5))
+	s2 := c.Snapshot()
+	if s2 == s || s2.Block(edge).Type != 5 || s.Block(edge).Type != 4 {
+		t.Fatalf("snapshot after neighbour edit: new %v old %v", s2.Block(edge), s.Block(edge))
+	}
+	id := Vec3{3, 30, 3}
+	w.UpdateBlock(id, NewBlock(6))
+	if s.Block(id).Type != TypeAir || c.Snapshot().Block(id).Type != 6 {
+		t.Fatal("snapshot changed by edit")
+	}
+}
+
+// TestChunkSnapshotRace 用 go test -race 运行, 同时修改和生成网格
+func TestChunkSnapshotRace(t *testing.T) {
+	defer openTestStore(t)()
+	w := NewWorld(2)
+	cid := Vec3{0, 0, 0}
+	c := w.Chunk(cid)
+	w.Chunk(Vec3{-1, 0, 0})
+
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				w.UpdateBlock(Vec3{n%ChunkWidth - i, 20 + n%10, n % 7}, NewBlock(4+n%3))
+			}
+		}(i)
+	}
+	for i := 0; i < 2; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// 和生成网格一样读取所有方块和它们的邻居
+				s := c.Snapshot()
+				s.RangeBlocks(func(id Vec3, b *Block) {
+					for _, n := range []Vec3{id.Left(), id.Right(), id.Front(), id.Back(), id.Up(), id.Down()} {
+						s.Block(n).IsTransparent()
+					}
+					_ = b.Type + b.Life
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	if s := c.Snapshot(); s.Version != c.V() {
+		t.Fatalf("snapshot version %d, chunk %d", s.Version, c.V())
+	}
+}
@@ -0,0 +1,196 @@
+package world
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+var (
+	spawnSearch     = flag.Int("spawn-search", 32, "blocks around the dimension spawn searched for a safe place to stand")
+	spawnProtection = flag.Int("spawn-protection", 0, "blocks around the world spawn players can't change, 0 disables")
+
+	ErrNotSafe = errors.New("no safe place to stand")
+)
+
+const spawnKey = "spawn"
+
+// RespawnPoint 玩家自己的出生点
+type RespawnPoint struct {
+	Dimension string `json:"dimension"`
+	Pos       Vec3   `json:"pos"`
+}
+
+// safeGround 可以站在上面的方块, 不包括云和树
+func safeGround(b *Block) bool {
+	return b != nil && b.IsObstacle() && b.Type != typeCloud && b.Type != typeWood
+}
+
+// safeSpace 可以站在里面的方块
+func safeSpace(b *Block) bool {
+	return b == nil || !b.IsObstacle() && b.Type != typeLeaves
+}
+
+// standable id 为脚的位置, 下面是地面, 脚和头都是空的
+func standable(block func(id Vec3) *Block, id Vec3) bool {
+	return safeGround(block(id.Down())) && safeSpace(block(id)) && safeSpace(block(id.Up()))
+}
+
+// Standable 玩家能不能安全地站在 id
+func (w *World) Standable(id Vec3) bool {
+	return standable(w.Block, id)
+}
+
+// FindSpawn 从 center 所在的列开始一圈圈向外找最高的可以站立的位置, 最远 radius 个方块
+func (w *World) FindSpawn(center Vec3, radius int) (Vec3, bool) {
+	snaps := make(map[Vec3]*ChunkSnapshot)
+	snap := func(cid Vec3) *ChunkSnapshot {
+		s, ok := snaps[cid]
+		if !ok {
+			s = w.Chunk(cid).Snapshot()
+			snaps[cid] = s
+		}
+		return s
+	}
+	block := func(id Vec3) *Block {
+		return snap(id.Chunkid()).Block(id)
+	}
+	column := func(x, z int) (Vec3, bool) {
+		s := snap(Vec3{X: x, Z: z}.Chunkid())
+		for y := s.MaxY + 1; y > s.MinY; y-- {
+			id := Vec3{X: x, Y: y, Z: z}
+			if standable(block, id) {
+				return id, true
+			}
+		}
+		return Vec3{}, false
+	}
+	for r := 0; r <= radius; r++ {
+		for dx := -r; dx <= r; dx++ {
+			for dz := -r; dz <= r; dz++ {
+				if dx != -r && dx != r && dz != -r && dz != r {
+					continue
+				}
+				if id, ok := column(center.X+dx, center.Z+dz); ok {
+					return id, true
+				}
+			}
+		}
+	}
+	return Vec3{}, false
+}
+
+// SpawnBlock 世界的出生点, 保存在数据库中. 第一次使用时在维度设置的出生点附近找安全的位置
+func (w *World) SpawnBlock() Vec3 {
+	w.spawnMutex.Lock()
+	defer w.spawnMutex.Unlock()
+	if w.spawn != nil {
+		return *w.spawn
+	}
+	var saved *Vec3
+	if w.store != nil {
+		err := w.store.GetState(spawnKey, &saved)
+		if err != nil {
+			log.Printf("load spawn error:%s", err)
+		}
+	}
+	if saved != nil {
+		w.spawn = saved
+		return *w.spawn
+	}
+	id, ok := w.FindSpawn(w.dim.Spawn, *spawnSearch)
+	if !ok {
+		// 没有地面的维度, 比如 void, 使用设置的位置, 不保存
+		log.Printf("no safe spawn in %s near %v", w.dim.Name, w.dim.Spawn)
+		id = w.dim.Spawn
+		w.spawn = &id
+		return id
+	}
+	w.spawn = &id
+	w.saveSpawn()
+	return id
+}
+
+// Spawn 玩家出生的位置
+func (w *World) Spawn() mgl32.Vec3 {
+	return blockPos(w.SpawnBlock())
+}
+
+// SetSpawn 修改世界的出生点
+func (w *World) SetSpawn(id Vec3) error {
+	if !w.Standable(id) {
+		return fmt.Errorf("%w at %v", ErrNotSafe, id)
+	}
+	w.spawnMutex.Lock()
+	defer w.spawnMutex.Unlock()
+	w.spawn = &id
+	return w.saveSpawn()
+}
+
+// saveSpawn 调用时需要持有 spawnMutex
+func (w *World) saveSpawn() error {
+	if w.store == nil {
+		return nil
+	}
+	err := w.store.UpdateState(spawnKey, w.spawn)
+	if err != nil {
+		log.Printf("save spawn error:%s", err)
+	}
+	return err
+}
+
+// Protected 出生点附近 -spawn-protection 个方块内的方块不能被玩家修改
+func (w *World) Protected(id Vec3) bool {
+	r := *spawnProtection
+	if r <= 0 {
+		return false
+	}
+	s := w.SpawnBlock()
+	dx, dz := id.X-s.X, id.Z-s.Z
+	return dx >= -r && dx <= r && dz >= -r && dz <= r
+}
+
+// RespawnPos 玩家在这个维度重生的位置, 玩家的出生点不在这个维度或者不再安全时使用世界的出生点
+func (w *World) RespawnPos(p *Player) mgl32.Vec3 {
+	if r := p.Respawn(); r != nil && r.Dimension == w.dim.Name {
+		if w.Standable(r.Pos) {
+			return blockPos(r.Pos)
+		}
+		log.Printf("respawn point of %s at %v is blocked", p.Name, r.Pos)
+	}
+	return w.Spawn()
+}
+
+func blockPos(id Vec3) mgl32.Vec3 {
+	return mgl32.Vec3{float32(id.X), float32(id.Y), float32(id.Z)}
+}
+
+func init() {
+	const usage = "/setspawn [me]"
+	RegisterCommand("setspawn", usage, func(ctx *CommandContext, args []string) (string, error) {
+		id := ctx.Player.Foot()
+		switch {
+		case len(args) == 0:
+			err := ctx.World.SetSpawn(id)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("world spawn set to %v", id), nil
+		case len(args) == 1 && args[0] == "me":
+			if !ctx.World.Standable(id) {
+				return "", fmt.Errorf("%w at %v", ErrNotSafe, id)
+			}
+			ctx.Player.SetRespawn(&RespawnPoint{Dimension: ctx.World.dim.Name, Pos: id})
+			return fmt.Sprintf("your spawn set to %v", id), nil
+		}
+		return "", UsageError(usage)
+	})
+	RegisterCommand("spawn", "/spawn", func(ctx *CommandContext, args []string) (string, error) {
+		pos := ctx.World.RespawnPos(ctx.Player)
+		ctx.Player.SetPos(pos)
+		return fmt.Sprintf("moved to %v", NearBlock(pos)), nil
+	})
+}
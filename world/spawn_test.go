@@ -0,0 +1,77 @@
+package world
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSpawn(t *testing.T) {
+	testBackends(t, testSpawn)
+}
+
+func testSpawn(t *testing.T) {
+	err := CreateDimension(&Dimension{Name: "flat", Generator: Generator{Type: GeneratorFlat, Height: 14}, Spawn: Vec3{0, 14, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := OpenWorld("flat", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 设置的出生点上有树
+	center := Vec3{0, 14, 0}
+	w.UpdateBlock(center, NewBlock(typeWood))
+	w.UpdateBlock(center.Up().Up(), NewBlock(typeLeaves))
+	w.UpdateBlock(center.Up().Up().Up(), NewBlock(typeWood))
+	if w.Standable(center) || w.Standable(center.Up()) {
+		t.Fatal("tree is standable")
+	}
+	s := w.SpawnBlock()
+	if !w.Standable(s) || s.Y != 14 || s == center {
+		t.Fatalf("spawn %v", s)
+	}
+	if d := s.X*s.X + s.Z*s.Z; d > 2 {
+		t.Fatalf("spawn %v far from %v", s, center)
+	}
+
+	if err := w.SetSpawn(Vec3{5, 20, 5}); !errors.Is(err, ErrNotSafe) {
+		t.Fatalf("spawn in the air: %v", err)
+	}
+	if err := w.SetSpawn(Vec3{5, 14, 5}); err != nil {
+		t.Fatal(err)
+	}
+	// 出生点保存在数据库中
+	closeWorlds()
+	w, _ = OpenWorld("flat", 2)
+	if s := w.SpawnBlock(); s != (Vec3{5, 14, 5}) {
+		t.Fatalf("reloaded spawn %v", s)
+	}
+
+	old := *spawnProtection
+	defer func() { *spawnProtection = old }()
+	*spawnProtection = 3
+	if !w.Protected(Vec3{8, 30, 2}) || w.Protected(Vec3{9, 14, 5}) {
+		t.Fatal("spawn protection")
+	}
+}
+
+func TestFindSpawnVoid(t *testing.T) {
+	testBackends(t, testFindSpawnVoid)
+}
+
+func testFindSpawnVoid(t *testing.T) {
+	err := CreateDimension(&Dimension{Name: "void", Generator: Generator{Type: GeneratorVoid}, Spawn: Vec3{0, 16, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := OpenWorld("void", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := w.FindSpawn(Vec3{0, 16, 0}, 4); ok {
+		t.Fatal("found spawn in void")
+	}
+	if s := w.SpawnBlock(); s != (Vec3{0, 16, 0}) {
+		t.Fatalf("void spawn %v", s)
+	}
+}
@@ -6,77 +6,133 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"time"
 
 	"github.com/boltdb/bolt"
-	"github.com/go-gl/mathgl/mgl32"
 )
 
 var (
-	dbpath = flag.String("db", "gocraft.db", "db file name")
+	dbpath        = flag.String("db", "gocraft.db", "db file name")
+	chunkCompress = flag.Bool("chunk-compress", true, "compress chunk data in db")
 )
 
 var (
-	blockBucket  = []byte("block")
-	chunkBucket  = []byte("chunk")
-	cameraBucket = []byte("camera")
+	// blockBucket 旧格式, 每个方块一个 key, 打开时迁移到 chunkDataBucket
+	blockBucket     = []byte("block")
+	chunkBucket     = []byte("chunk")
+	chunkDataBucket = []byte("chunkdata")
+	cameraBucket    = []byte("camera") // 旧格式, 只有一个玩家, 打开时迁移到 playerBucket
+	playerBucket    = []byte("player")
+	stateBucket     = []byte("state")
 
 	store Store
 )
 
-func InitBoltStore() error {
-	var path string
-	if *dbpath != "" {
-		path = *dbpath
+// InitStore 打开 -store 选择的 store, 没有设置时使用 -db 的 bolt 数据库
+func InitStore() error {
+	url := *storeFlag
+	if url == "" {
+		if *dbpath == "" {
+			return errors.New("empty db path")
+		}
+		url = "bolt:" + *dbpath
+	}
+	s, err := OpenStore(url)
+	if err != nil {
+		return err
 	}
-	/*if *serverAddr != "" {
-		path = fmt.Sprintf("cache_%s.db", *serverAddr)
-	}*/
-	if path == "" {
-		return errors.New("empty db path")
+	store = s
+	storeURL = url
+	if *writeBehind {
+		store = NewWriteBehindStore(s, *writeDelay, *writeBatch)
 	}
-	var err error
-	store, err = NewBoltStore(path)
-	return err
+	return nil
 }
 
 func CloseStore() {
+	closeWorlds()
 	store.Close()
 }
 
 type Store interface {
 	UpdateBlock(id Vec3, w *Block) error
-	//UpdatePlayerState(state Position) error
-	UpdatePlayer(p *Player) error
-	GetPlayer() *Player
+	UpdateBlocks(blocks map[Vec3]*Block) error
+	DeleteBlocks(ids []Vec3) error
+	// UpdatePlayer 按名字保存玩家
+	UpdatePlayer(r *PlayerRecord) error
+	// GetPlayer 没有保存过的玩家返回 nil
+	GetPlayer(name string) (*PlayerRecord, error)
 	RangeBlocks(id Vec3, f func(bid Vec3, w *Block)) error
+	// ChunkData chunk 的二进制数据 (见 EncodeChunk), 用于网络传输
+	ChunkData(id Vec3) ([]byte, error)
 	UpdateChunkVersion(id Vec3, version string) error
 	GetChunkVersion(id Vec3) string
+	UpdateState(key string, v interface{}) error
+	GetState(key string, v interface{}) error
+	// Chunks 返回保存了方块的 chunk
+	Chunks() ([]Vec3, error)
+	// Dimension 返回同一个数据库中另一个维度的 store, 方块和状态分开保存, 玩家共用
+	Dimension(name string) (Store, error)
+	// Backup 把整个数据库一致地写入 w
+	Backup(w io.Writer) (int64, error)
 	Close()
 }
 
 type BoltStore struct {
 	db *bolt.DB
+	// prefix 维度的 bucket 名字前缀, 默认维度为空
+	prefix string
+	// shared Dimension 返回的 store 和 NewBoltStore 的共用数据库, 不能关闭
+	shared bool
+	// readOnly 备份打开时只读, 缺少的 bucket 视为空
+	readOnly bool
+}
+
+func init() {
+	RegisterStore("bolt", &StoreBackend{
+		Open: func(p string) (Store, error) {
+			if p == "" {
+				return nil, errors.New("empty db path")
+			}
+			opts := MigrateOptions{DryRun: *migrateDryRun}
+			if *migrateBackup {
+				opts.BackupDir = *backupDir
+			}
+			return OpenBoltStore(p, opts)
+		},
+		OpenSnapshot: OpenBoltSnapshot,
+	})
 }
 
 func NewBoltStore(p string) (Store, error) {
+	return OpenBoltStore(p, MigrateOptions{})
+}
+
+// OpenBoltStore 打开数据库, 按 opts 执行没有执行过的迁移
+func OpenBoltStore(p string, opts MigrateOptions) (Store, error) {
 	db, err := bolt.Open(p, 0666, nil)
 	if err != nil {
 		return nil, err
 	}
+	err = migrate(db, p, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(blockBucket)
-		if err != nil {
-			return err
-		}
-		_, err = tx.CreateBucketIfNotExists(chunkBucket)
-		if err != nil {
-			return err
+		for _, b := range [][]byte{chunkBucket, playerBucket, stateBucket, chunkDataBucket} {
+			_, err := tx.CreateBucketIfNotExists(b)
+			if err != nil {
+				return err
+			}
 		}
-		_, err = tx.CreateBucketIfNotExists(cameraBucket)
-		return err
+		return nil
 	})
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	db.NoSync = true
@@ -86,69 +142,154 @@ func NewBoltStore(p string) (Store, error) {
 }
 
 func (s *BoltStore) UpdateBlock(id Vec3, w *Block) error {
-	return s.db.Update(func(tx *bolt.Tx) error {
-		log.Printf("put %v -> %d", id, w)
-		bkt := tx.Bucket(blockBucket)
+	log.Printf("put %v -> %d", id, w)
+	return s.UpdateBlocks(map[Vec3]*Block{id: w})
+}
+
+// UpdateBlocks 在一个事务中写入多个方块, 每个 chunk 读写一次
+func (s *BoltStore) UpdateBlocks(blocks map[Vec3]*Block) error {
+	chunks := make(map[Vec3]map[Vec3]*Block)
+	for id, w := range blocks {
 		cid := id.Chunkid()
-		key := encodeBlockDbKey(cid, id)
-		value := encodeBlockDbValue(w)
-		return bkt.Put(key, value)
+		if chunks[cid] == nil {
+			chunks[cid] = make(map[Vec3]*Block)
+		}
+		chunks[cid][id] = w
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bkt := s.bucket(tx, chunkDataBucket)
+		for cid, changes := range chunks {
+			stored, err := getChunkData(bkt, cid)
+			if err != nil {
+				return err
+			}
+			for id, w := range changes {
+				stored[id] = w
+			}
+			err = putChunkData(bkt, cid, stored)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
-func (s *BoltStore) UpdatePlayer(p *Player) error {
+// DeleteBlocks 删除保存的方块, 没有方块的 chunk 整个删除
+func (s *BoltStore) DeleteBlocks(ids []Vec3) error {
+	chunks := make(map[Vec3][]Vec3)
+	for _, id := range ids {
+		cid := id.Chunkid()
+		chunks[cid] = append(chunks[cid], id)
+	}
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(cameraBucket)
-		b, err := json.Marshal(p)
-		if err != nil {
-			return err
+		bkt := s.bucket(tx, chunkDataBucket)
+		for cid, ids := range chunks {
+			stored, err := getChunkData(bkt, cid)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				delete(stored, id)
+			}
+			if len(stored) == 0 {
+				err = bkt.Delete(encodeVec3(cid))
+			} else {
+				err = putChunkData(bkt, cid, stored)
+			}
+			if err != nil {
+				return err
+			}
 		}
-		bkt.Put(cameraBucket, b)
 		return nil
 	})
 }
 
-func (s *BoltStore) GetPlayer() (player *Player) {
-	player = NewPlayer(mgl32.Vec3{0, 16, 0}, nil, nil)
-	//var state Position
-	//state.Vec3[1] = 16
-	s.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(cameraBucket)
-		value := bkt.Get(cameraBucket)
-		if value == nil {
+func getChunkData(bkt *bolt.Bucket, cid Vec3) (map[Vec3]*Block, error) {
+	var value []byte
+	if bkt != nil {
+		value = bkt.Get(encodeVec3(cid))
+	}
+	if value == nil {
+		return make(map[Vec3]*Block), nil
+	}
+	return DecodeChunk(cid, value)
+}
+
+func putChunkData(bkt *bolt.Bucket, cid Vec3, blocks map[Vec3]*Block) error {
+	value, err := EncodeChunk(cid, blocks, *chunkCompress)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(encodeVec3(cid), value)
+}
+
+// ChunkData 返回 chunk 的二进制数据, 没有保存过的 chunk 返回 nil
+func (s *BoltStore) ChunkData(cid Vec3) ([]byte, error) {
+	var data []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := s.bucket(tx, chunkDataBucket)
+		if bkt == nil {
 			return nil
 		}
-		err := json.Unmarshal(value, player)
-		if err != nil {
-			return err
+		value := bkt.Get(encodeVec3(cid))
+		if value != nil {
+			data = append([]byte(nil), value...)
 		}
 		return nil
 	})
-	return player
+	return data, err
 }
 
-func (s *BoltStore) RangeBlocks(id Vec3, f func(bid Vec3, w *Block)) error {
-	return s.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(blockBucket)
-		startkey := encodeBlockDbKey(id, Vec3{0, 0, 0})
-		iter := bkt.Cursor()
-		for k, v := iter.Seek(startkey); k != nil; k, v = iter.Next() {
-			cid, bid := decodeBlockDbKey(k)
-			if cid != id {
-				break
-			}
-			w := decodeBlockDbValue(v)
-			if w != nil {
-				f(bid, w)
-			}
+func (s *BoltStore) UpdatePlayer(r *PlayerRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(playerBucket).Put([]byte(r.Name), b)
+	})
+}
+
+func (s *BoltStore) GetPlayer(name string) (*PlayerRecord, error) {
+	var r *PlayerRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(playerBucket)
+		if bkt == nil {
+			return nil
 		}
-		return nil
+		value := bkt.Get([]byte(name))
+		if value == nil {
+			return nil
+		}
+		r = new(PlayerRecord)
+		return json.Unmarshal(value, r)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *BoltStore) RangeBlocks(id Vec3, f func(bid Vec3, w *Block)) error {
+	var blocks map[Vec3]*Block
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		blocks, err = getChunkData(s.bucket(tx, chunkDataBucket), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for bid, w := range blocks {
+		f(bid, w)
+	}
+	return nil
 }
 
 func (s *BoltStore) UpdateChunkVersion(id Vec3, version string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(chunkBucket)
+		bkt := s.bucket(tx, chunkBucket)
 		key := encodeVec3(id)
 		return bkt.Put(key, []byte(version))
 	})
@@ -157,7 +298,7 @@ func (s *BoltStore) UpdateChunkVersion(id Vec3, version string) error {
 func (s *BoltStore) GetChunkVersion(id Vec3) string {
 	var version string
 	s.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(chunkBucket)
+		bkt := s.bucket(tx, chunkBucket)
 		key := encodeVec3(id)
 		v := bkt.Get(key)
 		if v != nil {
@@ -168,7 +309,105 @@ func (s *BoltStore) GetChunkVersion(id Vec3) string {
 	return version
 }
 
+// UpdateState 保存世界状态(时间, 天气等), 以 json 编码
+func (s *BoltStore) UpdateState(key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bkt := s.bucket(tx, stateBucket)
+		return bkt.Put([]byte(key), b)
+	})
+}
+
+// GetState 读取世界状态, key 不存在时 v 保持不变
+func (s *BoltStore) GetState(key string, v interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bkt := s.bucket(tx, stateBucket)
+		if bkt == nil {
+			return nil
+		}
+		value := bkt.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, v)
+	})
+}
+
+// OpenBoltSnapshot 只读打开备份
+func OpenBoltSnapshot(p string) (Store, error) {
+	db, err := bolt.Open(p, 0444, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db, readOnly: true}, nil
+}
+
+func (s *BoltStore) Chunks() ([]Vec3, error) {
+	var cids []Vec3
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := s.bucket(tx, chunkDataBucket)
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(k, v []byte) error {
+			cid, err := decodeVec3(k)
+			if err != nil {
+				return err
+			}
+			cids = append(cids, cid)
+			return nil
+		})
+	})
+	return cids, err
+}
+
+// Backup 在一个读事务中复制数据库, 不影响其他读写
+func (s *BoltStore) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+func (s *BoltStore) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	return tx.Bucket(append([]byte(s.prefix), name...))
+}
+
+// Dimension 维度的 bucket 为 "dim/<name>/chunk" 等, 默认维度使用原来的 bucket
+func (s *BoltStore) Dimension(name string) (Store, error) {
+	if name == "" || name == DefaultDimension {
+		return &BoltStore{db: s.db, shared: true, readOnly: s.readOnly}, nil
+	}
+	ds := &BoltStore{db: s.db, prefix: "dim/" + name + "/", shared: true, readOnly: s.readOnly}
+	if s.readOnly {
+		return ds, nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{chunkBucket, chunkDataBucket, stateBucket} {
+			_, err := tx.CreateBucketIfNotExists([]byte(ds.prefix + string(b)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Close 关闭数据库, 维度的 store 共用数据库, 关闭时什么也不做
 func (s *BoltStore) Close() {
+	if s.shared {
+		return
+	}
 	s.db.Sync()
 	s.db.Close()
 }
@@ -179,6 +418,15 @@ func encodeVec3(v Vec3) []byte {
 	return buf.Bytes()
 }
 
+func decodeVec3(b []byte) (Vec3, error) {
+	if len(b) != 4*3 {
+		return Vec3{}, fmt.Errorf("bad vec3 length:%d", len(b))
+	}
+	var arr [3]int32
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, &arr)
+	return Vec3{int(arr[0]), int(arr[1]), int(arr[2])}, nil
+}
+
 func encodeBlockDbKey(cid, bid Vec3) []byte {
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, [...]int32{int32(cid.X), int32(cid.Z)})
@@ -186,9 +434,9 @@ func encodeBlockDbKey(cid, bid Vec3) []byte {
 	return buf.Bytes()
 }
 
-func decodeBlockDbKey(b []byte) (Vec3, Vec3) {
+func decodeBlockDbKey(b []byte) (Vec3, Vec3, error) {
 	if len(b) != 4*5 {
-		log.Panicf("bad db key length:%d", len(b))
+		return Vec3{}, Vec3{}, fmt.Errorf("bad db key length:%d", len(b))
 	}
 	buf := bytes.NewBuffer(b)
 	var arr [5]int32
@@ -197,9 +445,9 @@ func decodeBlockDbKey(b []byte) (Vec3, Vec3) {
 	cid := Vec3{int(arr[0]), 0, int(arr[1])}
 	bid := Vec3{int(arr[2]), int(arr[3]), int(arr[4])}
 	if bid.Chunkid() != cid {
-		log.Panicf("bad db key: cid:%v, bid:%v", cid, bid)
+		return Vec3{}, Vec3{}, fmt.Errorf("bad db key: cid:%v, bid:%v", cid, bid)
 	}
-	return cid, bid
+	return cid, bid, nil
 }
 
 func encodeBlockDbValue(w *Block) []byte {
@@ -207,7 +455,8 @@ func encodeBlockDbValue(w *Block) []byte {
 	return value
 }
 
-func decodeBlockDbValue(b []byte) (r *Block) {
-	json.Unmarshal(b, &r)
-	return r
+func decodeBlockDbValue(b []byte) (*Block, error) {
+	var r *Block
+	err := json.Unmarshal(b, &r)
+	return r, err
 }
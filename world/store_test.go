@@ -0,0 +1,216 @@
+package world
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// TestStoreBackends 所有注册的 Store 实现, 以及包装它们的 WriteBehindStore 都要通过 testStore
+func TestStoreBackends(t *testing.T) {
+	for _, name := range StoreBackends() {
+		for _, wb := range []bool{false, true} {
+			name, wb := name, wb
+			tname := name
+			if wb {
+				tname += "+writebehind"
+			}
+			t.Run(tname, func(t *testing.T) {
+				dir, err := ioutil.TempDir("", "store")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				url := name + ":" + filepath.Join(dir, "world.db")
+				s, err := OpenStore(url)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if wb {
+					s = NewWriteBehindStore(s, time.Hour, 1<<20)
+				}
+				defer s.Close()
+				testStore(t, s, url, dir)
+			})
+		}
+	}
+}
+
+func rangeAll(t *testing.T, s Store, cid Vec3) map[Vec3]*Block {
+	m := make(map[Vec3]*Block)
+	err := s.RangeBlocks(cid, func(id Vec3, b *Block) {
+		m[id] = b
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func sortedChunks(t *testing.T, s Store) []Vec3 {
+	cids, err := s.Chunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(cids, func(i, j int) bool {
+		return cids[i].X < cids[j].X || cids[i].X == cids[j].X && cids[i].Z < cids[j].Z
+	})
+	return cids
+}
+
+func testStore(t *testing.T, s Store, url, dir string) {
+	c0, c1 := Vec3{0, 0, 0}, Vec3{-1, 0, 2}
+	a, b, c := Vec3{1, 2, 3}, Vec3{4, 5, 6}, Vec3{-5, 7, 40}
+
+	// 方块
+	if got := rangeAll(t, s, c0); len(got) != 0 {
+		t.Fatalf("empty store has blocks %v", got)
+	}
+	if data, err := s.ChunkData(c0); err != nil || data != nil {
+		t.Fatalf("chunk data of empty chunk: %v %v", data, err)
+	}
+	blk := NewBlock(4)
+	if err := s.UpdateBlock(a, blk); err != nil {
+		t.Fatal(err)
+	}
+	// 保存后修改不影响保存的方块
+	blk.Type = 9
+	if err := s.UpdateBlocks(map[Vec3]*Block{b: NewBlock(5), c: NewBlock(6)}); err != nil {
+		t.Fatal(err)
+	}
+	got := rangeAll(t, s, c0)
+	if len(got) != 2 || got[a].Type != 4 || got[b].Type != 5 {
+		t.Fatalf("chunk %v: %v", c0, got)
+	}
+	if got := rangeAll(t, s, c1); len(got) != 1 || got[c].Type != 6 {
+		t.Fatalf("chunk %v: %v", c1, got)
+	}
+	if err := s.UpdateBlock(a, NewBlock(7)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.ChunkData(c0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeChunk(c0, data)
+	if err != nil || len(decoded) != 2 || decoded[a].Type != 7 {
+		t.Fatalf("chunk data: %v %v", decoded, err)
+	}
+	if cids := sortedChunks(t, s); len(cids) != 2 || cids[0] != c1 || cids[1] != c0 {
+		t.Fatalf("chunks %v", cids)
+	}
+	if err := s.DeleteBlocks([]Vec3{c}); err != nil {
+		t.Fatal(err)
+	}
+	if cids := sortedChunks(t, s); len(cids) != 1 || cids[0] != c0 {
+		t.Fatalf("chunks after delete %v", cids)
+	}
+
+	// chunk 版本和状态
+	if v := s.GetChunkVersion(c0); v != "" {
+		t.Fatalf("version %q", v)
+	}
+	if err := s.UpdateChunkVersion(c0, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if v := s.GetChunkVersion(c0); v != "v1" {
+		t.Fatalf("version %q", v)
+	}
+	keep := 3
+	if err := s.GetState("missing", &keep); err != nil || keep != 3 {
+		t.Fatalf("missing state: %d %v", keep, err)
+	}
+	if err := s.UpdateState("time", map[string]int{"tick": 42}); err != nil {
+		t.Fatal(err)
+	}
+	var st map[string]int
+	if err := s.GetState("time", &st); err != nil || st["tick"] != 42 {
+		t.Fatalf("state %v %v", st, err)
+	}
+
+	// 维度的方块和状态分开
+	ds, err := s.Dimension("nether")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rangeAll(t, ds, c0); len(got) != 0 {
+		t.Fatalf("dimension sees blocks %v", got)
+	}
+	if err := ds.UpdateBlock(a, NewBlock(8)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.UpdateState("time", map[string]int{"tick": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rangeAll(t, s, c0); got[a].Type != 7 {
+		t.Fatalf("dimension write leaked: %v", got[a])
+	}
+	if err := s.GetState("time", &st); err != nil || st["tick"] != 42 {
+		t.Fatalf("dimension state leaked: %v %v", st, err)
+	}
+	if def, err := s.Dimension(DefaultDimension); err != nil || rangeAll(t, def, c0)[a].Type != 7 {
+		t.Fatalf("default dimension: %v", err)
+	}
+
+	// 玩家按名字保存, 所有维度共用
+	if r, err := s.GetPlayer("alice"); err != nil || r != nil {
+		t.Fatalf("missing player: %v %v", r, err)
+	}
+	alice := &PlayerRecord{Name: "alice", Dimension: "nether", Pos: mgl32.Vec3{1, 20, -3}, Rx: -90, Ry: 12,
+		Flying: true, Health: 7, Inventory: Inventory{Selected: 5}}
+	if err := ds.UpdatePlayer(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdatePlayer(&PlayerRecord{Name: "bob", Health: MaxHealth}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := s.GetPlayer("alice"); err != nil || r == nil || *r != *alice {
+		t.Fatalf("player %v %v", r, err)
+	}
+
+	// 备份包含所有维度, 用同一个实现打开
+	path := filepath.Join(dir, "backup")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Backup(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 备份后的修改不在备份中
+	if err := s.UpdateBlock(b, NewBlock(9)); err != nil {
+		t.Fatal(err)
+	}
+	backend, _, err := parseStoreURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := backend.OpenSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	if got := rangeAll(t, snap, c0); len(got) != 2 || got[a].Type != 7 || got[b].Type != 5 {
+		t.Fatalf("snapshot blocks %v", got)
+	}
+	if err := snap.GetState("time", &st); err != nil || st["tick"] != 42 {
+		t.Fatalf("snapshot state %v %v", st, err)
+	}
+	sds, err := snap.Dimension("nether")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rangeAll(t, sds, c0); len(got) != 1 || got[a].Type != 8 {
+		t.Fatalf("snapshot dimension blocks %v", got)
+	}
+	if r, err := snap.GetPlayer("alice"); err != nil || r == nil || *r != *alice {
+		t.Fatalf("snapshot player %v %v", r, err)
+	}
+}
@@ -0,0 +1,136 @@
+package world
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// Structure 生成地形时随机放置的建筑模板
+type Structure struct {
+	Name string
+	// Blocks 相对于锚点, 锚点位于地面上第一层方块
+	Blocks map[Vec3]*Block
+	// Chance 每个 chunk 放置一个的概率
+	Chance float64
+
+	min, max Vec3
+}
+
+var (
+	structuresMutex sync.RWMutex
+	structures      = map[string]*Structure{}
+	// structuresLocked 生成过地形后不能再注册建筑
+	structuresLocked bool
+
+	ErrStructureLate = errors.New("structures must be registered before terrain is generated")
+)
+
+// RegisterStructure 注册建筑模板, 只能在生成地形之前调用. store 只保存对生成地形的修改,
+// 之后再增加建筑会改变已经修改过的 chunk
+func RegisterStructure(s *Structure) error {
+	s.min, s.max = Vec3{}, Vec3{}
+	first := true
+	for id := range s.Blocks {
+		if first {
+			s.min, s.max = id, id
+			first = false
+		}
+		s.min = Vec3{minInt(s.min.X, id.X), minInt(s.min.Y, id.Y), minInt(s.min.Z, id.Z)}
+		s.max = Vec3{maxInt(s.max.X, id.X), maxInt(s.max.Y, id.Y), maxInt(s.max.Z, id.Z)}
+	}
+	structuresMutex.Lock()
+	defer structuresMutex.Unlock()
+	if structuresLocked {
+		return ErrStructureLate
+	}
+	structures[s.Name] = s
+	return nil
+}
+
+func Structures() []*Structure {
+	structuresMutex.RLock()
+	defer structuresMutex.RUnlock()
+	var ss []*Structure
+	for _, s := range structures {
+		ss = append(ss, s)
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return ss[i].Name < ss[j].Name
+	})
+	return ss
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// structureRand 由种子, chunk 和建筑名字决定的随机数, 同一个世界每次生成结果相同
+func structureRand(seed int64, cid Vec3, name string, salt uint32) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write(encodeVec3(cid))
+	h.Write([]byte{byte(salt), byte(salt >> 8), byte(salt >> 16), byte(salt >> 24)})
+	if seed != 0 {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(seed))
+		h.Write(b[:])
+	}
+	return h.Sum32()
+}
+
+// anchor 返回 chunk 中建筑的锚点, 只在草地上放置
+func (s *Structure) anchor(g *Generator, cid Vec3) (Vec3, bool) {
+	if float64(structureRand(g.Seed, cid, s.Name, 0))/(1<<32) >= s.Chance {
+		return Vec3{}, false
+	}
+	x := cid.X*ChunkWidth + int(structureRand(g.Seed, cid, s.Name, 1)%ChunkWidth)
+	z := cid.Z*ChunkWidth + int(structureRand(g.Seed, cid, s.Name, 2)%ChunkWidth)
+	h, grass := g.height(x, z)
+	if !grass {
+		return Vec3{}, false
+	}
+	return Vec3{x, h, z}, true
+}
+
+// placeStructures 把锚点在附近 chunk 中的建筑落在 cid 内的部分加入 m
+func placeStructures(g *Generator, cid Vec3, m map[Vec3]*Block) {
+	x0, z0 := cid.X*ChunkWidth, cid.Z*ChunkWidth
+	structuresMutex.Lock()
+	structuresLocked = true
+	structuresMutex.Unlock()
+	for _, s := range Structures() {
+		if s.Chance <= 0 || len(s.Blocks) == 0 {
+			continue
+		}
+		// 锚点所在 chunk 的范围, 建筑可能跨越多个 chunk
+		minA := Vec3{x0 - s.max.X, 0, z0 - s.max.Z}.Chunkid()
+		maxA := Vec3{x0 + ChunkWidth - 1 - s.min.X, 0, z0 + ChunkWidth - 1 - s.min.Z}.Chunkid()
+		for ax := minA.X; ax <= maxA.X; ax++ {
+			for az := minA.Z; az <= maxA.Z; az++ {
+				anchor, ok := s.anchor(g, Vec3{ax, 0, az})
+				if !ok {
+					continue
+				}
+				for id, b := range s.Blocks {
+					id = id.Add(anchor)
+					if id.Chunkid() == cid {
+						m[id] = NewBlock(b.Type)
+					}
+				}
+			}
+		}
+	}
+}
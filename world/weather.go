@@ -0,0 +1,234 @@
+package world
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type WeatherType int
+
+const (
+	WeatherClear WeatherType = iota
+	WeatherRain
+	WeatherThunder
+)
+
+var weatherNames = map[WeatherType]string{
+	WeatherClear:   "clear",
+	WeatherRain:    "rain",
+	WeatherThunder: "thunder",
+}
+
+func (t WeatherType) String() string {
+	return weatherNames[t]
+}
+
+func ParseWeather(s string) (WeatherType, bool) {
+	for t, name := range weatherNames {
+		if name == s {
+			return t, true
+		}
+	}
+	return WeatherClear, false
+}
+
+// 每种天气持续的时间范围(秒)
+var weatherDurations = map[WeatherType][2]float64{
+	WeatherClear:   {300, 900},
+	WeatherRain:    {180, 480},
+	WeatherThunder: {120, 300},
+}
+
+type WeatherState struct {
+	Type      WeatherType
+	Remaining float64 // 当前天气剩余时间(秒)
+}
+
+type Weather struct {
+	mutex     sync.Mutex
+	state     WeatherState
+	intensity float32 // 0 晴天, 1 完全降雨, 用于平滑过渡
+	lightning time.Time
+	rand      *rand.Rand
+}
+
+func NewWeather(state WeatherState) *Weather {
+	w := &Weather{
+		state: state,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if state.Type != WeatherClear {
+		w.intensity = 1
+	}
+	if w.state.Remaining <= 0 {
+		w.state.Remaining = w.duration(state.Type)
+	}
+	return w
+}
+
+func (w *Weather) duration(t WeatherType) float64 {
+	d := weatherDurations[t]
+	return d[0] + w.rand.Float64()*(d[1]-d[0])
+}
+
+func (w *Weather) next() WeatherType {
+	switch w.state.Type {
+	case WeatherClear:
+		if w.rand.Intn(4) == 0 {
+			return WeatherThunder
+		}
+		return WeatherRain
+	case WeatherRain:
+		if w.rand.Intn(3) == 0 {
+			return WeatherThunder
+		}
+	}
+	return WeatherClear
+}
+
+func (w *Weather) State() WeatherState {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.state
+}
+
+func (w *Weather) SetState(state WeatherState) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if state.Remaining <= 0 {
+		state.Remaining = w.duration(state.Type)
+	}
+	w.state = state
+}
+
+func (w *Weather) Type() WeatherType {
+	return w.State().Type
+}
+
+// Intensity 降雨强度 [0,1]
+func (w *Weather) Intensity() float32 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.intensity
+}
+
+// Lightning 返回最近一次闪电的时间
+func (w *Weather) Lightning() time.Time {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.lightning
+}
+
+// Update 推进天气, 返回天气是否发生变化
+func (w *Weather) Update(dt float64) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	changed := false
+	w.state.Remaining -= dt
+	if w.state.Remaining <= 0 {
+		t := w.next()
+		w.state = WeatherState{Type: t, Remaining: w.duration(t)}
+		changed = true
+	}
+	w.animate(dt)
+	return changed
+}
+
+// Animate 只更新降雨强度和闪电, 不改变天气. 连接服务器时天气由服务器同步
+func (w *Weather) Animate(dt float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.animate(dt)
+}
+
+func (w *Weather) animate(dt float64) {
+	target := float32(0)
+	if w.state.Type != WeatherClear {
+		target = 1
+	}
+	step := float32(dt) * 0.1
+	if w.intensity < target {
+		w.intensity = min(target, w.intensity+step)
+	} else if w.intensity > target {
+		w.intensity = max(target, w.intensity-step)
+	}
+
+	// 雷暴时平均每 10 秒一次闪电
+	if w.state.Type == WeatherThunder && w.intensity > 0.5 && w.rand.Float64() < dt/10 {
+		w.lightning = time.Now()
+	}
+}
+
+// Temperature 返回某个位置的温度, 小于 0 时降雪
+func Temperature(id Vec3) float32 {
+	t := noise2(float32(id.X)*0.002, float32(id.Z)*0.002, 2, 0.5, 2)*2 - 0.6
+	// 越高越冷, 云层附近一定下雪
+	t -= float32(id.Y-12) * 0.01
+	return t
+}
+
+func IsSnowy(id Vec3) bool {
+	return Temperature(id) < 0
+}
+
+func (w *World) Weather() *Weather {
+	return w.weather
+}
+
+func (w *World) loadWeather() {
+	var state WeatherState
+	if w.store != nil {
+		err := w.store.GetState("weather", &state)
+		if err != nil {
+			log.Printf("load weather error:%s", err)
+		}
+	}
+	w.weather = NewWeather(state)
+}
+
+func (w *World) saveWeather() {
+	if w.store == nil {
+		return
+	}
+	err := w.store.UpdateState("weather", w.weather.State())
+	if err != nil {
+		log.Printf("save weather error:%s", err)
+	}
+}
+
+func init() {
+	usage := "/weather <clear|rain|thunder> [seconds] | /weather query"
+	RegisterCommand("weather", usage, func(ctx *CommandContext, args []string) (string, error) {
+		weather := ctx.World.Weather()
+		if len(args) == 1 && args[0] == "query" {
+			s := weather.State()
+			return fmt.Sprintf("%v %.0fs", s.Type, s.Remaining), nil
+		}
+		if len(args) < 1 || len(args) > 2 {
+			return "", UsageError(usage)
+		}
+		if ctx.World.Remote() {
+			return "", ErrRemote
+		}
+		t, ok := ParseWeather(args[0])
+		if !ok {
+			return "", UsageError(usage)
+		}
+		state := WeatherState{Type: t}
+		if len(args) == 2 {
+			d, err := strconv.ParseFloat(args[1], 64)
+			if err != nil {
+				return "", UsageError(usage)
+			}
+			state.Remaining = d
+		}
+		weather.SetState(state)
+		ctx.World.saveWeather()
+		ctx.World.Watcher.Emit(Event{Type: "Weather.Update", Data: weather.State()})
+		return fmt.Sprintf("weather %v", t), nil
+	})
+}
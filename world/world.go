@@ -2,12 +2,13 @@ package world
 
 import (
 	"log"
+	"strconv"
 	"sync"
+	"time"
 
 	"container/list"
 
 	"github.com/go-gl/mathgl/mgl32"
-	lru "github.com/hashicorp/golang-lru"
 )
 
 type Event struct {
@@ -44,28 +45,99 @@ func (w *Watcher) Emit(e interface{}) {
 	}
 }
 
+// Unwatch 停止接收事件并关闭 ch
+func (w *Watcher) Unwatch(ch chan interface{}) {
+	w.Lock()
+	defer w.Unlock()
+	for it := w.watched.Front(); it != nil; it = it.Next() {
+		if it.Value.(chan interface{}) == ch {
+			w.watched.Remove(it)
+			close(ch)
+			return
+		}
+	}
+}
+
 func (w *Watcher) Watch(size int) chan interface{} {
 	ch := make(chan interface{}, size)
+	w.Lock()
+	defer w.Unlock()
 	w.watched.PushBack(ch)
 	return ch
 }
 
 type World struct {
 	mutex   sync.Mutex
-	chunks  *lru.Cache // map[Vec3]*Chunk
+	chunks  *chunkCache
 	Watcher *Watcher
+
+	pinsMutex sync.Mutex
+	pins      map[interface{}]map[Vec3]*Chunk // PinArea pin 的 chunk
+
+	dim    *Dimension
+	store  Store
+	radius int
+	loader *ChunkLoader
+
+	clock     *Clock
+	weather   *Weather
+	lastSaved time.Time
+
+	spawnMutex sync.Mutex
+	spawn      *Vec3
+
+	editSessions sync.Map // map[*Player]*EditSession
+	players      sync.Map // map[string]*Player, Join 的玩家
+
+	remote func(id Vec3) error // 连接服务器时由 SetRemote 设置
 }
 
+// NewWorld 打开 -dim 指定的维度
 func NewWorld(renderRadius int) *World {
-	m := (renderRadius * 2) * (renderRadius * 2) * 4
-	world := &World{}
+	w, err := OpenWorld(*dimFlag, renderRadius)
+	if err != nil {
+		log.Panic(err)
+	}
+	return w
+}
+
+func newWorld(dim *Dimension, s Store, renderRadius int) *World {
+	world := &World{dim: dim, store: s, radius: renderRadius}
 	world.Watcher = NewWatcher()
-	world.chunks, _ = lru.NewWithEvict(m, world.EvictedChunk)
+	world.chunks = newChunkCache(int64(*chunkMemory) << 20)
+	world.pins = make(map[interface{}]map[Vec3]*Chunk)
+	world.loader = newChunkLoader(world, *chunkWorkers)
+	world.loadClock()
+	world.loadWeather()
+	world.lastSaved = time.Now()
 	return world
 }
 
-func (w *World) EvictedChunk(key interface{}, value interface{}) {
-	log.Printf("onEvicted Chunk %v", key)
+// Update 推进世界时间和天气, 定期保存世界状态. 连接服务器时天气只由服务器同步
+func (w *World) Update(dt float64) {
+	w.clock.Advance(dt)
+	if w.Remote() {
+		w.weather.Animate(dt)
+	} else if w.weather.Update(dt) {
+		w.saveWeather()
+		w.Watcher.Emit(Event{Type: "Weather.Update", Data: w.weather.State()})
+	}
+	if time.Since(w.lastSaved) > 10*time.Second {
+		w.lastSaved = time.Now()
+		w.Save()
+	}
+}
+
+func (w *World) Save() {
+	w.saveClock()
+	w.saveWeather()
+	w.savePlayers()
+	w.flushDirty()
+}
+
+// Close 停止后台加载 chunk 的 goroutine, 不再使用的 World 需要关闭
+func (w *World) Close() {
+	w.loader.Close()
 }
 
 func (w *World) Collide(from, to mgl32.Vec3) (mgl32.Vec3, bool) {
@@ -151,63 +223,43 @@ func (w *World) BlockChunk(block Vec3) *Chunk {
 	}
 	return chunk
 }
+
+// CreateBlock 在空的位置放置生成的方块, 只修改已加载的 chunk, 不保存
 func (w *World) CreateBlock(id Vec3, tp *Block) {
 	otp := w.Block(id)
 	if otp != nil {
 		return
 	}
+	chunk := w.BlockChunk(id)
+	if chunk == nil {
+		return
+	}
 	log.Printf("create %v", id)
-	w.updateBlock(id, tp)
+	chunk.generated.Store(id, paletteEntry{tp.Type, tp.Life})
+	chunk.add(id, tp)
+	w.Watcher.Emit(Event{Type: "Block.Update", Data: tp})
 }
+
+// Generate 在低处修改方块时填充周围, 只保存触发的位置 (见 generatedChunk)
 func (w *World) Generate(id Vec3) {
 	log.Printf("generate %v", id)
-	nw := typeSandBlock
-	if noise2(-float32(id.X)*0.1, float32(id.Y)*0.1, 4, 0.8, 2) > 0.6 {
-		nw = typeGrassBlock
-		width := 10
-		//length := 10
-		height := 5
-		y := id.Y - height
-		minY := id.Y - height
-		maxY := id.Y
-		minX := id.X - width/2
-		maxX := id.X + width/2
-		minZ := id.Z - width/2
-		maxZ := id.Z + width/2
-		for ; y <= maxY; y++ {
-			for x := minX; x <= id.X+width/2; x++ {
-				for z := id.Z - width/2; z <= id.Z+width/2; z++ {
-					if y == minY || y == maxY || x == minX || x == maxX || z == minZ || z == maxZ {
-						nw = typeGrassBlock
-					} else {
-						nw = TypeAir
-					}
-					w.CreateBlock(Vec3{x, y, z}, NewBlock(nw))
-				}
-			}
-		}
-	}
-	for x := id.X - 1; x <= id.X+1; x++ {
-		for y := id.Y - 1; y <= id.Y+1; y++ {
-			for z := id.Z - 1; z <= id.Z+1; z++ {
-				w.CreateBlock(Vec3{x, y, z}, NewBlock(nw))
-			}
-		}
-	}
+	w.addGenerate(id)
+	generateFill(id, w.CreateBlock)
 }
 func (w *World) updateBlock(id Vec3, tp *Block) {
 	chunk := w.BlockChunk(id)
 	if chunk != nil {
 		chunk.add(id, tp)
+		chunk.markDirty(map[Vec3]*Block{id: tp})
 	}
 	w.Watcher.Emit(Event{Type: "Block.Update", Data: tp})
 	//on change
-	store.UpdateBlock(id, tp)
+	w.saveChanges(map[Vec3]*Block{id: tp})
 
 }
 func (w *World) UpdateBlock(id Vec3, tp *Block) {
 	w.updateBlock(id, tp)
-	if id.Y <= 12 {
+	if id.Y <= 12 && w.dim.Generator.Type != GeneratorVoid {
 		w.Generate(id)
 	}
 }
@@ -217,29 +269,60 @@ func (w *World) HasBlock(id Vec3) bool {
 	return tp != nil && tp.BlockType().Model != DTAir
 }
 
+// Chunk 返回 chunk, 没有加载时在当前 goroutine 加载, 渲染时使用 TryChunk
 func (w *World) Chunk(id Vec3) *Chunk {
 	p, ok := w.loadChunk(id)
 	if ok {
 		return p
 	}
-	chunk := NewChunk(id)
-	blocks := makeChunkMap(id)
-	for block, tp := range blocks {
-		chunk.add(block, tp)
+	return w.loader.Load(id)
+}
+
+// SetRemote 连接服务器后设置, 加载 chunk 前先用 fetch 把服务器上的 chunk 更新到 store 中.
+// 已经加载的 chunk 卸载后重新加载
+func (w *World) SetRemote(fetch func(id Vec3) error) {
+	w.mutex.Lock()
+	w.remote = fetch
+	w.mutex.Unlock()
+	for _, c := range w.chunks.all() {
+		w.unloadChunk(c)
 	}
-	err := store.RangeBlocks(id, func(bid Vec3, w *Block) {
-		chunk.add(bid, w)
-	})
-	if err != nil {
-		log.Printf("fetch chunk(%v) from db error:%s", id, err)
+}
+
+// Remote 是否连接了服务器
+func (w *World) Remote() bool {
+	return w.remoteFetch() != nil
+}
+
+func (w *World) remoteFetch() func(id Vec3) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.remote
+}
+
+// ChunkVersion chunk 在 store 中的版本, 客户端用来判断是否需要重新获取
+func (w *World) ChunkVersion(id Vec3) string {
+	if w.store == nil {
+		return ""
+	}
+	return w.store.GetChunkVersion(id)
+}
+
+func (w *World) UpdateChunkVersion(id Vec3, version string) error {
+	if w.store == nil {
 		return nil
 	}
-	/*ClientFetchChunk(id, func(bid Vec3, w *Block) {
-		chunk.add(bid, w)
-		store.UpdateBlock(bid, w)
-	})*/
-	w.storeChunk(id, chunk)
-	return chunk
+	return w.store.UpdateChunkVersion(id, version)
+}
+
+// BumpChunkVersion 修改 chunk 后设置新的版本, 客户端缓存的旧版本不再有效
+func (w *World) BumpChunkVersion(id Vec3) (string, error) {
+	old := w.ChunkVersion(id)
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	for version == old {
+		version = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return version, w.UpdateChunkVersion(id, version)
 }
 
 func (w *World) Chunks(ids []Vec3) []*Chunk {
@@ -260,83 +343,14 @@ func (w *World) Chunks(ids []Vec3) []*Chunk {
 	return chunks
 }
 
-func makeChunkMap(cid Vec3) map[Vec3]*Block {
-	m := make(map[Vec3]*Block)
-	p, q := cid.X, cid.Z
-	for dx := 0; dx < ChunkWidth; dx++ {
-		for dz := 0; dz < ChunkWidth; dz++ {
-			x, z := p*ChunkWidth+dx, q*ChunkWidth+dz
-			f := noise2(float32(x)*0.01, float32(z)*0.01, 4, 0.5, 2)
-			g := noise2(float32(-x)*0.01, float32(-z)*0.01, 2, 0.9, 2)
-			mh := int(g*32 + 16)
-			h := int(f * float32(mh))
-			tb := typeGrassBlock
-			if h <= 12 {
-				h = 12
-				tb = typeSandBlock
-			}
-			// grass and sand
-			for y := 11; y < h; y++ {
-				m[Vec3{x, y, z}] = NewBlock(tb)
-			}
-
-			// flowers
-			if tb == typeGrassBlock {
-				if noise2(-float32(x)*0.1, float32(z)*0.1, 4, 0.8, 2) > 0.6 {
-					m[Vec3{x, h, z}] = NewBlock(typeGrass)
-				}
-				if noise2(float32(x)*0.05, float32(-z)*0.05, 4, 0.8, 2) > 0.7 {
-					tb := 18 + int(noise2(float32(x)*0.1, float32(z)*0.1, 4, 0.8, 2)*7)
-					m[Vec3{x, h, z}] = NewBlock(tb)
-				}
-			}
-
-			// tree
-			if tb == typeGrassBlock {
-				ok := true
-				if dx-4 < 0 || dz-4 < 0 ||
-					dx+4 > ChunkWidth || dz+4 > ChunkWidth {
-					ok = false
-				}
-				if ok && noise2(float32(x), float32(z), 6, 0.5, 2) > 0.79 {
-					for y := h + 3; y < h+8; y++ {
-						for ox := -3; ox <= 3; ox++ {
-							for oz := -3; oz <= 3; oz++ {
-								d := ox*ox + oz*oz + (y-h-4)*(y-h-4)
-								if d < 11 {
-									m[Vec3{x + ox, y, z + oz}] = NewBlock(typeLeaves)
-								}
-							}
-						}
-					}
-					for y := h; y < h+7; y++ {
-						m[Vec3{x, y, z}] = NewBlock(typeWood)
-					}
-				}
-			}
-
-			// cloud
-			for y := 64; y < 72; y++ {
-				if noise3(float32(x)*0.01, float32(y)*0.1, float32(z)*0.01, 8, 0.5, 2) > 0.69 {
-					m[Vec3{x, y, z}] = NewBlock(typeCloud)
-				}
-			}
-		}
-	}
-	return m
-}
-
 func (w *World) loadChunk(id Vec3) (*Chunk, bool) {
 	if w.chunks == nil {
 		panic("chunks is nil")
 	}
-	chunk, ok := w.chunks.Get(id)
-	if !ok {
-		return nil, false
-	}
-	return chunk.(*Chunk), true
+	return w.chunks.get(id)
 }
 
 func (w *World) storeChunk(id Vec3, chunk *Chunk) {
-	w.chunks.Add(id, chunk)
+	w.chunks.add(id, chunk)
+	w.evictChunks()
 }
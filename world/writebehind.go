@@ -0,0 +1,295 @@
+package world
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	writeBehind = flag.Bool("write-behind", true, "queue block writes and flush them to db in batches")
+	writeDelay  = flag.Duration("write-delay", time.Second, "max time a queued block write waits before flushing")
+	writeBatch  = flag.Int("write-batch", 4096, "flush as soon as this many blocks are queued")
+)
+
+// WriteStats 写入队列的统计
+type WriteStats struct {
+	Queued    int64 // 放入队列的方块写入
+	Coalesced int64 // 覆盖了队列中同一个方块的写入
+	Flushed   int64 // 写入数据库的方块
+	Batches   int64 // 写入数据库的批次
+	Errors    int64 // 失败的批次, 失败的写入会放回队列
+	Pending   int   // 队列中的方块
+}
+
+func (s WriteStats) String() string {
+	return fmt.Sprintf("pending %d, queued %d (%d coalesced), flushed %d in %d batches, %d errors",
+		s.Pending, s.Queued, s.Coalesced, s.Flushed, s.Batches, s.Errors)
+}
+
+func (s *WriteStats) add(o WriteStats) {
+	s.Queued += o.Queued
+	s.Coalesced += o.Coalesced
+	s.Flushed += o.Flushed
+	s.Batches += o.Batches
+	s.Errors += o.Errors
+	s.Pending += o.Pending
+}
+
+// WriteBehindStore 方块的写入先放入队列, 同一个方块只保留最后一次写入,
+// 后台按时间或数量批量写入 Store. 读取方块时合并队列中还没写入的修改
+type WriteBehindStore struct {
+	Store
+
+	delay time.Duration
+	batch int
+
+	mutex    sync.Mutex
+	pending  map[Vec3]*Block // nil 表示删除
+	inflight map[Vec3]*Block // 正在写入的批次
+	stats    WriteStats
+	children []*WriteBehindStore
+
+	flushing sync.RWMutex // Flush 时不能 RangeBlocks, RangeBlocks 之间可以并发
+	kick     chan bool
+	done     chan bool
+	stopped  chan bool
+}
+
+func NewWriteBehindStore(s Store, delay time.Duration, batch int) *WriteBehindStore {
+	ws := &WriteBehindStore{
+		Store:   s,
+		delay:   delay,
+		batch:   batch,
+		pending: make(map[Vec3]*Block),
+		kick:    make(chan bool, 1),
+		done:    make(chan bool),
+		stopped: make(chan bool),
+	}
+	go ws.loop()
+	return ws
+}
+
+func (s *WriteBehindStore) loop() {
+	defer close(s.stopped)
+	ticker := time.NewTicker(s.delay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.kick:
+		case <-s.done:
+			return
+		}
+		err := s.Flush()
+		if err != nil {
+			log.Printf("flush blocks error:%s", err)
+		}
+	}
+}
+
+func (s *WriteBehindStore) enqueue(blocks map[Vec3]*Block) {
+	s.mutex.Lock()
+	for id, b := range blocks {
+		if _, ok := s.pending[id]; ok {
+			s.stats.Coalesced++
+		}
+		if b != nil {
+			b = copyBlock(b)
+		}
+		s.pending[id] = b
+		s.stats.Queued++
+	}
+	n := len(s.pending)
+	s.mutex.Unlock()
+	if n >= s.batch {
+		select {
+		case s.kick <- true:
+		default:
+		}
+	}
+}
+
+func (s *WriteBehindStore) UpdateBlock(id Vec3, w *Block) error {
+	s.enqueue(map[Vec3]*Block{id: w})
+	return nil
+}
+
+func (s *WriteBehindStore) UpdateBlocks(blocks map[Vec3]*Block) error {
+	s.enqueue(blocks)
+	return nil
+}
+
+func (s *WriteBehindStore) DeleteBlocks(ids []Vec3) error {
+	blocks := make(map[Vec3]*Block, len(ids))
+	for _, id := range ids {
+		blocks[id] = nil
+	}
+	s.enqueue(blocks)
+	return nil
+}
+
+// RangeBlocks 读取数据库中的方块, 再用队列中的修改覆盖.
+// 读取时不能 Flush, 否则数据库中读到的是旧的数据, 而写入的批次已经不在队列中
+func (s *WriteBehindStore) RangeBlocks(cid Vec3, f func(bid Vec3, w *Block)) error {
+	s.flushing.RLock()
+	defer s.flushing.RUnlock()
+	blocks := make(map[Vec3]*Block)
+	err := s.Store.RangeBlocks(cid, func(id Vec3, b *Block) {
+		blocks[id] = b
+	})
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	for _, m := range []map[Vec3]*Block{s.inflight, s.pending} {
+		for id, b := range m {
+			if id.Chunkid() != cid {
+				continue
+			}
+			if b == nil {
+				delete(blocks, id)
+			} else {
+				blocks[id] = copyBlock(b)
+			}
+		}
+	}
+	s.mutex.Unlock()
+	for id, b := range blocks {
+		f(id, b)
+	}
+	return nil
+}
+
+// ChunkData 先写入队列, 返回数据库中的数据
+func (s *WriteBehindStore) ChunkData(cid Vec3) ([]byte, error) {
+	err := s.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.ChunkData(cid)
+}
+
+func (s *WriteBehindStore) Chunks() ([]Vec3, error) {
+	err := s.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Chunks()
+}
+
+// Backup 先写入所有维度的队列, 备份中包含之前的所有修改
+func (s *WriteBehindStore) Backup(w io.Writer) (int64, error) {
+	s.mutex.Lock()
+	children := s.children
+	s.mutex.Unlock()
+	for _, c := range append([]*WriteBehindStore{s}, children...) {
+		err := c.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return s.Store.Backup(w)
+}
+
+// Flush 把队列中的修改写入 Store
+func (s *WriteBehindStore) Flush() error {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+	s.mutex.Lock()
+	batch := s.pending
+	s.pending = make(map[Vec3]*Block)
+	s.inflight = batch
+	s.mutex.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	puts := make(map[Vec3]*Block)
+	var dels []Vec3
+	for id, b := range batch {
+		if b == nil {
+			dels = append(dels, id)
+		} else {
+			puts[id] = b
+		}
+	}
+	var err error
+	if len(puts) > 0 {
+		err = s.Store.UpdateBlocks(puts)
+	}
+	if err == nil && len(dels) > 0 {
+		err = s.Store.DeleteBlocks(dels)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.inflight = nil
+	if err != nil {
+		s.stats.Errors++
+		// 没有更新的写入时放回队列, 下次重试
+		for id, b := range batch {
+			if _, ok := s.pending[id]; !ok {
+				s.pending[id] = b
+			}
+		}
+		return err
+	}
+	s.stats.Flushed += int64(len(batch))
+	s.stats.Batches++
+	return nil
+}
+
+// Stats 返回统计, 包括 Dimension 返回的 store
+func (s *WriteBehindStore) Stats() WriteStats {
+	s.mutex.Lock()
+	st := s.stats
+	st.Pending = len(s.pending) + len(s.inflight)
+	children := s.children
+	s.mutex.Unlock()
+	for _, c := range children {
+		st.add(c.Stats())
+	}
+	return st
+}
+
+func (s *WriteBehindStore) Dimension(name string) (Store, error) {
+	ds, err := s.Store.Dimension(name)
+	if err != nil {
+		return nil, err
+	}
+	ws := NewWriteBehindStore(ds, s.delay, s.batch)
+	s.mutex.Lock()
+	s.children = append(s.children, ws)
+	s.mutex.Unlock()
+	return ws, nil
+}
+
+// Close 停止后台写入, 写入队列中所有的修改后关闭 Store
+func (s *WriteBehindStore) Close() {
+	close(s.done)
+	<-s.stopped
+	s.mutex.Lock()
+	children := s.children
+	s.mutex.Unlock()
+	for _, c := range children {
+		c.Close()
+	}
+	err := s.Flush()
+	if err != nil {
+		log.Printf("flush blocks on close error:%s", err)
+	}
+	log.Printf("block writes: %v", s.Stats())
+	s.Store.Close()
+}
+
+// StoreStats 返回 InitStore 打开的 store 的写入统计
+func StoreStats() WriteStats {
+	if ws, ok := store.(*WriteBehindStore); ok {
+		return ws.Stats()
+	}
+	return WriteStats{}
+}
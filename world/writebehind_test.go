@@ -0,0 +1,148 @@
+package world
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteBehindStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "world.db")
+	bs, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只有 Flush 和 Close 写入
+	s := NewWriteBehindStore(bs, time.Hour, 1000)
+
+	a, b := Vec3{1, 20, 1}, Vec3{2, 20, 1}
+	s.UpdateBlock(a, NewBlock(3))
+	s.UpdateBlock(a, NewBlock(4))
+	s.UpdateBlock(b, NewBlock(5))
+	got := make(map[Vec3]int)
+	s.RangeBlocks(a.Chunkid(), func(id Vec3, w *Block) {
+		got[id] = w.Type
+	})
+	if len(got) != 2 || got[a] != 4 || got[b] != 5 {
+		t.Fatalf("before flush %v", got)
+	}
+	st := s.Stats()
+	if st.Queued != 3 || st.Coalesced != 1 || st.Pending != 2 || st.Flushed != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	err = s.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.DeleteBlocks([]Vec3{b})
+	c := Vec3{-40, 30, 7}
+	s.UpdateBlocks(map[Vec3]*Block{c: NewBlock(6)})
+	got = make(map[Vec3]int)
+	s.RangeBlocks(a.Chunkid(), func(id Vec3, w *Block) {
+		got[id] = w.Type
+	})
+	if len(got) != 1 || got[a] != 4 {
+		t.Fatalf("after delete %v", got)
+	}
+	st = s.Stats()
+	if st.Flushed != 2 || st.Batches != 1 || st.Pending != 2 {
+		t.Fatalf("stats %+v", st)
+	}
+
+	// Close 写入队列中的修改
+	s.Close()
+	bs, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	got = make(map[Vec3]int)
+	for _, cid := range []Vec3{a.Chunkid(), c.Chunkid()} {
+		bs.RangeBlocks(cid, func(id Vec3, w *Block) {
+			got[id] = w.Type
+		})
+	}
+	if len(got) != 2 || got[a] != 4 || got[c] != 6 {
+		t.Fatalf("after close %v", got)
+	}
+}
+
+func TestWriteBehindBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bs, err := NewBoltStore(filepath.Join(dir, "world.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewWriteBehindStore(bs, time.Hour, 10)
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		s.UpdateBlock(Vec3{i, 20, 0}, NewBlock(3))
+	}
+	for i := 0; i < 100 && s.Stats().Flushed != 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := s.Stats(); st.Flushed != 10 || st.Batches != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+// pausedStore 读取数据库后等待 release, 这时可以 Flush
+type pausedStore struct {
+	Store
+	ranging chan bool
+	release chan bool
+}
+
+func (s *pausedStore) RangeBlocks(cid Vec3, f func(bid Vec3, w *Block)) error {
+	blocks := make(map[Vec3]*Block)
+	err := s.Store.RangeBlocks(cid, func(id Vec3, b *Block) {
+		blocks[id] = b
+	})
+	s.ranging <- true
+	<-s.release
+	for id, b := range blocks {
+		f(id, b)
+	}
+	return err
+}
+
+func TestWriteBehindRangeDuringFlush(t *testing.T) {
+	ps := &pausedStore{Store: NewMemStore(), ranging: make(chan bool), release: make(chan bool)}
+	s := NewWriteBehindStore(ps, time.Hour, 1000)
+	id := Vec3{1, 20, 1}
+	s.UpdateBlock(id, NewBlock(4))
+
+	got := make(chan map[Vec3]int)
+	go func() {
+		m := make(map[Vec3]int)
+		s.RangeBlocks(id.Chunkid(), func(id Vec3, w *Block) {
+			m[id] = w.Type
+		})
+		got <- m
+	}()
+	<-ps.ranging
+	flushed := make(chan error)
+	go func() { flushed <- s.Flush() }()
+	// 给 Flush 时间在读取中间写入
+	time.Sleep(20 * time.Millisecond)
+	ps.release <- true
+	if m := <-got; m[id] != 4 {
+		t.Fatalf("range during flush %v", m)
+	}
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}